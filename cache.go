@@ -0,0 +1,192 @@
+package jsonpath
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/marcfyk/go-jsonpath/internal/ast"
+	"github.com/marcfyk/go-jsonpath/internal/eval"
+)
+
+// Cache is a bounded cache of compiled queries, which evicts the least recently used query
+// when it is full.
+//
+// Queries are looked up by their jsonpath string. Spellings of the same query,
+// e.g. $.a and $['a'], are recognized by their canonical string and share one compiled form,
+// although each keeps its own spelling as its String.
+//
+// Queries that fail to compile are cached along with their error, so malformed queries
+// are only parsed once. Concurrent compiles of the same jsonpath string are collapsed into one.
+//
+// A Cache is safe for concurrent use by multiple goroutines.
+type Cache struct {
+	// config is the configuration every query in the Cache is compiled with.
+	config config
+	// capacity is the maximum number of entries.
+	capacity int
+
+	mu sync.Mutex
+	// entries are the elements of lru, by jsonpath string.
+	entries map[string]*list.Element
+	// lru is the list of entries, from the most to the least recently used.
+	lru *list.List
+	// programs are the compiled queries of the entries, by canonical string.
+	programs map[string]*program
+	// calls are the compiles in progress, by jsonpath string.
+	calls map[string]*call
+	// stats are the counters of lookups.
+	stats CacheStats
+}
+
+// CacheStats are the counters of a Cache.
+type CacheStats struct {
+	// Hits is the number of lookups served from the Cache,
+	// including lookups that waited for a concurrent compile of the same query.
+	Hits int64
+	// Misses is the number of lookups that compiled their query.
+	Misses int64
+	// Entries is the number of jsonpath strings in the Cache.
+	Entries int
+}
+
+// entry is a jsonpath string in the Cache, with the result of compiling it.
+type entry struct {
+	query string
+	// canonical is the canonical string of the query, if it compiled.
+	canonical string
+	q         *Query
+	err       error
+}
+
+// program is a compiled query shared by the entries with the same canonical string.
+type program struct {
+	program *eval.Program
+	// refs is the number of entries sharing the program.
+	refs int
+}
+
+// call is a compile in progress, which other lookups of the same jsonpath string wait for.
+type call struct {
+	done chan struct{}
+	q    *Query
+	err  error
+}
+
+// NewCache returns a Cache of at most capacity queries, which are compiled with the given options.
+// A capacity less than 1 is treated as 1.
+func NewCache(capacity int, options ...Option) *Cache {
+	return &Cache{
+		config:   newConfig(options),
+		capacity: max(capacity, 1),
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		programs: make(map[string]*program),
+		calls:    make(map[string]*call),
+	}
+}
+
+// Compile returns the compiled query of a jsonpath string from the Cache,
+// compiling and adding it if it is not in the Cache.
+//
+// The error of a query that fails to compile is returned on every lookup,
+// until the query is evicted.
+func (c *Cache) Compile(query string) (*Query, error) {
+	c.mu.Lock()
+	if e, ok := c.entries[query]; ok {
+		c.lru.MoveToFront(e)
+		c.stats.Hits++
+		entry := e.Value.(*entry)
+		c.mu.Unlock()
+		return entry.q, entry.err
+	}
+	if cl, ok := c.calls[query]; ok {
+		c.stats.Hits++
+		c.mu.Unlock()
+		<-cl.done
+		return cl.q, cl.err
+	}
+	cl := &call{done: make(chan struct{})}
+	c.calls[query] = cl
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	e := c.compile(query)
+	cl.q, cl.err = e.q, e.err
+
+	c.mu.Lock()
+	delete(c.calls, query)
+	c.add(e)
+	c.mu.Unlock()
+	close(cl.done)
+	return e.q, e.err
+}
+
+// compile compiles the query of an entry, reusing the compiled query of the same canonical string
+// if it is in the Cache.
+func (c *Cache) compile(query string) *entry {
+	tree, err := c.config.parse(query)
+	if err != nil {
+		return &entry{query: query, err: err}
+	}
+	canonical := ast.Format(tree)
+	c.mu.Lock()
+	p, ok := c.programs[canonical]
+	if ok {
+		// The entry is referenced now, so that the program is not evicted before the entry is added.
+		p.refs++
+	}
+	c.mu.Unlock()
+	if !ok {
+		compiled, err := c.config.compile(tree)
+		if err != nil {
+			return &entry{query: query, err: err}
+		}
+		c.mu.Lock()
+		if p, ok = c.programs[canonical]; ok {
+			// Another spelling of the query was compiled concurrently.
+			p.refs++
+		} else {
+			p = &program{program: compiled, refs: 1}
+			c.programs[canonical] = p
+		}
+		c.mu.Unlock()
+	}
+	return &entry{
+		query:     query,
+		canonical: canonical,
+		q:         &Query{query: query, program: p.program},
+	}
+}
+
+// add adds an entry, evicting the least recently used entries if the Cache is full.
+// c.mu must be held.
+func (c *Cache) add(e *entry) {
+	c.entries[e.query] = c.lru.PushFront(e)
+	for c.lru.Len() > c.capacity {
+		c.evict(c.lru.Back())
+	}
+}
+
+// evict removes an entry, along with its compiled query if no other entry shares it.
+// c.mu must be held.
+func (c *Cache) evict(el *list.Element) {
+	e := c.lru.Remove(el).(*entry)
+	delete(c.entries, e.query)
+	if e.err != nil {
+		return
+	}
+	p := c.programs[e.canonical]
+	p.refs--
+	if p.refs == 0 {
+		delete(c.programs, e.canonical)
+	}
+}
+
+// Stats returns the counters of the Cache.
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Entries = c.lru.Len()
+	return s
+}
@@ -0,0 +1,95 @@
+package jsonpath_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/marcfyk/go-jsonpath"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	c := jsonpath.NewCache(2)
+	q1, err := c.Compile("$.a")
+	assert.Nil(t, err)
+	q2, err := c.Compile("$.a")
+	assert.Nil(t, err)
+	assert.Same(t, q1, q2)
+	assert.Equal(t, jsonpath.CacheStats{Hits: 1, Misses: 1, Entries: 1}, c.Stats())
+
+	// Spellings of the same query keep their own string.
+	q3, err := c.Compile("$['a']")
+	assert.Nil(t, err)
+	assert.Equal(t, "$['a']", q3.String())
+	doc := decode(t, `{"a": 1}`)
+	assert.Equal(t, selectNodes(t, q1, doc), selectNodes(t, q3, doc))
+
+	// $.a is the least recently used, so it is evicted.
+	_, err = c.Compile("$.b")
+	assert.Nil(t, err)
+	assert.Equal(t, jsonpath.CacheStats{Hits: 1, Misses: 3, Entries: 2}, c.Stats())
+	q4, err := c.Compile("$.a")
+	assert.Nil(t, err)
+	assert.NotSame(t, q1, q4)
+	assert.Equal(t, jsonpath.CacheStats{Hits: 1, Misses: 4, Entries: 2}, c.Stats())
+}
+
+func TestCacheErrors(t *testing.T) {
+	c := jsonpath.NewCache(10)
+	_, err1 := c.Compile("$[")
+	assert.IsType(t, jsonpath.ErrUnexpectedToken{}, err1)
+	q, err2 := c.Compile("$[")
+	assert.Nil(t, q)
+	assert.Equal(t, err1, err2)
+	assert.Equal(t, jsonpath.CacheStats{Hits: 1, Misses: 1, Entries: 1}, c.Stats())
+}
+
+func TestCacheOptions(t *testing.T) {
+	c := jsonpath.NewCache(10, jsonpath.WithMaxResults(1))
+	q, err := c.Compile("$.*")
+	assert.Nil(t, err)
+	_, err = q.Select(decode(t, `[1, 2]`))
+	assert.Equal(t, jsonpath.ErrLimitExceeded{Limit: jsonpath.LimitResults, Max: 1}, err)
+}
+
+func TestCacheConcurrentCompiles(t *testing.T) {
+	c := jsonpath.NewCache(100)
+	queries := make([]string, 10)
+	for i := range queries {
+		queries[i] = fmt.Sprintf("$..[?@.id == %d]", i)
+	}
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, query := range queries {
+				_, err := c.Compile(query)
+				assert.Nil(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	// Every query is compiled once, however many goroutines look it up at the same time.
+	assert.Equal(t, jsonpath.CacheStats{Hits: 190, Misses: 10, Entries: 10}, c.Stats())
+}
+
+func BenchmarkCache(b *testing.B) {
+	query := "$..book[?@.price < 10 && @.category == 'fiction']"
+	b.Run("compile", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			jsonpath.MustCompile(query)
+		}
+	})
+	b.Run("cache", func(b *testing.B) {
+		c := jsonpath.NewCache(10)
+		b.ReportAllocs()
+		for range b.N {
+			if _, err := c.Compile(query); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,363 @@
+// Package cbordoc evaluates jsonpath queries against CBOR documents, as specified by RFC8949.
+//
+// CBOR is read as the JSON value it is equivalent to, following the conversion of section 6.1 of RFC8949:
+//   - Maps are objects, whose keys are text strings, byte strings converted as below,
+//     or integers named by their decimal representation. Maps with other keys are not supported.
+//   - Byte strings are base64url encoded strings without padding,
+//     or base64 or base16 encoded strings if they are tagged 22 or 23.
+//   - Bignums, i.e. byte strings tagged 2 or 3, and integers are numbers, which are compared exactly.
+//     Integers are int64, or uint64 if they are beyond the range of an int64,
+//     and integers beyond both are json.Number values.
+//   - Other tags are ignored, and their content is read in place of them.
+//
+// Simple values other than false, true and null, including undefined,
+// and NaN and infinite floats have no JSON equivalent, and fail with ErrUnsupported.
+package cbordoc
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/marcfyk/go-jsonpath"
+	"github.com/marcfyk/go-jsonpath/internal/codec"
+	"github.com/marcfyk/go-jsonpath/internal/model"
+)
+
+// Node is an item of a CBOR document, whose arrays and maps are decoded once a query reads them.
+// Offset returns the offset of the item in the document.
+type Node = codec.Node
+
+// Errors returned when reading a document.
+type (
+	ErrMalformed   = codec.ErrMalformed
+	ErrUnsupported = codec.ErrUnsupported
+)
+
+// Decode decodes a CBOR document, with maps as jsonpath.Objects,
+// which keep the order of their members.
+func Decode(data []byte) (jsonpath.Value, error) {
+	return codec.Decode(format{}, data)
+}
+
+// Parse returns the Node of a CBOR document, which queries read without decoding the document
+// up front, so the arrays and maps they do not select into are skipped over rather than decoded.
+// The document is validated once, so that queries cannot fail to read it.
+func Parse(data []byte) (*Node, error) {
+	return codec.Parse(format{}, data)
+}
+
+// Major types of items.
+const (
+	majorUint = iota
+	majorNegative
+	majorBytes
+	majorText
+	majorArray
+	majorMap
+	majorTag
+	majorSimple
+)
+
+// Tags that change how byte strings are read.
+const (
+	tagBignum         = 2
+	tagNegativeBignum = 3
+	tagBase64         = 22
+	tagBase16         = 23
+)
+
+// indefinite is the additional information of items of indefinite length.
+const indefinite = 31
+
+// format reads CBOR.
+type format struct{}
+
+func (f format) Head(data []byte, off int) (codec.Head, error) {
+	h, err := argument(data, off)
+	if err != nil {
+		return codec.Head{}, err
+	}
+	switch h.major {
+	case majorUint, majorNegative:
+		if h.indefinite {
+			return codec.Head{}, codec.ErrMalformed{Offset: off, Reason: "integer of indefinite length"}
+		}
+		return codec.Head{Kind: model.Number, Integer: true, End: h.end}, nil
+	case majorBytes, majorText:
+		end, err := stringEnd(data, off, h)
+		return codec.Head{Kind: model.String, End: end}, err
+	case majorArray, majorMap:
+		kind := model.Array
+		if h.major == majorMap {
+			kind = model.Object
+		}
+		if h.indefinite {
+			return codec.Head{Kind: kind, Len: -1, End: h.end}, nil
+		}
+		// Every element or member takes at least a byte.
+		if h.arg > uint64(len(data)-h.end) {
+			return codec.Head{}, codec.Truncated(off)
+		}
+		return codec.Head{Kind: kind, Len: int(h.arg), End: h.end}, nil
+	case majorTag:
+		// Tags are skipped over in a loop, so that long chains of them cannot overflow the stack.
+		// Only the innermost tag changes how its content is read.
+		for {
+			if h.indefinite {
+				return codec.Head{}, codec.ErrMalformed{Offset: off, Reason: "tag of indefinite length"}
+			}
+			next, err := argument(data, h.end)
+			if err != nil {
+				return codec.Head{}, err
+			}
+			if next.major != majorTag {
+				break
+			}
+			off, h = h.end, next
+		}
+		content, err := f.Head(data, h.end)
+		if err != nil {
+			return codec.Head{}, err
+		}
+		if content.Break {
+			return codec.Head{}, codec.ErrMalformed{Offset: off, Reason: "tag of a break"}
+		}
+		if (h.arg == tagBignum || h.arg == tagNegativeBignum) && content.Kind == model.String {
+			if data[h.end]>>5 != majorBytes {
+				return codec.Head{}, codec.ErrMalformed{Offset: off, Reason: "bignum that is not a byte string"}
+			}
+			return codec.Head{Kind: model.Number, Integer: true, End: content.End}, nil
+		}
+		return content, nil
+	default:
+		return simple(data, off, h)
+	}
+}
+
+// head is the initial byte of an item, with its argument.
+type head struct {
+	major byte
+	// arg is the argument, i.e. the value of an integer, the length of a string, array or map,
+	// the number of a tag, or the value of a simple value or float.
+	arg        uint64
+	indefinite bool
+	// info is the additional information of the initial byte.
+	info byte
+	// end is the offset after the argument.
+	end int
+}
+
+// argument reads the initial byte and argument of the item at offset, off.
+func argument(data []byte, off int) (head, error) {
+	if off >= len(data) {
+		return head{}, codec.Truncated(off)
+	}
+	h := head{major: data[off] >> 5, info: data[off] & 0x1f, end: off + 1}
+	switch {
+	case h.info < 24:
+		h.arg = uint64(h.info)
+	case h.info <= 27:
+		size := 1 << (h.info - 24)
+		if len(data)-h.end < size {
+			return head{}, codec.Truncated(off)
+		}
+		h.arg = uintN(data[h.end : h.end+size])
+		h.end += size
+	case h.info == indefinite:
+		h.indefinite = true
+	default:
+		return head{}, codec.ErrMalformed{Offset: off, Reason: fmt.Sprintf("reserved additional information %d", h.info)}
+	}
+	return h, nil
+}
+
+// stringEnd returns the offset after the byte or text string at offset, off, with the head, h.
+// Strings of indefinite length are chunks of definite length of the same major type, ended by a break.
+func stringEnd(data []byte, off int, h head) (int, error) {
+	if !h.indefinite {
+		return chunkEnd(data, off, h)
+	}
+	end := h.end
+	for {
+		if end < len(data) && data[end] == 0xff {
+			return end + 1, nil
+		}
+		chunk, err := argument(data, end)
+		if err != nil {
+			return 0, err
+		}
+		if chunk.major != h.major || chunk.indefinite {
+			return 0, codec.ErrMalformed{Offset: end, Reason: "chunk of another type in a string of indefinite length"}
+		}
+		if end, err = chunkEnd(data, end, chunk); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// chunkEnd returns the offset after the string of definite length at offset, off, with the head, h.
+func chunkEnd(data []byte, off int, h head) (int, error) {
+	if h.arg > uint64(len(data)-h.end) {
+		return 0, codec.Truncated(off)
+	}
+	end := h.end + int(h.arg)
+	if h.major == majorText && !utf8.Valid(data[h.end:end]) {
+		return 0, codec.ErrMalformed{Offset: off, Reason: "text string that is not valid UTF-8"}
+	}
+	return end, nil
+}
+
+// simple returns the head of the simple value or float at offset, off, with the head, h.
+func simple(data []byte, off int, h head) (codec.Head, error) {
+	switch h.info {
+	case 20, 21:
+		return codec.Head{Kind: model.Bool, End: h.end}, nil
+	case 22:
+		return codec.Head{Kind: model.Null, End: h.end}, nil
+	case 23:
+		return codec.Head{}, codec.ErrUnsupported{Offset: off, Value: "undefined"}
+	case 25, 26, 27:
+		if f := float(h); math.IsNaN(f) || math.IsInf(f, 0) {
+			return codec.Head{}, codec.ErrUnsupported{Offset: off, Value: fmt.Sprint(f)}
+		}
+		return codec.Head{Kind: model.Number, End: h.end}, nil
+	case indefinite:
+		return codec.Head{Break: true, End: h.end}, nil
+	default:
+		return codec.Head{}, codec.ErrUnsupported{Offset: off, Value: fmt.Sprintf("simple value %d", h.arg)}
+	}
+}
+
+func (format) Scalar(data []byte, off int) any {
+	h, _ := argument(data, off)
+	// tag is the innermost tag of the item.
+	var tag uint64
+	for h.major == majorTag {
+		tag = h.arg
+		h, _ = argument(data, h.end)
+	}
+	switch h.major {
+	case majorUint:
+		if h.arg > math.MaxInt64 {
+			return h.arg
+		}
+		return int64(h.arg)
+	case majorNegative:
+		if h.arg > math.MaxInt64 {
+			n := new(big.Int).SetUint64(h.arg)
+			return json.Number(n.Not(n).String())
+		}
+		return -1 - int64(h.arg)
+	case majorBytes:
+		b := []byte(content(data, h))
+		switch tag {
+		case tagBignum, tagNegativeBignum:
+			return bignum(b, tag == tagNegativeBignum)
+		case tagBase64:
+			return base64.StdEncoding.EncodeToString(b)
+		case tagBase16:
+			return hex.EncodeToString(b)
+		default:
+			return base64.RawURLEncoding.EncodeToString(b)
+		}
+	case majorText:
+		return content(data, h)
+	default:
+		switch h.info {
+		case 20, 21:
+			return h.info == 21
+		case 26:
+			return math.Float32frombits(uint32(h.arg))
+		case 25, 27:
+			return float(h)
+		default:
+			return nil
+		}
+	}
+}
+
+// content returns the content of the string with the head, h, joining the chunks of a string of indefinite length.
+func content(data []byte, h head) string {
+	if !h.indefinite {
+		return string(data[h.end : h.end+int(h.arg)])
+	}
+	var b strings.Builder
+	for off := h.end; data[off] != 0xff; {
+		chunk, _ := argument(data, off)
+		b.Write(data[chunk.end : chunk.end+int(chunk.arg)])
+		off = chunk.end + int(chunk.arg)
+	}
+	return b.String()
+}
+
+// bignum returns the number of the bytes of a bignum, which is -1 minus the number if it is negative.
+func bignum(b []byte, negative bool) any {
+	n := new(big.Int).SetBytes(b)
+	if negative {
+		n.Not(n)
+	}
+	switch {
+	case n.IsInt64():
+		return n.Int64()
+	case n.IsUint64():
+		return n.Uint64()
+	default:
+		return json.Number(n.String())
+	}
+}
+
+// float returns the value of the half, single or double precision float with the head, h.
+func float(h head) float64 {
+	switch h.info {
+	case 25:
+		return half(uint16(h.arg))
+	case 26:
+		return float64(math.Float32frombits(uint32(h.arg)))
+	default:
+		return math.Float64frombits(h.arg)
+	}
+}
+
+// half returns the value of a half precision float.
+func half(bits uint16) float64 {
+	exp := int(bits>>10) & 0x1f
+	mant := float64(bits & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if bits&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
+
+// uintN returns the big endian unsigned integer of 1, 2, 4 or 8 bytes.
+func uintN(b []byte) uint64 {
+	switch len(b) {
+	case 1:
+		return uint64(b[0])
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b))
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b))
+	default:
+		return binary.BigEndian.Uint64(b)
+	}
+}
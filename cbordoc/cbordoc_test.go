@@ -0,0 +1,130 @@
+package cbordoc_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/marcfyk/go-jsonpath"
+	"github.com/marcfyk/go-jsonpath/cbordoc"
+	"github.com/stretchr/testify/assert"
+)
+
+// encoded returns the bytes of hexadecimal text, which is split into items by spaces.
+func encoded(t *testing.T, text string) []byte {
+	b, err := hex.DecodeString(strings.ReplaceAll(text, " ", ""))
+	assert.Nil(t, err)
+	return b
+}
+
+// toJSON returns the JSON of v.
+func toJSON(t *testing.T, v any) string {
+	b, err := json.Marshal(v)
+	assert.Nil(t, err)
+	return string(b)
+}
+
+// document is a map of 3 members.
+const document = "a3" +
+	" 61 61 88" + // "a": [
+	" 01 20" + // 1, -1,
+	" c2 49 010000000000000000" + // 2(h'010000000000000000'),
+	" 42 6869 d6 42 6869" + // h'6869', 22(h'6869'),
+	" f9 3e00" + // 1.5 as a half precision float,
+	" 9f 01 02 ff" + // [_ 1, 2],
+	" 7f 62 6162 61 63 ff" + // (_ "ab", "c")]
+	" 01 f6" + // 1: null
+	" 61 6e 3b ffffffffffffffff" // "n": -18446744073709551616
+
+func TestDecode(t *testing.T) {
+	v, err := cbordoc.Decode(encoded(t, document))
+	assert.Nil(t, err)
+	assert.Equal(t, jsonpath.Object{
+		{Name: "a", Value: []any{
+			int64(1), int64(-1), json.Number("18446744073709551616"), "aGk", "aGk=", 1.5, []any{int64(1), int64(2)}, "abc",
+		}},
+		{Name: "1", Value: nil},
+		{Name: "n", Value: json.Number("-18446744073709551616")},
+	}, v)
+}
+
+func TestParse(t *testing.T) {
+	data := encoded(t, document)
+	decoded, err := cbordoc.Decode(data)
+	assert.Nil(t, err)
+	parsed, err := cbordoc.Parse(data)
+	assert.Nil(t, err)
+	indexed, err := jsonpath.Index(parsed)
+	assert.Nil(t, err)
+	queries := []string{
+		"$.*",
+		"$..*",
+		"$.a[?@ > 1]",
+		"$.a[?@ == 18446744073709551616]",
+		"$[?@ < -9223372036854775808]",
+		"$.a[6][1]",
+		"$['1']",
+	}
+	for _, query := range queries {
+		t.Run(query, func(t *testing.T) {
+			q := jsonpath.MustCompile(query)
+			expected, err := q.Select(decoded)
+			assert.Nil(t, err)
+			assert.NotEmpty(t, expected)
+			for _, doc := range []jsonpath.Value{parsed, indexed} {
+				nodes, err := q.Select(doc)
+				assert.Nil(t, err)
+				assert.Equal(t, toJSON(t, expected), toJSON(t, nodes))
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	cases := []struct {
+		name     string
+		data     string
+		expected error
+	}{
+		{"reserved", "1c", cbordoc.ErrMalformed{Offset: 0, Reason: "reserved additional information 28"}},
+		{"truncated", "82 83 01 01 01", cbordoc.ErrMalformed{Offset: 5, Reason: "unexpected end of data"}},
+		{"long array", "9b ffffffffffffffff", cbordoc.ErrMalformed{Offset: 0, Reason: "unexpected end of data"}},
+		{"trailing data", "01 02", cbordoc.ErrMalformed{Offset: 1, Reason: "unexpected data after the value"}},
+		{"break", "ff", cbordoc.ErrMalformed{Offset: 0, Reason: "unexpected break"}},
+		{"map without value", "bf 01 ff", cbordoc.ErrMalformed{Offset: 2, Reason: "map without the value of its last key"}},
+		{"chunk", "7f 41 61 ff", cbordoc.ErrMalformed{Offset: 1, Reason: "chunk of another type in a string of indefinite length"}},
+		{"invalid UTF-8", "61 ff", cbordoc.ErrMalformed{Offset: 0, Reason: "text string that is not valid UTF-8"}},
+		{"undefined", "81 f7", cbordoc.ErrUnsupported{Offset: 1, Value: "undefined"}},
+		{"simple value", "f8 20", cbordoc.ErrUnsupported{Offset: 0, Value: "simple value 32"}},
+		{"infinity", "f9 7c00", cbordoc.ErrUnsupported{Offset: 0, Value: "+Inf"}},
+		{"key", "a1 f5 01", cbordoc.ErrUnsupported{Offset: 1, Value: "map key that is not a string or integer"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := cbordoc.Decode(encoded(t, c.data))
+			assert.Equal(t, c.expected, err)
+			_, err = cbordoc.Parse(encoded(t, c.data))
+			assert.Equal(t, c.expected, err)
+		})
+	}
+}
+
+func TestTags(t *testing.T) {
+	// Long chains of tags are read without recursion, and the innermost tag is the one that applies.
+	data := append(bytes.Repeat([]byte{0xc1}, 3_000_000), encoded(t, "c2 49 010000000000000000")...)
+	v, err := cbordoc.Decode(data)
+	assert.Nil(t, err)
+	assert.Equal(t, json.Number("18446744073709551616"), v)
+	n, err := cbordoc.Parse(data)
+	assert.Nil(t, err)
+	nodes, err := jsonpath.MustCompile("$").Select(n)
+	assert.Nil(t, err)
+	assert.Equal(t, "18446744073709551616", toJSON(t, nodes[0].Value))
+
+	_, err = cbordoc.Decode(append(bytes.Repeat([]byte{0xc1}, 3), 0xdf, 0x01))
+	assert.Equal(t, cbordoc.ErrMalformed{Offset: 3, Reason: "tag of indefinite length"}, err)
+	_, err = cbordoc.Decode(encoded(t, "81 c1 c1 ff"))
+	assert.Equal(t, cbordoc.ErrMalformed{Offset: 2, Reason: "tag of a break"}, err)
+}
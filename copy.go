@@ -0,0 +1,68 @@
+package jsonpath
+
+import (
+	"maps"
+	"reflect"
+	"slices"
+
+	"github.com/marcfyk/go-jsonpath/internal/eval"
+)
+
+// SetCopy is like Set, but never modifies doc. It returns a new document, which shares every array and object
+// of doc that no replaced node is in, and has copies of the arrays and objects on the paths from the root
+// to the replaced nodes, which are shallow, so they share their other members and elements with doc.
+// It returns doc itself if no value is replaced.
+//
+// As doc is only read, SetCopy is safe to call with a doc that other goroutines read concurrently,
+// and returns ErrNotWritable, rather than a partly modified doc, if a node is in an array or object that Set cannot write into.
+func (q *Query) SetCopy(doc Value, value Value) (Value, error) {
+	return q.edit(doc, true, func(Location, []eval.Step, Value) (Value, error) {
+		return value, nil
+	})
+}
+
+// DeleteCopy is like Delete, but never modifies doc, and returns a new document with copies of the arrays and objects
+// on the paths from the root to the removed nodes, as SetCopy returns.
+func (q *Query) DeleteCopy(doc Value) (Value, []Location, error) {
+	return q.delete(doc, true)
+}
+
+// ApplyCopy is like Apply, but never modifies doc, and returns a new document with copies of the arrays and objects
+// on the paths from the root to the replaced nodes, as SetCopy returns. f must not modify the values it is passed
+// in place, but return new values to replace them with. The values f is passed for the ancestors of other
+// selected nodes are the copies, with their descendants already replaced.
+func (q *Query) ApplyCopy(doc Value, f func(loc Location, v Value) (Value, error)) (Value, error) {
+	return q.edit(doc, true, func(loc Location, _ []eval.Step, v Value) (Value, error) {
+		v, err := f(loc, v)
+		if err != nil {
+			return nil, ErrApply{Location: loc, Err: err}
+		}
+		return v, nil
+	})
+}
+
+// shallowCopy returns a copy of the array or object, v, at the location, loc, that shares its members or elements.
+func shallowCopy(v Value, loc []byte) (Value, error) {
+	switch x := v.(type) {
+	case map[string]any:
+		return maps.Clone(x), nil
+	case []any:
+		return slices.Clone(x), nil
+	case Object:
+		return slices.Clone(x), nil
+	}
+	rv := reflect.ValueOf(v)
+	switch {
+	case rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String && !rv.IsNil():
+		c := reflect.MakeMapWithSize(rv.Type(), rv.Len())
+		for it := rv.MapRange(); it.Next(); {
+			c.SetMapIndex(it.Key(), it.Value())
+		}
+		return c.Interface(), nil
+	case rv.Kind() == reflect.Slice:
+		c := reflect.MakeSlice(rv.Type(), rv.Len(), rv.Len())
+		reflect.Copy(c, rv)
+		return c.Interface(), nil
+	}
+	return nil, ErrNotWritable{Location: Location(loc), Type: reflect.TypeOf(v)}
+}
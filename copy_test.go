@@ -0,0 +1,129 @@
+package jsonpath_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/marcfyk/go-jsonpath"
+	"github.com/stretchr/testify/assert"
+)
+
+// same reports if the maps or slices, a and b, are the same map or share the same backing array.
+func same(a, b any) bool {
+	return reflect.ValueOf(a).UnsafePointer() == reflect.ValueOf(b).UnsafePointer()
+}
+
+func TestSetCopy(t *testing.T) {
+	cases := []struct {
+		query    string
+		value    any
+		expected string
+	}{
+		{"$.a.b", "x", `{"a": {"b": "x", "c": [1]}, "d": {"e": 1}, "f": [{"g": 1}, {"g": 2}]}`},
+		{"$.f[*].g", 0, `{"a": {"b": 1, "c": [1]}, "d": {"e": 1}, "f": [{"g": 0}, {"g": 0}]}`},
+		{"$..g", 0, `{"a": {"b": 1, "c": [1]}, "d": {"e": 1}, "f": [{"g": 0}, {"g": 0}]}`},
+		{"$.missing", 0, `{"a": {"b": 1, "c": [1]}, "d": {"e": 1}, "f": [{"g": 1}, {"g": 2}]}`},
+		{"$", 0, `0`},
+	}
+	const input = `{"a": {"b": 1, "c": [1]}, "d": {"e": 1}, "f": [{"g": 1}, {"g": 2}]}`
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			for _, doc := range []jsonpath.Value{decode(t, input), decodeOrdered(t, input)} {
+				v, err := jsonpath.MustCompile(c.query).SetCopy(doc, c.value)
+				assert.Nil(t, err)
+				assert.JSONEq(t, c.expected, marshal(t, v))
+				assert.JSONEq(t, input, marshal(t, doc))
+			}
+		})
+	}
+
+	// Only the containers on the path to the replaced node are copied.
+	doc := map[string]any{
+		"a": map[string]any{"b": 1, "c": []any{1}},
+		"d": map[string]any{"e": 1},
+	}
+	v, err := jsonpath.MustCompile("$.a.b").SetCopy(doc, 2)
+	assert.Nil(t, err)
+	copied := v.(map[string]any)
+	assert.False(t, same(doc, copied))
+	assert.False(t, same(doc["a"], copied["a"]))
+	assert.True(t, same(doc["a"].(map[string]any)["c"], copied["a"].(map[string]any)["c"]))
+	assert.True(t, same(doc["d"], copied["d"]))
+	assert.Equal(t, 1, doc["a"].(map[string]any)["b"])
+
+	// Nothing is copied if no value changes.
+	v, err = jsonpath.MustCompile("$.a.b").SetCopy(doc, 1)
+	assert.Nil(t, err)
+	assert.True(t, same(doc, v))
+
+	// Maps and slices of other types are copied too.
+	typed := map[string][]int{"a": {1, 2}, "b": {3}}
+	v, err = jsonpath.MustCompile("$.a[0]").SetCopy(typed, 9)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]int{"a": {9, 2}, "b": {3}}, v)
+	assert.Equal(t, map[string][]int{"a": {1, 2}, "b": {3}}, typed)
+	assert.True(t, same(typed["b"], v.(map[string][]int)["b"]))
+
+	_, err = jsonpath.MustCompile("$.a[0]").SetCopy(map[string][]int{"a": {1}}, "x")
+	var notWritable jsonpath.ErrNotWritable
+	assert.True(t, errors.As(err, &notWritable))
+}
+
+func TestDeleteCopy(t *testing.T) {
+	const input = `{"items": [{"id": 1}, {"id": 2, "tags": ["a", "b"]}, {"id": 3}], "meta": {"count": 3}}`
+	cases := []struct {
+		query    string
+		expected string
+		removed  []jsonpath.Location
+	}{
+		{"$.items[?@.id != 2]", `{"items": [{"id": 2, "tags": ["a", "b"]}], "meta": {"count": 3}}`, []jsonpath.Location{"$['items'][0]", "$['items'][2]"}},
+		{"$..tags[0]", `{"items": [{"id": 1}, {"id": 2, "tags": ["b"]}, {"id": 3}], "meta": {"count": 3}}`, []jsonpath.Location{"$['items'][1]['tags'][0]"}},
+		{"$.meta", `{"items": [{"id": 1}, {"id": 2, "tags": ["a", "b"]}, {"id": 3}]}`, []jsonpath.Location{"$['meta']"}},
+		{"$", `null`, []jsonpath.Location{"$"}},
+	}
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			for _, doc := range []jsonpath.Value{decode(t, input), decodeOrdered(t, input)} {
+				v, removed, err := jsonpath.MustCompile(c.query).DeleteCopy(doc)
+				assert.Nil(t, err)
+				assert.JSONEq(t, c.expected, marshal(t, v))
+				assert.Equal(t, c.removed, removed)
+				assert.JSONEq(t, input, marshal(t, doc))
+			}
+		})
+	}
+
+	doc := decode(t, input).(map[string]any)
+	v, _, err := jsonpath.MustCompile("$.items[0]").DeleteCopy(doc)
+	assert.Nil(t, err)
+	assert.True(t, same(doc["meta"], v.(map[string]any)["meta"]))
+	assert.Equal(t, doc["items"].([]any)[1], v.(map[string]any)["items"].([]any)[0])
+}
+
+func TestApplyCopy(t *testing.T) {
+	const input = `{"a": {"n": 1}, "b": [{"n": 2}, {"m": 3}]}`
+	doc := decode(t, input)
+	v, err := jsonpath.MustCompile("$..n").ApplyCopy(doc, func(_ jsonpath.Location, v jsonpath.Value) (jsonpath.Value, error) {
+		return v.(float64) * 10, nil
+	})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"a": {"n": 10}, "b": [{"n": 20}, {"m": 3}]}`, marshal(t, v))
+	assert.JSONEq(t, input, marshal(t, doc))
+	assert.True(t, same(doc.(map[string]any)["b"].([]any)[1], v.(map[string]any)["b"].([]any)[1]))
+
+	// Ancestors are passed their copies, with their descendants replaced.
+	nested := decode(t, `{"x": {"n": {"n": 1}}}`)
+	v, err = jsonpath.MustCompile("$..[?@.n]").ApplyCopy(nested, func(_ jsonpath.Location, v jsonpath.Value) (jsonpath.Value, error) {
+		return jsonpath.Object{{Name: "wrapped", Value: v}}, nil
+	})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"x": {"wrapped": {"n": {"wrapped": {"n": 1}}}}}`, marshal(t, v))
+	assert.JSONEq(t, `{"x": {"n": {"n": 1}}}`, marshal(t, nested))
+
+	fail := errors.New("fail")
+	_, err = jsonpath.MustCompile("$.a.n").ApplyCopy(doc, func(jsonpath.Location, jsonpath.Value) (jsonpath.Value, error) {
+		return nil, fail
+	})
+	assert.Equal(t, jsonpath.ErrApply{Location: "$['a']['n']", Err: fail}, err)
+}
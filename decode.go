@@ -0,0 +1,168 @@
+package jsonpath
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/marcfyk/go-jsonpath/internal/model"
+)
+
+// Object is a JSON object that keeps the order of its members,
+// which wildcards, filters and descendant segments select in that order.
+// Objects marshal to JSON with their members in order.
+//
+// Members are looked up by name by scanning them, and the first member with a name
+// is the member of that name. Decode never produces Objects with two members of the same name.
+type Object = model.Ordered
+
+// Member is a member of an Object.
+type Member = model.Member
+
+// ErrTrailingData is returned by Decode for data that continues after the JSON value.
+type ErrTrailingData struct {
+	// Offset is the offset of the data after the JSON value.
+	Offset int64
+}
+
+func (e ErrTrailingData) Error() string {
+	return fmt.Sprintf("unexpected data after the JSON value; found at offset:%d", e.Offset)
+}
+
+// Decode decodes the JSON value of data, keeping the order of object members.
+//
+// Values are decoded as encoding/json decodes them into an any, except that objects are Objects,
+// and numbers are json.Number, so that integers are read exactly. Of members with the same name,
+// the last one is kept, at the position of the first one, as encoding/json keeps the last one.
+// DecodeIJSON rejects such data instead.
+//
+// Values are decoded from a stack rather than by recursion. Data nested deeper than 10000 levels
+// is rejected with a *json.SyntaxError, as encoding/json rejects it.
+func Decode(data []byte) (Value, error) {
+	return decodeData(data, nil)
+}
+
+// decodeData decodes the JSON value of data, validating it with iv if it is not nil.
+func decodeData(data []byte, iv *validator) (Value, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	v, err := decode(d, iv)
+	if err != nil {
+		return nil, err
+	}
+	offset := d.InputOffset()
+	if _, err := d.Token(); err != io.EOF {
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrTrailingData{Offset: offset}
+	}
+	return v, nil
+}
+
+// decode decodes the next JSON value of d, validating its tokens with iv if it is not nil.
+func decode(d *json.Decoder, iv *validator) (Value, error) {
+	// stack are the arrays and objects being decoded, from the outermost one.
+	var stack []container
+	for {
+		start := d.InputOffset()
+		t, err := d.Token()
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n := len(stack); n > 0 && stack[n-1].isObject && !stack[n-1].named && t != json.Delim('}') {
+			// The decoder only returns strings in place of member names.
+			stack[n-1].name, stack[n-1].named = t.(string), true
+			if iv != nil {
+				iv.name(stack, start, d.InputOffset())
+			}
+			continue
+		}
+		var v Value
+		switch t {
+		case json.Delim('['):
+			stack = append(stack, container{array: []any{}})
+			continue
+		case json.Delim('{'):
+			stack = append(stack, container{object: Object{}, isObject: true})
+			continue
+		case json.Delim(']'), json.Delim('}'):
+			v = stack[len(stack)-1].value()
+			stack = stack[:len(stack)-1]
+		default:
+			if iv != nil {
+				iv.scalar(stack, t, start, d.InputOffset())
+			}
+			v = t
+		}
+		if len(stack) == 0 {
+			return v, nil
+		}
+		stack[len(stack)-1].add(v)
+	}
+}
+
+// container is an array or object being decoded.
+type container struct {
+	array    []any
+	object   Object
+	isObject bool
+	// names are the positions of the members of a large object, by name.
+	names map[string]int
+	// name is the name of the member being decoded, if named.
+	name  string
+	named bool
+}
+
+// indexedMembers is the number of members an object being decoded needs
+// for its members to be looked up by a map rather than by scanning them.
+const indexedMembers = 16
+
+// add adds the decoded value, v, as the next element or member.
+func (c *container) add(v Value) {
+	if !c.isObject {
+		c.array = append(c.array, v)
+		return
+	}
+	c.named = false
+	if i, ok := c.position(c.name); ok {
+		c.object[i].Value = v
+		return
+	}
+	if c.names != nil {
+		c.names[c.name] = len(c.object)
+	}
+	c.object = append(c.object, Member{Name: c.name, Value: v})
+	if c.names == nil && len(c.object) == indexedMembers {
+		c.names = make(map[string]int, 2*indexedMembers)
+		for i, m := range c.object {
+			c.names[m.Name] = i
+		}
+	}
+}
+
+// position returns the position of the member, name, of an object.
+func (c *container) position(name string) (int, bool) {
+	if c.names != nil {
+		i, ok := c.names[name]
+		return i, ok
+	}
+	for i, m := range c.object {
+		if m.Name == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// value returns the decoded array or object.
+func (c *container) value() Value {
+	if c.isObject {
+		return c.object
+	}
+	return c.array
+}
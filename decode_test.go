@@ -0,0 +1,174 @@
+package jsonpath_test
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/marcfyk/go-jsonpath"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecode(t *testing.T) {
+	v, err := jsonpath.Decode([]byte(`{"z": 1, "a": [true, null, "s"], "m": {}, "z": 12345678901234567890}`))
+	assert.Nil(t, err)
+	// The last of the members with the same name is kept, at the position of the first one.
+	assert.Equal(t, jsonpath.Object{
+		{Name: "z", Value: json.Number("12345678901234567890")},
+		{Name: "a", Value: []any{true, nil, "s"}},
+		{Name: "m", Value: jsonpath.Object{}},
+	}, v)
+	b, err := json.Marshal(v)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"z":12345678901234567890,"a":[true,null,"s"],"m":{}}`, string(b))
+
+	// Members of large objects are looked up by a map while decoding.
+	var members []string
+	for i := range 20 {
+		members = append(members, `"`+string(rune('a'+i))+`": 1`)
+	}
+	v, err = jsonpath.Decode([]byte(`{` + strings.Join(members, ",") + `, "a": 2}`))
+	assert.Nil(t, err)
+	assert.Len(t, v, 20)
+	assert.Equal(t, jsonpath.Member{Name: "a", Value: json.Number("2")}, v.(jsonpath.Object)[0])
+
+	v, err = jsonpath.Decode([]byte(strings.Repeat("[", 10_000) + strings.Repeat("]", 10_000)))
+	assert.Nil(t, err)
+	assert.NotNil(t, v)
+	_, err = jsonpath.Decode([]byte(strings.Repeat("[", 10_001) + strings.Repeat("]", 10_001)))
+	assert.IsType(t, &json.SyntaxError{}, err)
+
+	errors := []struct {
+		data     string
+		expected error
+	}{
+		{`{"a": 1} {}`, jsonpath.ErrTrailingData{Offset: 8}},
+		{`[1, 2`, io.ErrUnexpectedEOF},
+		{``, io.ErrUnexpectedEOF},
+	}
+	for _, e := range errors {
+		t.Run(e.data, func(t *testing.T) {
+			_, err := jsonpath.Decode([]byte(e.data))
+			assert.Equal(t, e.expected, err)
+		})
+	}
+	_, err = jsonpath.Decode([]byte(`{"a" 1}`))
+	assert.IsType(t, &json.SyntaxError{}, err)
+}
+
+func TestMemberOrder(t *testing.T) {
+	doc, err := jsonpath.Decode([]byte(`{"z": {"y": 1, "x": 2}, "b": [{"d": 3, "c": 4}], "a": 5}`))
+	assert.Nil(t, err)
+	cases := []struct {
+		query    string
+		expected []jsonpath.Location
+	}{
+		{"$.*", []jsonpath.Location{"$['z']", "$['b']", "$['a']"}},
+		{"$[?@ != 1]", []jsonpath.Location{"$['z']", "$['b']", "$['a']"}},
+		{"$..*", []jsonpath.Location{
+			"$['z']", "$['b']", "$['a']", "$['z']['y']", "$['z']['x']", "$['b'][0]", "$['b'][0]['d']", "$['b'][0]['c']",
+		}},
+		{"$..[?@ > 1]", []jsonpath.Location{"$['a']", "$['z']['x']", "$['b'][0]['d']", "$['b'][0]['c']"}},
+	}
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			for _, options := range [][]jsonpath.Option{nil, {jsonpath.WithParallelDescendants(1)}} {
+				q := jsonpath.MustCompile(c.query, options...)
+				assert.Equal(t, c.expected, locationsOf(selectNodes(t, q, doc)))
+				assert.Equal(t, c.expected, locationsOf(selectNodes(t, q, index(t, doc))))
+			}
+		})
+	}
+}
+
+func TestSortedKeys(t *testing.T) {
+	doc := decode(t, `{"z": {"y": 1, "x": 2}, "b": [{"d": 3, "c": 4}], "a": 5}`)
+	cases := []struct {
+		query    string
+		expected []jsonpath.Location
+	}{
+		{"$.*", []jsonpath.Location{"$['a']", "$['b']", "$['z']"}},
+		{"$[?@ != 1]", []jsonpath.Location{"$['a']", "$['b']", "$['z']"}},
+		{"$..*", []jsonpath.Location{
+			"$['a']", "$['b']", "$['z']", "$['b'][0]", "$['b'][0]['c']", "$['b'][0]['d']", "$['z']['x']", "$['z']['y']",
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			for _, options := range [][]jsonpath.Option{nil, {jsonpath.WithParallelDescendants(1)}} {
+				q := jsonpath.MustCompile(c.query, append(options, jsonpath.WithSortedKeys())...)
+				assert.Equal(t, c.expected, locationsOf(selectNodes(t, q, doc)))
+				assert.Equal(t, c.expected, locationsOf(selectNodes(t, q, index(t, doc))))
+			}
+		})
+	}
+	// Structs keep the order of their fields.
+	type point struct {
+		Y int `json:"y"`
+		X int `json:"x"`
+	}
+	q := jsonpath.MustCompile("$.*", jsonpath.WithSortedKeys())
+	assert.Equal(t, []jsonpath.Value{2, 1}, selectValues(t, q, point{Y: 2, X: 1}))
+}
+
+func TestDecodeIJSON(t *testing.T) {
+	v, err := jsonpath.DecodeIJSON([]byte(`{"a": [9007199254740991, -9007199254740991, 9.007199254740991e15, 9007199254740992.5, 1.5e-300, "\ud83d\ude00 é"]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, jsonpath.Object{{Name: "a", Value: []any{
+		json.Number("9007199254740991"), json.Number("-9007199254740991"), json.Number("9.007199254740991e15"),
+		json.Number("9007199254740992.5"), json.Number("1.5e-300"), "😀 é",
+	}}}, v)
+
+	cases := []struct {
+		data     string
+		expected []jsonpath.Problem
+	}{
+		{`{"a": 1, "b": {"c": 2, "c": 3}, "a": 4}`, []jsonpath.Problem{
+			{Kind: jsonpath.ProblemDuplicateName, Location: "$['b']['c']", Offset: 23},
+			{Kind: jsonpath.ProblemDuplicateName, Location: "$['a']", Offset: 32},
+		}},
+		{`[9007199254740992, -9007199254740992, 1e400, 18446744073709551616]`, []jsonpath.Problem{
+			{Kind: jsonpath.ProblemInexactNumber, Location: "$[0]", Offset: 1},
+			{Kind: jsonpath.ProblemInexactNumber, Location: "$[1]", Offset: 19},
+			{Kind: jsonpath.ProblemInexactNumber, Location: "$[2]", Offset: 38},
+			{Kind: jsonpath.ProblemInexactNumber, Location: "$[3]", Offset: 45},
+		}},
+		// Integers are found however they are written.
+		{`[9007199254740993.0, 1.0e16, -9.007199254740992E15, 1.5e300, 90071992547409920e-1]`, []jsonpath.Problem{
+			{Kind: jsonpath.ProblemInexactNumber, Location: "$[0]", Offset: 1},
+			{Kind: jsonpath.ProblemInexactNumber, Location: "$[1]", Offset: 21},
+			{Kind: jsonpath.ProblemInexactNumber, Location: "$[2]", Offset: 29},
+			{Kind: jsonpath.ProblemInexactNumber, Location: "$[3]", Offset: 52},
+			{Kind: jsonpath.ProblemInexactNumber, Location: "$[4]", Offset: 61},
+		}},
+		{"[\"\\ud800\", \"\\udc00\\ud800\", \"\\uffff\", \"\xff\", \"\xed\xa0\x80\", \"\\ufdd0\"]", []jsonpath.Problem{
+			{Kind: jsonpath.ProblemInvalidUnicode, Location: "$[0]", Offset: 1},
+			{Kind: jsonpath.ProblemInvalidUnicode, Location: "$[1]", Offset: 11},
+			{Kind: jsonpath.ProblemInvalidUnicode, Location: "$[2]", Offset: 27},
+			{Kind: jsonpath.ProblemInvalidUnicode, Location: "$[3]", Offset: 37},
+			{Kind: jsonpath.ProblemInvalidUnicode, Location: "$[4]", Offset: 42},
+			{Kind: jsonpath.ProblemInvalidUnicode, Location: "$[5]", Offset: 49},
+		}},
+		{"{\"\\udfff\": {\"x\\uD800\": 1}}", []jsonpath.Problem{
+			{Kind: jsonpath.ProblemInvalidUnicode, Location: "$['\ufffd']", Offset: 1},
+			{Kind: jsonpath.ProblemInvalidUnicode, Location: "$['\ufffd']['x\ufffd']", Offset: 12},
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.data, func(t *testing.T) {
+			_, err := jsonpath.DecodeIJSON([]byte(c.data))
+			assert.Equal(t, jsonpath.ErrNotIJSON{Problems: c.expected}, err)
+		})
+	}
+	_, err = jsonpath.DecodeIJSON([]byte(`{"a": 1, "a": 2}`))
+	assert.EqualError(t, err, "data is not I-JSON: duplicate member name at $['a']; found at offset:9")
+	_, err = jsonpath.DecodeIJSON([]byte(`{"a": 1, "a": 2, "a": 3}`))
+	assert.EqualError(t, err, "data is not I-JSON: duplicate member name at $['a']; found at offset:9, and 1 more problem")
+	_, err = jsonpath.DecodeIJSON([]byte(`{"a": 1, "a": 2, "a": 3, "a": 4}`))
+	assert.EqualError(t, err, "data is not I-JSON: duplicate member name at $['a']; found at offset:9, and 2 more problems")
+
+	// Documents that are not JSON fail as they fail to decode.
+	_, err = jsonpath.DecodeIJSON([]byte(`[1,]`))
+	assert.IsType(t, &json.SyntaxError{}, err)
+}
@@ -0,0 +1,47 @@
+package jsonpath
+
+import "github.com/marcfyk/go-jsonpath/internal/model"
+
+// Document is a value of a document tree that queries read through its methods,
+// rather than by its Go type. Implementing Document plugs trees other than Go values
+// into evaluation, e.g. a custom DOM, a parsed YAML file or the rows of a database query.
+//
+// The methods are called according to the Kind of the Document:
+//   - Len returns the number of elements of an array or members of an object.
+//   - Index returns the element of an array at an index from 0 up to Len.
+//   - Member returns the member of an object by name, or false if it has none by that name.
+//   - Keys returns the names of the members of an object, in the order they are selected in.
+//   - Scalar returns the value of a scalar: nil for null, a bool for a boolean,
+//     any Go number or json.Number for a number, and a string for a string.
+//
+// Elements and members are either Documents themselves or any other Value,
+// and the values of the nodes a query selects are the elements and members as they are returned.
+type Document = model.Node
+
+// MemberVisitor is implemented by Documents of objects that visit all of their members in one pass,
+// which queries call to visit the members of an object, e.g. for wildcards, rather than
+// calling Member for every name that Keys returns.
+type MemberVisitor = model.MemberVisitor
+
+// Kind is the JSON type of a Document.
+type Kind = model.Kind
+
+// JSON types of a Document.
+// KindInvalid is the type of values that are not JSON values, which queries never select into.
+const (
+	KindInvalid = model.Invalid
+	KindNull    = model.Null
+	KindBool    = model.Bool
+	KindNumber  = model.Number
+	KindString  = model.String
+	KindArray   = model.Array
+	KindObject  = model.Object
+)
+
+// Adapt returns the Document of a Value, which reads it the way queries read it, see Value.
+//
+// Adapt lets Documents delegate to the default reading of Go values,
+// e.g. for the parts of a custom tree that are plain Go values.
+func Adapt(v Value) Document {
+	return model.Of(v)
+}
@@ -0,0 +1,110 @@
+package jsonpath_test
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/marcfyk/go-jsonpath"
+	"github.com/stretchr/testify/assert"
+)
+
+// table is a Document of the rows of a database query, as an array of objects.
+type table struct {
+	columns []string
+	rows    [][]any
+}
+
+func (t *table) Kind() jsonpath.Kind       { return jsonpath.KindArray }
+func (t *table) Len() int                  { return len(t.rows) }
+func (t *table) Index(i int) any           { return row{table: t, i: i} }
+func (t *table) Member(string) (any, bool) { return nil, false }
+func (t *table) Keys() []string            { return nil }
+func (t *table) Scalar() any               { return nil }
+
+// row is a Document of a row of a table, as an object of its columns.
+type row struct {
+	table *table
+	i     int
+}
+
+func (r row) Kind() jsonpath.Kind { return jsonpath.KindObject }
+func (r row) Len() int            { return len(r.table.columns) }
+func (r row) Index(int) any       { return nil }
+func (r row) Keys() []string      { return r.table.columns }
+func (r row) Scalar() any         { return nil }
+
+func (r row) Member(name string) (any, bool) {
+	c := slices.Index(r.table.columns, name)
+	if c < 0 {
+		return nil, false
+	}
+	return r.table.rows[r.i][c], true
+}
+
+// decimal is a Document of a number column.
+type decimal string
+
+func (d decimal) Kind() jsonpath.Kind       { return jsonpath.KindNumber }
+func (d decimal) Len() int                  { return 0 }
+func (d decimal) Index(int) any             { return nil }
+func (d decimal) Member(string) (any, bool) { return nil, false }
+func (d decimal) Keys() []string            { return nil }
+func (d decimal) Scalar() any               { return json.Number(d) }
+
+func TestDocument(t *testing.T) {
+	users := &table{
+		columns: []string{"name", "age", "balance", "tags"},
+		rows: [][]any{
+			{"ada", 36, decimal("10.50"), []string{"admin"}},
+			{"alan", 41, decimal("-3"), nil},
+			{"grace", 29, decimal("7"), []string{"admin", "ops"}},
+		},
+	}
+	cases := []struct {
+		query    string
+		expected []jsonpath.Value
+	}{
+		{"$[*].name", []jsonpath.Value{"ada", "alan", "grace"}},
+		{"$[?@.age > 30].name", []jsonpath.Value{"ada", "alan"}},
+		{"$[?@.balance < 0].name", []jsonpath.Value{"alan"}},
+		{"$[?@.balance == 10.5].name", []jsonpath.Value{"ada"}},
+		{"$[?@.tags[?@ == 'ops']].name", []jsonpath.Value{"grace"}},
+		{"$[-1].*", []jsonpath.Value{"grace", 29, decimal("7"), []string{"admin", "ops"}}},
+		{"$..[?@ == 'admin']", []jsonpath.Value{"admin", "admin"}},
+		{"$[?length(@) == 4 && count(@.tags[*]) == 0].name", []jsonpath.Value{"alan"}},
+	}
+	indexed := index(t, users)
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			q := jsonpath.MustCompile(c.query)
+			// Members are selected in the order of Keys.
+			assert.Equal(t, c.expected, selectValues(t, q, users))
+			assert.Equal(t, c.expected, selectValues(t, q, indexed))
+		})
+	}
+	nodes := selectNodes(t, jsonpath.MustCompile("$[1]['balance', 'age']"), users)
+	assert.Equal(t, []jsonpath.Location{"$[1]['balance']", "$[1]['age']"}, locationsOf(nodes))
+}
+
+func TestAdapt(t *testing.T) {
+	type user struct {
+		Name string `json:"name"`
+		Age  int    `json:"age,omitempty"`
+	}
+	d := jsonpath.Adapt([]any{user{Name: "ada"}, nil})
+	assert.Equal(t, jsonpath.KindArray, d.Kind())
+	assert.Equal(t, 2, d.Len())
+	u := jsonpath.Adapt(d.Index(0))
+	assert.Equal(t, jsonpath.KindObject, u.Kind())
+	assert.Equal(t, []string{"name"}, u.Keys())
+	name, ok := u.Member("name")
+	assert.True(t, ok)
+	assert.Equal(t, "ada", jsonpath.Adapt(name).Scalar())
+	assert.Equal(t, jsonpath.KindNull, jsonpath.Adapt(d.Index(1)).Kind())
+	assert.Equal(t, jsonpath.KindInvalid, jsonpath.Adapt(func() {}).Kind())
+
+	// Adapted values are Documents, which read the same as the values they adapt.
+	q := jsonpath.MustCompile("$[?@.name == 'ada']")
+	assert.Equal(t, selectNodes(t, q, d), selectNodes(t, q, []any{user{Name: "ada"}, nil}))
+}
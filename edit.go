@@ -0,0 +1,532 @@
+package jsonpath
+
+import (
+	"cmp"
+	"fmt"
+	"reflect"
+	"slices"
+
+	"github.com/marcfyk/go-jsonpath/internal/ast"
+	"github.com/marcfyk/go-jsonpath/internal/eval"
+	"github.com/marcfyk/go-jsonpath/internal/model"
+)
+
+// ErrNotWritable is the error type when a value cannot be written into an array or object,
+// because it is not a value that can be modified, e.g. a struct, a Document or an IndexedDocument,
+// or because it cannot hold the value, e.g. a string written into a map[string]int.
+type ErrNotWritable struct {
+	// Location is the location of the array or object.
+	Location Location
+	Type     reflect.Type
+}
+
+func (e ErrNotWritable) Error() string {
+	return fmt.Sprintf("value cannot be written into:%v; found at location:%s", e.Type, e.Location)
+}
+
+// ErrIndexOutOfRange is the error type when SetOrCreate or Marshal would extend an array with more than
+// MaxArrayExtension null elements to reach an index.
+type ErrIndexOutOfRange struct {
+	// Location is the location of the array.
+	Location Location
+	Index    int
+}
+
+func (e ErrIndexOutOfRange) Error() string {
+	return fmt.Sprintf("index is too far past the end of the array:%d; found at location:%s", e.Index, e.Location)
+}
+
+// MaxArrayExtension is the maximum number of null elements SetOrCreate and Marshal add to an array
+// before the element at the index of a query, so that queries with large indices, e.g. $[9007199254740991],
+// fail rather than allocate arrays of that length.
+const MaxArrayExtension = 1024
+
+// ErrNotSingular is the error type when a query must be a singular query, but is not.
+type ErrNotSingular struct {
+	Query string
+}
+
+func (e ErrNotSingular) Error() string {
+	return fmt.Sprintf("query is not singular:%s", e.Query)
+}
+
+// ErrApply is the error type when the function of Apply fails for a node.
+type ErrApply struct {
+	// Location is the location of the node.
+	Location Location
+	Err      error
+}
+
+func (e ErrApply) Error() string {
+	return fmt.Sprintf("apply function failed:%v; found at location:%s", e.Err, e.Location)
+}
+
+func (e ErrApply) Unwrap() error {
+	return e.Err
+}
+
+// Apply replaces the value of every node the Query selects from doc with the value f returns for it,
+// and returns doc, or the value f returns for the root if the Query selects it.
+//
+// The document is walked once, calling f for the descendants of a node before the node,
+// so that f is passed the value of a node with its descendants already replaced.
+// Locations are the locations of the nodes in doc, which replacing values does not change.
+// Values are replaced in place, in the same arrays and objects that Set writes into.
+//
+// If f fails, Apply stops and returns ErrApply with the location of the node, and the values replaced before it.
+func (q *Query) Apply(doc Value, f func(loc Location, v Value) (Value, error)) (Value, error) {
+	return q.edit(doc, false, func(loc Location, _ []eval.Step, v Value) (Value, error) {
+		v, err := f(loc, v)
+		if err != nil {
+			return nil, ErrApply{Location: loc, Err: err}
+		}
+		return v, nil
+	})
+}
+
+// Set replaces the values of the nodes the Query selects from doc with value, and returns doc,
+// or value if the Query selects the root. value is not copied, so every node it replaces shares it.
+//
+// Values are replaced in place, inside the arrays and objects of doc, which are a map[string]any, []any or Object,
+// or any other map with string keys, or slice, that can hold value. Set returns ErrNotWritable,
+// with doc partly modified, if value must be written into any other array or object, e.g. a struct.
+// Descendants are replaced before their ancestors, so if both a node and one of its descendants
+// are selected, the node is replaced by value.
+func (q *Query) Set(doc Value, value Value) (Value, error) {
+	return q.edit(doc, false, func(Location, []eval.Step, Value) (Value, error) {
+		return value, nil
+	})
+}
+
+// SetOrCreate replaces the value of the node the singular Query selects from doc with value, like Set,
+// or adds it if the Query selects no node, and returns doc. The objects and arrays on the way to the node
+// are created if they are missing or null, as Objects and []any values, and arrays are extended
+// to the index with null elements. Negative indices only select elements of arrays that have them.
+//
+// SetOrCreate returns ErrNotSingular if the Query is not a singular query,
+// ErrIndexOutOfRange if an array must be extended with more than MaxArrayExtension null elements,
+// and ErrNotWritable if a member or element must be added to a value that is not an object or array.
+func (q *Query) SetOrCreate(doc Value, value Value) (Value, error) {
+	steps, ok := q.program.Path()
+	if !ok {
+		return nil, ErrNotSingular{Query: q.query}
+	}
+	if _, ok := doc.(*IndexedDocument); ok && len(steps) > 0 {
+		return nil, ErrNotWritable{Location: "$", Type: reflect.TypeOf(doc)}
+	}
+	return create(doc, true, steps, value, []byte("$"))
+}
+
+// create returns the value, v, at the location, loc, with value added at the steps from it.
+// exists reports if v exists, rather than being created.
+func create(v Value, exists bool, steps []eval.Step, value Value, loc []byte) (Value, error) {
+	if len(steps) == 0 {
+		return value, nil
+	}
+	s, m := steps[0], model.Of(v)
+	if !exists || m.Kind() == model.Null {
+		if s.IsIndex {
+			v = []any{}
+		} else {
+			v = Object{}
+		}
+		m = model.Of(v)
+	}
+	switch {
+	case s.IsIndex && m.Kind() == model.Array:
+		if s.Index < 0 {
+			s.Index += m.Len()
+			if s.Index < 0 {
+				return nil, ErrNotWritable{Location: Location(loc), Type: reflect.TypeOf(v)}
+			}
+		}
+		if s.Index-m.Len() > MaxArrayExtension {
+			return nil, ErrIndexOutOfRange{Location: Location(loc), Index: s.Index}
+		}
+		exists := s.Index < m.Len()
+		var e Value
+		if exists {
+			e = m.Index(s.Index)
+		}
+		e, err := create(e, exists, steps[1:], value, ast.AppendIndex(loc, s.Index))
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return writeChild(v, s, e, loc)
+		}
+		return grow(v, s.Index, e, loc)
+	case !s.IsIndex && m.Kind() == model.Object:
+		e, exists := m.Member(s.Name)
+		e, err := create(e, exists, steps[1:], value, ast.AppendName(loc, s.Name))
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return writeChild(v, s, e, loc)
+		}
+		return addMember(v, s.Name, e, loc)
+	default:
+		return nil, ErrNotWritable{Location: Location(loc), Type: reflect.TypeOf(v)}
+	}
+}
+
+// Delete removes the nodes the Query selects from doc, from the arrays and objects they are in,
+// and returns doc, along with the locations of the nodes it removes in the order the Query selects them.
+// It returns a nil document if the Query selects the root.
+//
+// Elements of the same array are removed from the highest index down, so that every element that is removed
+// is the element the Query selected, rather than one that has moved into its place. If both a node and
+// one of its descendants are selected, the descendant is removed along with the node, and only the location
+// of the node is returned. Nodes are removed in place, from the same arrays and objects that Set writes into,
+// and Delete returns ErrNotWritable, with doc partly modified, if a node is in any other array or object.
+func (q *Query) Delete(doc Value) (Value, []Location, error) {
+	return q.delete(doc, false)
+}
+
+// delete removes the nodes the Query selects from doc, and returns doc, along with the locations of the nodes.
+// If copying, the arrays and objects that nodes are removed from are copied, rather than modified.
+func (q *Query) delete(doc Value, copying bool) (Value, []Location, error) {
+	t, paths, err := q.edits(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	if t == nil {
+		return doc, nil, nil
+	}
+	removed := t.outermost(paths)
+	if t.selected {
+		return nil, removed, nil
+	}
+	v, err := t.remove(doc, []byte("$"), copying)
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, removed, nil
+}
+
+// edit calls f with the location, steps and value of every node the Query selects from doc, deepest first,
+// and replaces the value of the node with the value f returns. It returns doc, or the value of its root.
+// If copying, the arrays and objects that values are replaced in are copied, rather than modified.
+func (q *Query) edit(doc Value, copying bool, f editFunc) (Value, error) {
+	t, _, err := q.edits(doc)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return doc, nil
+	}
+	return t.apply(doc, []byte("$"), nil, copying, f)
+}
+
+// editFunc returns the value that replaces the value, v, of a node, with the location, loc, at the steps from the root.
+type editFunc func(loc Location, steps []eval.Step, v Value) (Value, error)
+
+// edits returns the edits of the nodes the Query selects from doc, along with the steps to the nodes,
+// or nil edits if the Query selects no node. The steps are recorded by evaluating the Query once,
+// rather than parsed from the locations of the nodes.
+func (q *Query) edits(doc Value) (*edits, [][]eval.Step, error) {
+	if _, ok := doc.(*IndexedDocument); ok {
+		nodes, err := q.Select(doc)
+		if err != nil || len(nodes) == 0 {
+			return nil, nil, err
+		}
+		return nil, nil, ErrNotWritable{Location: "$", Type: reflect.TypeOf(doc)}
+	}
+	paths, err := q.program.SelectSteps(doc)
+	if err != nil || len(paths) == 0 {
+		return nil, nil, err
+	}
+	return newEdits(paths), paths, nil
+}
+
+// edits is a tree of the steps to the nodes a query selects, which is walked once to edit them.
+type edits struct {
+	// selected reports if the node at the location is selected.
+	selected bool
+	// steps are the steps to the children with selected descendants, in the order they are first selected,
+	// and children are their edits.
+	steps    []eval.Step
+	children map[eval.Step]*edits
+}
+
+// newEdits returns the edits of the nodes at the steps, paths.
+func newEdits(paths [][]eval.Step) *edits {
+	root := &edits{}
+	for _, steps := range paths {
+		t := root
+		for _, s := range steps {
+			next, ok := t.children[s]
+			if !ok {
+				next = &edits{}
+				if t.children == nil {
+					t.children = make(map[eval.Step]*edits)
+				}
+				t.children[s] = next
+				t.steps = append(t.steps, s)
+			}
+			t = next
+		}
+		t.selected = true
+	}
+	return root
+}
+
+// apply edits the descendants of the value, v, at the location, loc, and the steps from the root, and then v itself
+// if it is selected, replacing values with the values f returns. It returns the edited value, which is a copy of v
+// if copying and a descendant is replaced.
+func (t *edits) apply(v Value, loc []byte, steps []eval.Step, copying bool, f editFunc) (Value, error) {
+	if len(t.steps) > 0 {
+		m := model.Of(v)
+		copied := !copying
+		for _, s := range t.steps {
+			e, ok := child(m, s)
+			if !ok {
+				continue
+			}
+			edited, err := t.children[s].apply(e, appendStep(loc, s), append(steps[:len(steps):len(steps)], s), copying, f)
+			if err != nil {
+				return nil, err
+			}
+			if !copied && !identical(e, edited) {
+				if v, err = shallowCopy(v, loc); err != nil {
+					return nil, err
+				}
+				copied = true
+			}
+			if v, err = writeChild(v, s, edited, loc); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if t.selected {
+		return f(Location(loc), steps, v)
+	}
+	return v, nil
+}
+
+// outermost returns the locations of the selected nodes at the steps, paths, which are not descendants
+// of other selected nodes, in the order of the paths, without duplicates.
+func (t *edits) outermost(paths [][]eval.Step) []Location {
+	var locations []Location
+	seen := make(map[*edits]bool, len(paths))
+	for _, steps := range paths {
+		e, inner := t, false
+		for _, s := range steps {
+			if inner = e.selected; inner {
+				break
+			}
+			e = e.children[s]
+		}
+		if !inner && !seen[e] {
+			seen[e] = true
+			locations = append(locations, stepsLocation(steps))
+		}
+	}
+	return locations
+}
+
+// walk calls f with the steps to every selected node at or below the steps, steps, in the order they are edited:
+// elements from the highest index down, and descendants before their ancestors, or in place of them if outermost,
+// in which case only the nodes that are not descendants of other selected nodes are walked.
+func (t *edits) walk(steps []eval.Step, outermost bool, f func([]eval.Step)) {
+	if !t.selected || !outermost {
+		for _, s := range t.sortedSteps() {
+			t.children[s].walk(append(steps[:len(steps):len(steps)], s), outermost, f)
+		}
+	}
+	if t.selected {
+		f(steps)
+	}
+}
+
+// sortedSteps returns the steps to the children, with indices from the highest down.
+func (t *edits) sortedSteps() []eval.Step {
+	steps := slices.Clone(t.steps)
+	slices.SortStableFunc(steps, func(a, b eval.Step) int {
+		return cmp.Compare(b.Index, a.Index)
+	})
+	return steps
+}
+
+// remove removes the selected descendants of the value, v, at the location, loc, and returns v,
+// or a copy of v if copying and a descendant is removed.
+func (t *edits) remove(v Value, loc []byte, copying bool) (Value, error) {
+	copied := !copying
+	// Elements are removed from the highest index down, so that the indices of the others do not shift.
+	for _, s := range t.sortedSteps() {
+		c := t.children[s]
+		var err error
+		if c.selected {
+			if _, ok := child(model.Of(v), s); ok && !copied {
+				if v, err = shallowCopy(v, loc); err != nil {
+					return nil, err
+				}
+				copied = true
+			}
+			if v, err = removeChild(v, s, loc); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		e, ok := child(model.Of(v), s)
+		if !ok {
+			continue
+		}
+		edited, err := c.remove(e, appendStep(loc, s), copying)
+		if err != nil {
+			return nil, err
+		}
+		if !copied && !identical(e, edited) {
+			if v, err = shallowCopy(v, loc); err != nil {
+				return nil, err
+			}
+			copied = true
+		}
+		if v, err = writeChild(v, s, edited, loc); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// child returns the member or element of the value, m, at the step, s.
+func child(m model.Value, s eval.Step) (Value, bool) {
+	if !s.IsIndex {
+		return m.Member(s.Name)
+	}
+	if m.Kind() != model.Array || s.Index >= m.Len() {
+		return nil, false
+	}
+	return m.Index(s.Index), true
+}
+
+// appendStep appends the normalized path segment of the step, s, to loc, without modifying loc.
+func appendStep(loc []byte, s eval.Step) []byte {
+	loc = loc[:len(loc):len(loc)]
+	if s.IsIndex {
+		return ast.AppendIndex(loc, s.Index)
+	}
+	return ast.AppendName(loc, s.Name)
+}
+
+// writeChild writes the member or element, e, into the object or array, v, at the location, loc, at the step, s.
+// Values that are already the member or element are not written, so that values that cannot be
+// written into can still be edited inside. It returns v.
+func writeChild(v Value, s eval.Step, e Value, loc []byte) (Value, error) {
+	if old, ok := child(model.Of(v), s); ok && identical(old, e) {
+		return v, nil
+	}
+	switch x := v.(type) {
+	case map[string]any:
+		x[s.Name] = e
+		return x, nil
+	case []any:
+		x[s.Index] = e
+		return x, nil
+	case Object:
+		for i := range x {
+			if x[i].Name == s.Name {
+				x[i].Value = e
+				return x, nil
+			}
+		}
+	}
+	rv := reflect.ValueOf(v)
+	switch {
+	case rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String && !rv.IsNil():
+		if ev, ok := assignable(e, rv.Type().Elem()); ok {
+			rv.SetMapIndex(reflect.ValueOf(s.Name).Convert(rv.Type().Key()), ev)
+			return v, nil
+		}
+	case rv.Kind() == reflect.Slice:
+		if ev, ok := assignable(e, rv.Type().Elem()); ok {
+			rv.Index(s.Index).Set(ev)
+			return v, nil
+		}
+	}
+	return nil, ErrNotWritable{Location: Location(loc), Type: reflect.TypeOf(v)}
+}
+
+// removeChild removes the member or element at the step, s, from the object or array, v, at the location, loc,
+// and returns v, which is shortened if it is a slice.
+func removeChild(v Value, s eval.Step, loc []byte) (Value, error) {
+	if _, ok := child(model.Of(v), s); !ok {
+		return v, nil
+	}
+	switch x := v.(type) {
+	case map[string]any:
+		delete(x, s.Name)
+		return x, nil
+	case []any:
+		return slices.Delete(x, s.Index, s.Index+1), nil
+	case Object:
+		i := slices.IndexFunc(x, func(m Member) bool { return m.Name == s.Name })
+		return slices.Delete(x, i, i+1), nil
+	}
+	rv := reflect.ValueOf(v)
+	switch {
+	case rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String:
+		rv.SetMapIndex(reflect.ValueOf(s.Name).Convert(rv.Type().Key()), reflect.Value{})
+		return v, nil
+	case rv.Kind() == reflect.Slice:
+		return reflect.AppendSlice(rv.Slice(0, s.Index), rv.Slice(s.Index+1, rv.Len())).Interface(), nil
+	}
+	return nil, ErrNotWritable{Location: Location(loc), Type: reflect.TypeOf(v)}
+}
+
+// addMember adds the member, name, whose value is e, to the object, v, at the location, loc, and returns v.
+func addMember(v Value, name string, e Value, loc []byte) (Value, error) {
+	if o, ok := v.(Object); ok {
+		return append(o, Member{Name: name, Value: e}), nil
+	}
+	return writeChild(v, eval.Step{Name: name}, e, loc)
+}
+
+// grow extends the array, v, at the location, loc, with null elements up to the index, i,
+// whose element is e, and returns the extended array.
+func grow(v Value, i int, e Value, loc []byte) (Value, error) {
+	if s, ok := v.([]any); ok {
+		s = append(s, make([]any, i-len(s))...)
+		return append(s, e), nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice {
+		if ev, ok := assignable(e, rv.Type().Elem()); ok {
+			rv = reflect.AppendSlice(rv, reflect.MakeSlice(rv.Type(), i-rv.Len(), i-rv.Len()))
+			return reflect.Append(rv, ev).Interface(), nil
+		}
+	}
+	return nil, ErrNotWritable{Location: Location(loc), Type: reflect.TypeOf(v)}
+}
+
+// assignable returns the reflect.Value of e if it can be assigned to values of the type, t.
+func assignable(e Value, t reflect.Type) (reflect.Value, bool) {
+	if e == nil {
+		switch t.Kind() {
+		case reflect.Interface, reflect.Pointer, reflect.Map, reflect.Slice:
+			return reflect.Zero(t), true
+		}
+		return reflect.Value{}, false
+	}
+	ev := reflect.ValueOf(e)
+	return ev, ev.Type().AssignableTo(t)
+}
+
+// identical reports if a and b are the same value, i.e. equal scalars, or the same map, slice or pointer.
+func identical(a, b Value) bool {
+	ra, rb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if !ra.IsValid() || !rb.IsValid() {
+		return ra.IsValid() == rb.IsValid()
+	}
+	if ra.Type() != rb.Type() {
+		return false
+	}
+	switch ra.Kind() {
+	case reflect.Map, reflect.Pointer, reflect.Func, reflect.Chan:
+		return ra.Pointer() == rb.Pointer()
+	case reflect.Slice:
+		return ra.Pointer() == rb.Pointer() && ra.Len() == rb.Len()
+	}
+	return ra.Comparable() && ra.Equal(rb)
+}
@@ -0,0 +1,261 @@
+package jsonpath_test
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/marcfyk/go-jsonpath"
+	"github.com/stretchr/testify/assert"
+)
+
+// marshal returns the JSON of v, failing the test if it cannot be marshalled.
+func marshal(t testing.TB, v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestSet(t *testing.T) {
+	cases := []struct {
+		query    string
+		doc      string
+		value    any
+		expected string
+	}{
+		{"$.a", `{"a": 1, "b": 2}`, "x", `{"a": "x", "b": 2}`},
+		{"$.missing", `{"a": 1}`, "x", `{"a": 1}`},
+		{"$[*].price", `[{"price": 1}, {"name": "x"}, {"price": 3}]`, 0, `[{"price": 0}, {"name": "x"}, {"price": 0}]`},
+		{"$..[?@.secret].secret", `{"a": {"secret": "p"}, "b": [{"secret": "q"}, {}]}`, "***", `{"a": {"secret": "***"}, "b": [{"secret": "***"}, {}]}`},
+		{"$[-1]", `[1, 2, 3]`, nil, `[1, 2, null]`},
+		{"$.*", `{"it's\\\n": 1, "\u0001": 2, "\u00e9": 3}`, 0, `{"it's\\\n": 0, "\u0001": 0, "\u00e9": 0}`},
+		{"$", `{"a": 1}`, []any{"root"}, `["root"]`},
+		// The ancestor is replaced after its descendants.
+		{"$..a", `{"a": {"a": 1}}`, 2, `{"a": 2}`},
+	}
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			for _, doc := range []jsonpath.Value{decode(t, c.doc), decodeOrdered(t, c.doc)} {
+				v, err := jsonpath.MustCompile(c.query).Set(doc, c.value)
+				assert.Nil(t, err)
+				assert.JSONEq(t, c.expected, marshal(t, v))
+			}
+		})
+	}
+
+	// Values are replaced in place.
+	doc := map[string]any{"tags": []any{"a", "b"}, "counts": map[string]int{"a": 1}}
+	v, err := jsonpath.MustCompile("$.tags[0]").Set(doc, "z")
+	assert.Nil(t, err)
+	assert.Equal(t, []any{"z", "b"}, doc["tags"])
+	assert.Equal(t, doc, v)
+
+	_, err = jsonpath.MustCompile("$.counts.a").Set(doc, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"a": 2}, doc["counts"])
+	_, err = jsonpath.MustCompile("$.counts.a").Set(doc, "two")
+	assert.Equal(t, jsonpath.ErrNotWritable{Location: "$['counts']", Type: reflect.TypeFor[map[string]int]()}, err)
+
+	type point struct{ X, Y int }
+	_, err = jsonpath.MustCompile("$[0].X").Set([]any{point{}}, 1)
+	assert.Equal(t, jsonpath.ErrNotWritable{Location: "$[0]", Type: reflect.TypeFor[point]()}, err)
+	_, err = jsonpath.MustCompile("$.a").Set(index(t, decode(t, `{"a": 1}`)), 1)
+	assert.Equal(t, jsonpath.ErrNotWritable{Location: "$", Type: reflect.TypeFor[*jsonpath.IndexedDocument]()}, err)
+}
+
+func TestSetOrCreate(t *testing.T) {
+	cases := []struct {
+		query    string
+		doc      string
+		expected string
+	}{
+		{"$.a", `{"a": 1, "b": 2}`, `{"a": "x", "b": 2}`},
+		{"$.c", `{"a": 1}`, `{"a": 1, "c": "x"}`},
+		{"$.spec.template.metadata.labels['app']", `{"spec": {"replicas": 1}}`,
+			`{"spec": {"replicas": 1, "template": {"metadata": {"labels": {"app": "x"}}}}}`},
+		{"$.items[2].name", `{"items": [{"name": "a"}]}`, `{"items": [{"name": "a"}, null, {"name": "x"}]}`},
+		{"$.items[-1].name", `{"items": [{"name": "a"}, {}]}`, `{"items": [{"name": "a"}, {"name": "x"}]}`},
+		{"$.a.b", `{"a": null}`, `{"a": {"b": "x"}}`},
+		{"$[1][0]", `[]`, `[null, ["x"]]`},
+		{"$", `{"a": 1}`, `"x"`},
+	}
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			for _, doc := range []jsonpath.Value{decode(t, c.doc), decodeOrdered(t, c.doc)} {
+				v, err := jsonpath.MustCompile(c.query).SetOrCreate(doc, "x")
+				assert.Nil(t, err)
+				assert.JSONEq(t, c.expected, marshal(t, v))
+			}
+		})
+	}
+
+	// Created objects keep the order they are created in.
+	v, err := jsonpath.MustCompile("$.z.y").SetOrCreate(nil, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, jsonpath.Object{{Name: "z", Value: jsonpath.Object{{Name: "y", Value: 1}}}}, v)
+
+	_, err = jsonpath.MustCompile("$.a.b").SetOrCreate(decode(t, `{"a": "s"}`), 1)
+	assert.Equal(t, jsonpath.ErrNotWritable{Location: "$['a']", Type: reflect.TypeFor[string]()}, err)
+	_, err = jsonpath.MustCompile("$[-2]").SetOrCreate([]any{1}, 1)
+	assert.Equal(t, jsonpath.ErrNotWritable{Location: "$", Type: reflect.TypeFor[[]any]()}, err)
+	_, err = jsonpath.MustCompile("$[*]").SetOrCreate([]any{1}, 1)
+	assert.Equal(t, jsonpath.ErrNotSingular{Query: "$[*]"}, err)
+
+	// Arrays are extended by at most MaxArrayExtension null elements.
+	_, err = jsonpath.MustCompile("$[9007199254740991]").SetOrCreate(nil, 1)
+	assert.Equal(t, jsonpath.ErrIndexOutOfRange{Location: "$", Index: 9007199254740991}, err)
+	_, err = jsonpath.MustCompile("$.a[1026]").SetOrCreate(map[string]any{"a": []any{1}}, 1)
+	assert.Equal(t, jsonpath.ErrIndexOutOfRange{Location: "$['a']", Index: 1026}, err)
+	v, err = jsonpath.MustCompile("$.a[1025]").SetOrCreate(map[string]any{"a": []any{1}}, 1)
+	assert.Nil(t, err)
+	assert.Len(t, v.(map[string]any)["a"], 1026)
+}
+
+// decodeOrdered decodes s with Decode, failing the test if it cannot be decoded.
+func decodeOrdered(t testing.TB, s string) jsonpath.Value {
+	v, err := jsonpath.Decode([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestDelete(t *testing.T) {
+	cases := []struct {
+		query    string
+		doc      string
+		expected string
+		removed  []jsonpath.Location
+	}{
+		{"$.a", `{"a": 1, "b": 2}`, `{"b": 2}`, []jsonpath.Location{"$['a']"}},
+		{"$.missing", `{"a": 1}`, `{"a": 1}`, nil},
+		{
+			"$.items[?@.expired]",
+			`{"items": [{"id": 0, "expired": true}, {"id": 1}, {"id": 2, "expired": true}, {"id": 3, "expired": true}]}`,
+			`{"items": [{"id": 1}]}`,
+			[]jsonpath.Location{"$['items'][0]", "$['items'][2]", "$['items'][3]"},
+		},
+		{"$[0, 2, 0]", `["a", "b", "c", "d"]`, `["b", "d"]`, []jsonpath.Location{"$[0]", "$[2]"}},
+		{"$[-1]", `[1, 2, 3]`, `[1, 2]`, []jsonpath.Location{"$[2]"}},
+		// Descendants are removed along with their ancestors.
+		{"$..a", `{"a": {"a": 1}, "b": [{"a": 2}, {"c": 3}]}`, `{"b": [{}, {"c": 3}]}`, []jsonpath.Location{"$['a']", "$['b'][0]['a']"}},
+		{"$..[?@.x == 1]", `[{"x": 1, "y": [{"x": 1}]}, {"x": 2, "y": [{"x": 1}, {"x": 1}, {"x": 3}]}]`,
+			`[{"x": 2, "y": [{"x": 3}]}]`, []jsonpath.Location{"$[0]", "$[1]['y'][0]", "$[1]['y'][1]"}},
+		{"$.*[1]", `[[1, 2], [3, 4, 5]]`, `[[1], [3, 5]]`, []jsonpath.Location{"$[0][1]", "$[1][1]"}},
+		{"$", `{"a": 1}`, `null`, []jsonpath.Location{"$"}},
+	}
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			for _, doc := range []jsonpath.Value{decode(t, c.doc), decodeOrdered(t, c.doc)} {
+				v, removed, err := jsonpath.MustCompile(c.query).Delete(doc)
+				assert.Nil(t, err)
+				assert.JSONEq(t, c.expected, marshal(t, v))
+				assert.Equal(t, c.removed, removed)
+			}
+		})
+	}
+
+	// Members are removed from Objects without changing the order of the others.
+	v, _, err := jsonpath.MustCompile("$.b").Delete(decodeOrdered(t, `{"c": 1, "b": 2, "a": 3}`))
+	assert.Nil(t, err)
+	assert.Equal(t, jsonpath.Object{{Name: "c", Value: json.Number("1")}, {Name: "a", Value: json.Number("3")}}, v)
+
+	doc := map[string]any{"ids": []int{1, 2, 3}, "names": map[string]string{"a": "x", "b": "y"}}
+	_, removed, err := jsonpath.MustCompile("$.ids[0]").Delete(doc)
+	assert.Nil(t, err)
+	assert.Equal(t, []jsonpath.Location{"$['ids'][0]"}, removed)
+	_, _, err = jsonpath.MustCompile("$.names.a").Delete(doc)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]any{"ids": []int{2, 3}, "names": map[string]string{"b": "y"}}, doc)
+
+	type point struct{ X, Y int }
+	_, _, err = jsonpath.MustCompile("$[0].X").Delete([]any{point{}})
+	assert.Equal(t, jsonpath.ErrNotWritable{Location: "$[0]", Type: reflect.TypeFor[point]()}, err)
+}
+
+func TestApply(t *testing.T) {
+	doc := decodeOrdered(t, `{
+		"users": [
+			{"name": "  Ada ", "email": "ada@example.com", "height": {"cm": 170}},
+			{"name": "Alan", "email": "alan@example.com", "height": {"cm": 180}}
+		]
+	}`)
+	trim := func(_ jsonpath.Location, v jsonpath.Value) (jsonpath.Value, error) {
+		return strings.TrimSpace(v.(string)), nil
+	}
+	v, err := jsonpath.MustCompile("$.users[*].name").Apply(doc, trim)
+	assert.Nil(t, err)
+	redact := func(_ jsonpath.Location, v jsonpath.Value) (jsonpath.Value, error) {
+		local, domain, _ := strings.Cut(v.(string), "@")
+		return local[:1] + "***@" + domain, nil
+	}
+	v, err = jsonpath.MustCompile("$..email").Apply(v, redact)
+	assert.Nil(t, err)
+	inches := func(_ jsonpath.Location, v jsonpath.Value) (jsonpath.Value, error) {
+		cm, err := v.(jsonpath.Object)[0].Value.(json.Number).Float64()
+		return jsonpath.Object{{Name: "in", Value: math.Round(cm / 2.54)}}, err
+	}
+	v, err = jsonpath.MustCompile("$.users[*].height").Apply(v, inches)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{
+		"users": [
+			{"name": "Ada", "email": "a***@example.com", "height": {"in": 67}},
+			{"name": "Alan", "email": "a***@example.com", "height": {"in": 71}}
+		]
+	}`, marshal(t, v))
+
+	// Descendants are applied before their ancestors, which are passed the values applied.
+	var locations []jsonpath.Location
+	count := func(loc jsonpath.Location, v jsonpath.Value) (jsonpath.Value, error) {
+		locations = append(locations, loc)
+		sum := 1.0
+		for _, e := range v.([]any) {
+			sum += e.(float64)
+		}
+		return sum, nil
+	}
+	v, err = jsonpath.MustCompile("$..[?@[0]]").Apply(decode(t, `[[[0], 1], [2]]`), count)
+	assert.Nil(t, err)
+	assert.Equal(t, []any{3.0, 3.0}, v)
+	assert.Equal(t, []jsonpath.Location{"$[0][0]", "$[0]", "$[1]"}, locations)
+
+	// Failures stop the walk at the location of the node.
+	errOdd := errors.New("odd")
+	even := func(_ jsonpath.Location, v jsonpath.Value) (jsonpath.Value, error) {
+		if v.(float64) == 3 {
+			return nil, errOdd
+		}
+		return v.(float64) / 2, nil
+	}
+	d := decode(t, `[2, 4, 3, 6]`)
+	_, err = jsonpath.MustCompile("$[*]").Apply(d, even)
+	assert.Equal(t, jsonpath.ErrApply{Location: "$[2]", Err: errOdd}, err)
+	assert.True(t, errors.Is(err, errOdd))
+	assert.Equal(t, []any{1.0, 2.0, 3.0, 6.0}, d)
+
+	v, err = jsonpath.MustCompile("$").Apply(1.0, even)
+	assert.Nil(t, err)
+	assert.Equal(t, 0.5, v)
+
+	// Nodes are edited at the steps the evaluation visits, whatever their names are,
+	// including when descendants are visited concurrently.
+	for _, q := range []*jsonpath.Query{
+		jsonpath.MustCompile("$..*[?@ == 1]"),
+		jsonpath.MustCompile("$..*[?@ == 1]", jsonpath.WithParallelDescendants(1), jsonpath.WithWorkers(4)),
+	} {
+		names := map[string]any{"it's": map[string]any{`a\b`: 1.0, "\x01\n": []any{1.0, 2.0}}}
+		locations = nil
+		v, err = q.Apply(names, func(loc jsonpath.Location, v jsonpath.Value) (jsonpath.Value, error) {
+			locations = append(locations, loc)
+			return v.(float64) + 1, nil
+		})
+		assert.Nil(t, err)
+		assert.ElementsMatch(t, []jsonpath.Location{`$['it\'s']['a\\b']`, `$['it\'s']['\u0001\n'][0]`}, locations)
+		assert.Equal(t, map[string]any{"it's": map[string]any{`a\b`: 2.0, "\x01\n": []any{2.0, 2.0}}}, v)
+	}
+}
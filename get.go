@@ -0,0 +1,323 @@
+package jsonpath
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/marcfyk/go-jsonpath/internal/eval"
+	"github.com/marcfyk/go-jsonpath/internal/model"
+)
+
+// ErrNoMatch is the error type when Get or GetOne evaluates a query that selects no node.
+type ErrNoMatch struct {
+	Query string
+}
+
+func (e ErrNoMatch) Error() string {
+	return fmt.Sprintf("query selects no node:%s", e.Query)
+}
+
+// ErrMultipleMatches is the error type when GetOne evaluates a query that selects more than one node.
+type ErrMultipleMatches struct {
+	Query string
+	// Count is the number of nodes the query selects.
+	Count int
+}
+
+func (e ErrMultipleMatches) Error() string {
+	return fmt.Sprintf("query selects more than one node:%s; found nodes:%d", e.Query, e.Count)
+}
+
+// ErrTypeMismatch is the error type when the value of a selected node cannot be converted to a Go type.
+type ErrTypeMismatch struct {
+	// Location is the location of the node.
+	Location Location
+	Type     reflect.Type
+	// Field is the path of the struct field the value is unmarshalled into, if it is by Unmarshal.
+	Field string
+	// Err is the error decoding the value into the type, if it is decoded from its JSON.
+	Err error
+}
+
+func (e ErrTypeMismatch) Error() string {
+	msg := fmt.Sprintf("value cannot be converted to type:%s; found at location:%s", e.Type, e.Location)
+	if e.Field != "" {
+		msg += fmt.Sprintf(", for field:%s", e.Field)
+	}
+	if e.Err != nil {
+		msg += fmt.Sprintf(": %v", e.Err)
+	}
+	return msg
+}
+
+func (e ErrTypeMismatch) Unwrap() error {
+	return e.Err
+}
+
+// queries are the queries compiled by Get, GetAll and GetOne.
+var queries = NewCache(1024)
+
+// Get returns the value of the first node the query selects from doc, converted to T.
+// It returns ErrNoMatch if the query selects no node.
+//
+// Values are converted to T the way encoding/json would unmarshal their JSON into a T:
+//   - Values that are a T, and any values if T is an interface they implement, are returned as is.
+//   - Booleans, strings and numbers are converted to T if it is of the same kind.
+//     Numbers are only converted to integer types if they are integers within the range of the type,
+//     and to float32 if they are within its range.
+//   - Null is converted to the nil value of T if it is an interface, pointer, map or slice,
+//     and not converted to other types, which cannot hold it.
+//   - Other values are marshalled to JSON and unmarshalled into a T, e.g. a struct or slice.
+//
+// It returns ErrTypeMismatch, with the location of the value, if a value cannot be converted.
+//
+// Queries are compiled once, and kept in a Cache shared by Get, GetAll and GetOne.
+// Queries with options are evaluated with Select, whose nodes are converted by Convert.
+func Get[T any](doc Value, query string) (T, error) {
+	var zero T
+	nodes, err := selectNodes(doc, query)
+	if err != nil {
+		return zero, err
+	}
+	if len(nodes) == 0 {
+		return zero, ErrNoMatch{Query: query}
+	}
+	return Convert[T](nodes[0])
+}
+
+// GetOne returns the value of the only node the query selects from doc, converted to T as Get converts it.
+// It returns ErrNoMatch if the query selects no node, and ErrMultipleMatches if it selects more than one.
+func GetOne[T any](doc Value, query string) (T, error) {
+	var zero T
+	nodes, err := selectNodes(doc, query)
+	if err != nil {
+		return zero, err
+	}
+	switch len(nodes) {
+	case 0:
+		return zero, ErrNoMatch{Query: query}
+	case 1:
+		return Convert[T](nodes[0])
+	default:
+		return zero, ErrMultipleMatches{Query: query, Count: len(nodes)}
+	}
+}
+
+// GetAll returns the values of the nodes the query selects from doc, converted to T as Get converts them.
+// It returns no values, rather than an error, if the query selects no node.
+func GetAll[T any](doc Value, query string) ([]T, error) {
+	nodes, err := selectNodes(doc, query)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]T, len(nodes))
+	for i, n := range nodes {
+		if values[i], err = Convert[T](n); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// selectNodes compiles the query, and returns the nodes it selects from doc.
+func selectNodes(doc Value, query string) ([]Node, error) {
+	q, err := queries.Compile(query)
+	if err != nil {
+		return nil, err
+	}
+	return q.Select(doc)
+}
+
+// Convert returns the value of the node, n, converted to T as Get converts it.
+func Convert[T any](n Node) (T, error) {
+	if v, ok := n.Value.(T); ok {
+		return v, nil
+	}
+	var v T
+	rv := reflect.ValueOf(&v).Elem()
+	if ok, err := convert(n.Value, rv); !ok {
+		return v, ErrTypeMismatch{Location: n.Location, Type: rv.Type(), Err: err}
+	}
+	return v, nil
+}
+
+// convert converts the value, v, into rv, reporting if it can be converted.
+// It returns the error decoding v into rv, if v is decoded from its JSON.
+func convert(v Value, rv reflect.Value) (bool, error) {
+	if rv.Kind() == reflect.Interface {
+		if v == nil && rv.NumMethod() == 0 {
+			return true, nil
+		}
+		if v != nil && reflect.TypeOf(v).Implements(rv.Type()) {
+			rv.Set(reflect.ValueOf(v))
+			return true, nil
+		}
+	}
+	m := model.Of(v)
+	// Types that unmarshal themselves are decoded from JSON.
+	if !implementsUnmarshaler(rv.Type()) {
+		switch m.Kind() {
+		case model.Null:
+			// encoding/json leaves values it unmarshals null into as they are, rather than failing.
+			switch rv.Kind() {
+			case reflect.Interface, reflect.Pointer, reflect.Map, reflect.Slice:
+				rv.SetZero()
+				return true, nil
+			}
+			return false, nil
+		case model.Bool:
+			if rv.Kind() == reflect.Bool {
+				rv.SetBool(m.Scalar().(bool))
+				return true, nil
+			}
+		case model.String:
+			if rv.Kind() == reflect.String {
+				rv.SetString(m.Scalar().(string))
+				return true, nil
+			}
+		case model.Number:
+			if ok, done := convertNumber(m.Scalar(), rv); done {
+				return ok, nil
+			}
+		}
+	}
+	b, err := marshal(v)
+	if err == nil {
+		err = json.Unmarshal(b, rv.Addr().Interface())
+	}
+	return err == nil, err
+}
+
+var (
+	unmarshalerType     = reflect.TypeFor[json.Unmarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// implementsUnmarshaler reports if pointers to values of type t unmarshal themselves.
+func implementsUnmarshaler(t reflect.Type) bool {
+	p := reflect.PointerTo(t)
+	return p.Implements(unmarshalerType) || p.Implements(textUnmarshalerType)
+}
+
+// convertNumber converts the number, n, which is an int64, uint64 or float64, into rv,
+// reporting if rv is a number, and if the number fits it exactly.
+func convertNumber(n any, rv reflect.Value) (ok, done bool) {
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch x := n.(type) {
+		case int64:
+			i = x
+		case uint64:
+			if x > math.MaxInt64 {
+				return false, true
+			}
+			i = int64(x)
+		case float64:
+			if x != math.Trunc(x) || x < -0x1p63 || x >= 0x1p63 {
+				return false, true
+			}
+			i = int64(x)
+		}
+		if rv.OverflowInt(i) {
+			return false, true
+		}
+		rv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var u uint64
+		switch x := n.(type) {
+		case int64:
+			if x < 0 {
+				return false, true
+			}
+			u = uint64(x)
+		case uint64:
+			u = x
+		case float64:
+			if x != math.Trunc(x) || x < 0 || x >= 0x1p64 {
+				return false, true
+			}
+			u = uint64(x)
+		}
+		if rv.OverflowUint(u) {
+			return false, true
+		}
+		rv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		switch x := n.(type) {
+		case int64:
+			f = float64(x)
+		case uint64:
+			f = float64(x)
+		case float64:
+			f = x
+		}
+		if rv.OverflowFloat(f) {
+			return false, true
+		}
+		rv.SetFloat(f)
+	default:
+		return false, false
+	}
+	return true, true
+}
+
+// marshal returns the JSON of the value, v, as queries read it,
+// so that Documents are marshalled through their methods.
+func marshal(v Value) ([]byte, error) {
+	p, err := plain(v, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(p)
+}
+
+// plain returns the value of v, nested depth levels deep, made of the values encoding/json decodes JSON into,
+// with objects as Objects so that they keep their order. ancestors are the arrays and objects nested
+// deeper than model.CycleDepth that v descends from.
+func plain(v Value, depth int, ancestors map[model.ID]bool) (any, error) {
+	m := model.Of(v)
+	switch m.Kind() {
+	case model.Array, model.Object:
+	case model.Invalid:
+		return nil, fmt.Errorf("value of type %T is not a JSON value", v)
+	default:
+		return m.Scalar(), nil
+	}
+	if depth > model.CycleDepth {
+		if id, ok := model.Identify(v); ok {
+			if ancestors[id] {
+				return nil, eval.ErrCycle{Type: reflect.TypeOf(v)}
+			}
+			if ancestors == nil {
+				ancestors = make(map[model.ID]bool)
+			}
+			ancestors[id] = true
+			defer delete(ancestors, id)
+		}
+	}
+	if m.Kind() == model.Array {
+		elements := make([]any, m.Len())
+		for i := range elements {
+			e, err := plain(m.Index(i), depth+1, ancestors)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = e
+		}
+		return elements, nil
+	}
+	members := make(Object, 0, m.Len())
+	var err error
+	m.Members(func(name string, e any) bool {
+		var p any
+		p, err = plain(e, depth+1, ancestors)
+		members = append(members, Member{Name: name, Value: p})
+		return err == nil
+	})
+	return members, err
+}
@@ -0,0 +1,151 @@
+package jsonpath_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/marcfyk/go-jsonpath"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGet(t *testing.T) {
+	doc := decode(t, bookstore)
+
+	title, err := jsonpath.Get[string](doc, "$.store.book[0].title")
+	assert.Nil(t, err)
+	assert.Equal(t, "Sayings of the Century", title)
+
+	price, err := jsonpath.Get[int](doc, "$.store.bicycle.price")
+	assert.Nil(t, err)
+	assert.Equal(t, 399, price)
+
+	b, err := jsonpath.GetOne[book](doc, "$.store.book[?@.author == 'Herman Melville']")
+	assert.Nil(t, err)
+	assert.Equal(t, book{Category: "fiction", Author: "Herman Melville", Title: "Moby Dick", ISBN: "0-553-21311-3", Price: 8.99}, b)
+
+	books, err := jsonpath.Get[[]book](doc, "$.store.book")
+	assert.Nil(t, err)
+	assert.Len(t, books, 4)
+
+	titles, err := jsonpath.GetAll[string](doc, "$.store.book[?@.price < 10].title")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Sayings of the Century", "Moby Dick"}, titles)
+
+	prices, err := jsonpath.GetAll[float64](doc, "$..price")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []float64{8.95, 12.99, 8.99, 22.99, 399}, prices)
+
+	colors, err := jsonpath.GetAll[any](doc, "$.store.bicycle.color")
+	assert.Nil(t, err)
+	assert.Equal(t, []any{"red"}, colors)
+
+	none, err := jsonpath.GetAll[string](doc, "$.store.missing")
+	assert.Nil(t, err)
+	assert.Empty(t, none)
+
+	// Documents are converted through their methods.
+	indexed, err := jsonpath.GetOne[book](index(t, doc), "$.store.book[-1]")
+	assert.Nil(t, err)
+	assert.Equal(t, book{Category: "fiction", Author: "J. R. R. Tolkien", Title: "The Lord of the Rings", ISBN: "0-395-19395-8", Price: 22.99}, indexed)
+}
+
+func TestGetErrors(t *testing.T) {
+	doc := decode(t, bookstore)
+
+	_, err := jsonpath.Get[string](doc, "$.store.missing")
+	assert.Equal(t, jsonpath.ErrNoMatch{Query: "$.store.missing"}, err)
+
+	_, err = jsonpath.GetOne[string](doc, "$.store.book[*].author")
+	assert.Equal(t, jsonpath.ErrMultipleMatches{Query: "$.store.book[*].author", Count: 4}, err)
+
+	_, err = jsonpath.GetAll[string](doc, "$.store[")
+	assert.NotNil(t, err)
+
+	// Numbers only convert to integers they are exactly.
+	_, err = jsonpath.GetAll[int](doc, "$..price")
+	var mismatch jsonpath.ErrTypeMismatch
+	assert.True(t, errors.As(err, &mismatch))
+	assert.Equal(t, jsonpath.Location("$['store']['book'][0]['price']"), mismatch.Location)
+	assert.Equal(t, reflect.TypeFor[int](), mismatch.Type)
+
+	_, err = jsonpath.Get[bool](doc, "$.store.bicycle.color")
+	assert.Equal(t, jsonpath.ErrTypeMismatch{
+		Location: "$['store']['bicycle']['color']",
+		Type:     reflect.TypeFor[bool](),
+		Err:      &json.UnmarshalTypeError{Value: "string", Type: reflect.TypeFor[bool](), Offset: 5},
+	}, err)
+
+	// Null only converts to types that can hold nil.
+	nulls := map[string]any{"a": nil}
+	_, err = jsonpath.Get[int](nulls, "$.a")
+	assert.Equal(t, jsonpath.ErrTypeMismatch{Location: "$['a']", Type: reflect.TypeFor[int]()}, err)
+	_, err = jsonpath.Get[string](nulls, "$.a")
+	assert.Equal(t, jsonpath.ErrTypeMismatch{Location: "$['a']", Type: reflect.TypeFor[string]()}, err)
+	_, err = jsonpath.Get[struct{ A int }](nulls, "$.a")
+	assert.Equal(t, jsonpath.ErrTypeMismatch{Location: "$['a']", Type: reflect.TypeFor[struct{ A int }]()}, err)
+
+	// Numbers beyond the range of float32 do not convert to it.
+	_, err = jsonpath.Get[float32](map[string]any{"a": 1e39}, "$.a")
+	assert.Equal(t, jsonpath.ErrTypeMismatch{Location: "$['a']", Type: reflect.TypeFor[float32]()}, err)
+}
+
+type celsius float64
+
+func TestConvert(t *testing.T) {
+	type event struct {
+		ID   uint64    `json:"id"`
+		When time.Time `json:"when"`
+	}
+	when := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	doc := []any{
+		json.Number("18446744073709551615"), 3.0, -1, "2024-01-02T03:04:05Z", nil, "aGk=",
+		jsonpath.Object{{Name: "id", Value: uint64(18446744073709551615)}, {Name: "when", Value: "2024-01-02T03:04:05Z"}},
+	}
+	convert := func(query string, f func(jsonpath.Node) (any, error)) (any, error) {
+		nodes, err := jsonpath.MustCompile(query).Select(doc)
+		assert.Nil(t, err)
+		return f(nodes[0])
+	}
+	cases := []struct {
+		query    string
+		convert  func(jsonpath.Node) (any, error)
+		expected any
+	}{
+		{"$[0]", convertTo[uint64], uint64(18446744073709551615)},
+		{"$[1]", convertTo[int8], int8(3)},
+		{"$[1]", convertTo[celsius], celsius(3)},
+		{"$[2]", convertTo[int], -1},
+		{"$[3]", convertTo[time.Time], when},
+		{"$[4]", convertTo[*int], (*int)(nil)},
+		{"$[4]", convertTo[any], nil},
+		{"$[4]", convertTo[map[string]int], map[string]int(nil)},
+		{"$[4]", convertTo[[]int], []int(nil)},
+		{"$[4]", convertTo[fmt.Stringer], fmt.Stringer(nil)},
+		{"$[1]", convertTo[float32], float32(3)},
+		{"$[5]", convertTo[[]byte], []byte("hi")},
+		{"$[6]", convertTo[event], event{ID: 18446744073709551615, When: when}},
+		{"$[6].id", convertTo[json.Number], json.Number("18446744073709551615")},
+	}
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			v, err := convert(c.query, c.convert)
+			assert.Nil(t, err)
+			assert.Equal(t, c.expected, v)
+		})
+	}
+
+	// Integers beyond the range of a type do not convert to it.
+	_, err := convert("$[0]", convertTo[int64])
+	assert.Equal(t, jsonpath.ErrTypeMismatch{Location: "$[0]", Type: reflect.TypeFor[int64]()}, err)
+	_, err = convert("$[2]", convertTo[uint])
+	assert.Equal(t, jsonpath.ErrTypeMismatch{Location: "$[2]", Type: reflect.TypeFor[uint]()}, err)
+}
+
+// convertTo returns the value of the node converted to T, as an any.
+func convertTo[T any](n jsonpath.Node) (any, error) {
+	return jsonpath.Convert[T](n)
+}
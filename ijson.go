@@ -0,0 +1,216 @@
+package jsonpath
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/marcfyk/go-jsonpath/internal/ast"
+)
+
+// ProblemKind names what makes a Problem not I-JSON.
+type ProblemKind int
+
+const (
+	// ProblemDuplicateName is a member with the same name as an earlier member of its object.
+	ProblemDuplicateName ProblemKind = iota + 1
+	// ProblemInvalidUnicode is a string or member name that is not valid Unicode,
+	// i.e. invalid UTF-8 or an unpaired surrogate, or that has a noncharacter, e.g. U+FFFF.
+	ProblemInvalidUnicode
+	// ProblemInexactNumber is an integer beyond ±(2^53-1), which a float64 cannot hold exactly,
+	// however it is written, e.g. 9007199254740993.0 or 1e16, or a number beyond the range of a float64.
+	ProblemInexactNumber
+)
+
+func (k ProblemKind) String() string {
+	switch k {
+	case ProblemDuplicateName:
+		return "duplicate member name"
+	case ProblemInvalidUnicode:
+		return "invalid unicode"
+	case ProblemInexactNumber:
+		return "inexact number"
+	default:
+		return "unknown problem"
+	}
+}
+
+// Problem is a part of a JSON document that I-JSON, as specified by RFC7493, does not allow.
+type Problem struct {
+	Kind ProblemKind
+	// Location is the location of the value with the problem,
+	// or of the member whose name has the problem.
+	Location Location
+	// Offset is the offset in the data of the value or member name with the problem.
+	Offset int64
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s at %s; found at offset:%d", p.Kind, p.Location, p.Offset)
+}
+
+// ErrNotIJSON is the error type when DecodeIJSON decodes JSON that is not I-JSON.
+type ErrNotIJSON struct {
+	// Problems are every problem of the data, in the order they occur in.
+	Problems []Problem
+}
+
+func (e ErrNotIJSON) Error() string {
+	if len(e.Problems) == 1 {
+		return fmt.Sprintf("data is not I-JSON: %s", e.Problems[0])
+	}
+	more := len(e.Problems) - 1
+	if more == 1 {
+		return fmt.Sprintf("data is not I-JSON: %s, and 1 more problem", e.Problems[0])
+	}
+	return fmt.Sprintf("data is not I-JSON: %s, and %d more problems", e.Problems[0], more)
+}
+
+// DecodeIJSON decodes the JSON value of data like Decode, and validates that it is I-JSON,
+// which RFC9535 assumes documents to be. It returns ErrNotIJSON, listing every problem, for data that:
+//   - has objects with more than one member of the same name, which encoding/json silently
+//     decodes to the last one, so queries would select a member the data does not agree on.
+//   - has strings or member names that are not valid Unicode,
+//     which encoding/json silently replaces with U+FFFD.
+//   - has integers beyond ±(2^53-1), whether or not they are written with a fraction or exponent,
+//     e.g. 1.0e16, or numbers beyond the range of a float64, which other implementations cannot read exactly.
+func DecodeIJSON(data []byte) (Value, error) {
+	iv := &validator{data: data}
+	v, err := decodeData(data, iv)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv.problems) > 0 {
+		return nil, ErrNotIJSON{Problems: iv.problems}
+	}
+	return v, nil
+}
+
+// validator collects the problems of the tokens of data that are not I-JSON.
+type validator struct {
+	data     []byte
+	problems []Problem
+}
+
+// name validates the member name read from data between start and end,
+// which is the name of the innermost object of the stack.
+func (iv *validator) name(stack []container, start, end int64) {
+	offset, raw := iv.token(start, end)
+	c := &stack[len(stack)-1]
+	if _, ok := c.position(c.name); ok {
+		iv.report(ProblemDuplicateName, stack, offset)
+	}
+	if !validString(raw) {
+		iv.report(ProblemInvalidUnicode, stack, offset)
+	}
+}
+
+// scalar validates the scalar, t, read from data between start and end.
+func (iv *validator) scalar(stack []container, t json.Token, start, end int64) {
+	offset, raw := iv.token(start, end)
+	switch x := t.(type) {
+	case string:
+		if !validString(raw) {
+			iv.report(ProblemInvalidUnicode, stack, offset)
+		}
+	case json.Number:
+		if !exactNumber(x) {
+			iv.report(ProblemInexactNumber, stack, offset)
+		}
+	}
+}
+
+// token returns the offset and text of the token read from data between start and end,
+// which may be preceded by whitespace and separators.
+func (iv *validator) token(start, end int64) (int64, []byte) {
+	raw := iv.data[start:end]
+	trimmed := bytes.TrimLeft(raw, " \t\r\n,:")
+	return end - int64(len(trimmed)), trimmed
+}
+
+// report adds a problem with the value being decoded into the innermost container of the stack.
+func (iv *validator) report(kind ProblemKind, stack []container, offset int64) {
+	iv.problems = append(iv.problems, Problem{Kind: kind, Location: location(stack), Offset: offset})
+}
+
+// location returns the location of the value being decoded into the innermost container of the stack.
+func location(stack []container) Location {
+	b := []byte("$")
+	for _, c := range stack {
+		if c.isObject {
+			b = ast.AppendName(b, c.name)
+		} else {
+			b = ast.AppendIndex(b, len(c.array))
+		}
+	}
+	return Location(b)
+}
+
+// exactNumber reports if the number, n, is within the range of a float64,
+// and is not an integer beyond ±(2^53-1), however it is written.
+func exactNumber(n json.Number) bool {
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil {
+		return false
+	}
+	// Numbers that round to within ±2^53 are within it, so the integers among them are within ±(2^53-1).
+	if math.Abs(f) < 1<<53 {
+		return true
+	}
+	// Other numbers are parsed exactly, to find if they are integers. They are within the range of a float64,
+	// so their exponents are small enough for big.Rat to expand them.
+	r, ok := new(big.Rat).SetString(string(n))
+	return ok && !r.IsInt()
+}
+
+// validString reports if the JSON string literal, raw, is valid Unicode without noncharacters.
+// Escape sequences are decoded, so that unpaired surrogates are found in escaped strings too.
+func validString(raw []byte) bool {
+	s := raw[1 : len(raw)-1]
+	for i := 0; i < len(s); {
+		if s[i] != '\\' {
+			r, size := utf8.DecodeRune(s[i:])
+			if r == utf8.RuneError && size == 1 || noncharacter(r) {
+				return false
+			}
+			i += size
+			continue
+		}
+		if s[i+1] != 'u' {
+			i += 2
+			continue
+		}
+		r := hexRune(s[i+2 : i+6])
+		i += 6
+		if utf16.IsSurrogate(r) {
+			if r >= 0xDC00 || i+6 > len(s) || s[i] != '\\' || s[i+1] != 'u' {
+				return false
+			}
+			r = utf16.DecodeRune(r, hexRune(s[i+2:i+6]))
+			if r == utf8.RuneError {
+				return false
+			}
+			i += 6
+		}
+		if noncharacter(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// hexRune returns the rune of the 4 hexadecimal digits of a \u escape sequence.
+func hexRune(hex []byte) rune {
+	r, _ := strconv.ParseUint(string(hex), 16, 16)
+	return rune(r)
+}
+
+// noncharacter reports if r is a Unicode noncharacter.
+func noncharacter(r rune) bool {
+	return 0xFDD0 <= r && r <= 0xFDEF || r&0xFFFE == 0xFFFE
+}
@@ -2,9 +2,9 @@
 package ast
 
 import (
-	"maps"
 	"regexp"
-	"slices"
+
+	"github.com/marcfyk/go-jsonpath/internal/model"
 )
 
 // Value is the leaf values of a JSON structure.
@@ -12,7 +12,9 @@ import (
 // numbers | text strings | null | true | false | JSON objects     | arrays
 //
 // float64 | string       | nil  | true | false | map[string]Value | []Value
-type Value any
+//
+// Other Go values are read as the JSON values encoding/json marshals them to, see package model.
+type Value = any
 
 // Location is the position of a Value in a JSON structure.
 type Location string
@@ -21,173 +23,148 @@ type Location string
 type Node struct {
 	Location Location
 	Value    Value
+	// trail is the trail of the node if it is selected by an evaluation that records trails, rather than locations.
+	trail *Trail
 }
 
-type QueryJSONPath struct {
-	Segments []Expr
+// Trail is the position of a Value in a JSON structure, as the name or index that selects it from its parent,
+// linked to the trail of the parent. The trail of the root is nil.
+//
+// Trails of the children of a node share the trail of the node, so recording them while nodes are visited
+// costs a Trail per node, rather than a location that is rendered, and then parsed again by callers that edit the nodes.
+type Trail struct {
+	Parent  *Trail
+	Name    string
+	Index   int
+	IsIndex bool
 }
 
-func (q QueryJSONPath) Evaluate([]Node) []Node {
-	panic("unimplemented")
+// TrailOf returns the trail of the node, n.
+func TrailOf(n Node) *Trail {
+	return n.trail
 }
 
-// Expr is an expression that maps []Node -> []Node.
-//
-// Expr takes in 0..n nodes and outputs 0..n nodes.
-type Expr interface {
-	Evaluate([]Node) []Node
+// Trailed returns the node whose value is v, and whose trail is t.
+func Trailed(v Value, t *Trail) Node {
+	return Node{Value: v, trail: t}
+}
+
+type QueryJSONPath struct {
+	Segments []Expr
 }
 
-// ExprLogical is an expression that maps []Node -> bool.
+func (QueryJSONPath) expr() {}
+
+// Expr is an expression in the tree of a jsonpath query.
 //
-// ExprLogical takes in a list of nodes and returns a boolean value,
-// behaving like a predicate.
-type ExprLogical interface {
-	EvaluateLogical([]Node) bool
+// Expr only marks the types that make up the tree.
+// Trees are evaluated by compiling them into closures, see package eval.
+type Expr interface {
+	expr()
 }
 
-// ExprSingle is an expression that evaluates []Node -> Node.
+// ExprSingle is an expression that evaluates to at most 1 node.
 //
-// ExprSingle is an expression that takes in a list of nodes but returns only 1 node.
+// ExprSingle expressions are the operands of comparisons and singular queries.
 type ExprSingle interface {
-	EvaluateSingle([]Node) Node
+	Expr
+	exprSingle()
 }
 
 type SegmentChild struct {
 	Selectors []Expr
 }
 
-func (s SegmentChild) Evaluate(input []Node) []Node {
-	panic("unimplemented")
-}
+func (SegmentChild) expr() {}
 
 type SegmentDescendant struct {
 	Selectors []Expr
 }
 
-func (s SegmentDescendant) Evaluate(input []Node) []Node {
-	panic("unimplemented")
-}
+func (SegmentDescendant) expr() {}
 
 type SelectorName struct {
 	Name string
 }
 
-func (s SelectorName) Evaluate([]Node) []Node {
-	panic("unimplemented")
-}
-
-func (s SelectorName) EvaluateSingle([]Node) Node {
-	panic("unimplemented")
-}
+func (SelectorName) expr() {}
 
 type SelectorWildcard struct{}
 
-func (s SelectorWildcard) Evaluate([]Node) []Node {
-	panic("unimplemented")
-}
+func (SelectorWildcard) expr() {}
 
+// SelectorSlice selects a slice of an array.
+//
+// Start and End are nil when omitted from the query, as their defaults
+// depend on the sign of Step and the length of the array.
 type SelectorSlice struct {
-	Start int
-	End   int
+	Start *int
+	End   *int
 	Step  int
 }
 
-func (s SelectorSlice) Evaluate([]Node) []Node {
-	panic("unimplemented")
-}
+func (SelectorSlice) expr() {}
 
 type SelectorIndex struct {
 	Index int
 }
 
-func (s SelectorIndex) Evaluate([]Node) []Node {
-	panic("unimplemented")
-}
-
-func (s SelectorIndex) EvaluateSingle([]Node) Node {
-	panic("unimplemented")
-}
+func (SelectorIndex) expr() {}
 
 type SelectorFilter struct {
-	Expr ExprLogical
+	Expr Expr
 }
 
-func (s SelectorFilter) Evaluate([]Node) []Node {
-	panic("unimplemented")
-}
+func (SelectorFilter) expr() {}
 
 type ExprLogicalOr struct {
 	Exprs []Expr
 }
 
-func (e ExprLogicalOr) Evaluate([]Node) []Node {
-	panic("unimplemented")
-}
+func (ExprLogicalOr) expr() {}
 
 type ExprLogicalAnd struct {
 	Exprs []Expr
 }
 
-func (e ExprLogicalAnd) Evaluate([]Node) []Node {
-	panic("unimplemented")
-}
+func (ExprLogicalAnd) expr() {}
 
 type ExprLogicalNot struct {
 	Expr Expr
 }
 
-func (e ExprLogicalNot) Evaluate([]Node) []Node {
-	panic("unimplemented")
-}
+func (ExprLogicalNot) expr() {}
 
 type ExprParen struct {
 	Expr Expr
 }
 
-func (e ExprParen) Evaluate([]Node) []Node {
-	panic("unimplemented")
-}
+func (ExprParen) expr() {}
+
+// Nothing is the value of a comparable that yields no node,
+// e.g. a singular query that selects nothing.
+//
+// Nothing is distinct from nil, which is the JSON null.
+var Nothing Value = nothing{}
+
+type nothing struct{}
 
 var (
 	EQ = func(v1, v2 Value) bool {
-		switch v1 := v1.(type) {
-		case float64:
-			v2, ok := v2.(float64)
-			return ok && v1 == v2
-		case string:
-			v2, ok := v2.(string)
-			return ok && v1 == v2
-		case bool:
-			v2, ok := v2.(bool)
-			return ok && v1 == v2
-		case []any:
-			v2, ok := v2.([]any)
-			return ok && slices.Equal(v1, v2)
-		case map[string]any:
-			v2, ok := v2.(map[string]any)
-			return ok && maps.Equal(v1, v2)
-		case nil:
-			return v2 == nil
-		default:
-			return false
+		if v1 == Nothing || v2 == Nothing {
+			return v1 == v2
 		}
+		return model.Equal(v1, v2)
 	}
 	NE = func(v1, v2 Value) bool {
 		return !EQ(v1, v2)
 	}
 
 	LT = func(v1, v2 Value) bool {
-		switch v1 := v1.(type) {
-		case float64:
-			v2, ok := v2.(float64)
-			return ok && v1 < v2
-		case string:
-			v2, ok := v2.(string)
-			return ok && v1 < v2
-		default:
+		if v1 == Nothing || v2 == Nothing {
 			return false
 		}
+		return model.Less(v1, v2)
 	}
 
 	LTE = func(v1, v2 Value) bool {
@@ -195,156 +172,183 @@ var (
 	}
 
 	GT = func(v1, v2 Value) bool {
-		return !LTE(v1, v2)
+		return LT(v2, v1)
 	}
 
 	GTE = func(v1, v2 Value) bool {
-		return !LT(v1, v2)
+		return LTE(v2, v1)
 	}
 )
 
 type ExprComparison struct {
 	Left  ExprSingle
 	Right ExprSingle
-	F     func(Value, Value) bool
+	Op    ComparisonOp
 }
 
-func (e ExprComparison) Evaluate([]Node) []Node {
-	panic("unimplemented")
+func (ExprComparison) expr() {}
+
+// ComparisonOp is the operator of a comparison.
+type ComparisonOp int
+
+const (
+	OpEQ ComparisonOp = iota
+	OpNE
+	OpLT
+	OpLTE
+	OpGT
+	OpGTE
+)
+
+// Func returns the function that compares two values with the operator.
+func (o ComparisonOp) Func() func(Value, Value) bool {
+	switch o {
+	case OpNE:
+		return NE
+	case OpLT:
+		return LT
+	case OpLTE:
+		return LTE
+	case OpGT:
+		return GT
+	case OpGTE:
+		return GTE
+	default:
+		return EQ
+	}
 }
 
-type Literal struct {
-	Value Value
+func (o ComparisonOp) String() string {
+	switch o {
+	case OpNE:
+		return "!="
+	case OpLT:
+		return "<"
+	case OpLTE:
+		return "<="
+	case OpGT:
+		return ">"
+	case OpGTE:
+		return ">="
+	default:
+		return "=="
+	}
 }
 
-func (l Literal) EvaluateSingle([]Node) Node {
-	panic("unimplemented")
+// ExprLogicalConstant is a logical expression whose result is known without a document,
+// e.g. a comparison of two literals.
+type ExprLogicalConstant struct {
+	Value bool
 }
 
-func (l Literal) Evaluate([]Node) []Node {
-	panic("unimplemented")
+func (ExprLogicalConstant) expr() {}
+
+type Literal struct {
+	Value Value
 }
 
+func (Literal) expr() {}
+
+func (Literal) exprSingle() {}
+
 type QuerySingularRel struct {
 	Segments []ExprSingle
 }
 
-func (q QuerySingularRel) EvaluateSingle([]Node) Node {
-	panic("unimplemented")
-}
+func (QuerySingularRel) expr() {}
 
-func (q QuerySingularRel) Evaluate([]Node) []Node {
-	panic("unimplemented")
-}
+func (QuerySingularRel) exprSingle() {}
 
 type QuerySingularAbs struct {
 	Segments []ExprSingle
 }
 
-func (q QuerySingularAbs) EvaluateSingle([]Node) Node {
-	panic("unimplemented")
-}
+func (QuerySingularAbs) expr() {}
+
+func (QuerySingularAbs) exprSingle() {}
 
-func (q QuerySingularAbs) Evaluate([]Node) []Node {
-	panic("unimplemented")
+// QueryHoisted is an absolute singular query inside a filter, whose value is
+// evaluated once per document rather than once per node the filter tests.
+//
+// Slot identifies the value among the hoisted queries of the tree.
+// Hoisted queries with the same Slot are the same query.
+type QueryHoisted struct {
+	Slot  int
+	Query QuerySingularAbs
 }
 
+func (QueryHoisted) expr() {}
+
+func (QueryHoisted) exprSingle() {}
+
 type SegmentName struct {
 	Name string
 }
 
-func (s SegmentName) EvaluateSingle([]Node) Node {
-	panic("unimplemented")
-}
+func (SegmentName) expr() {}
+
+func (SegmentName) exprSingle() {}
 
 type SegmentIndex struct {
 	Index int
 }
 
-func (s SegmentIndex) EvaluateSingle([]Node) Node {
-	panic("unimplemented")
-}
+func (SegmentIndex) expr() {}
+
+func (SegmentIndex) exprSingle() {}
 
 type QueryRel struct {
 	Segments []Expr
 }
 
-func (q QueryRel) Evaluate([]Node) []Node {
-	panic("unimplemented")
-}
-
-type FuncLength struct{}
-
-func (f FuncLength) EvaluateFunc(Value) Value {
-	panic("unimplemented")
-}
+func (QueryRel) expr() {}
 
-func (f FuncLength) Evaluate([]Node) []Node {
-	panic("unimplemented")
+type FuncLength struct {
+	Expr Expr
 }
 
-func (f FuncLength) EvaluateSingle([]Node) Node {
-	panic("unimplemented")
-}
+func (FuncLength) expr() {}
 
-type FuncCount struct{}
+func (FuncLength) exprSingle() {}
 
-func (f FuncCount) EvaluateFunc(Value) Value {
-	panic("unimplemented")
+type FuncCount struct {
+	Expr Expr
 }
 
-func (f FuncCount) Evaluate([]Node) []Node {
-	panic("unimplemented")
-}
+func (FuncCount) expr() {}
 
-func (f FuncCount) EvaluateSingle([]Node) Node {
-	panic("unimplemented")
-}
+func (FuncCount) exprSingle() {}
 
+// FuncMatch tests if the string value of Expr matches the I-Regexp in Pattern entirely.
+//
+// Regex is the compiled Pattern when Pattern is a string Literal.
 type FuncMatch struct {
-	Regex *regexp.Regexp
+	Expr    Expr
+	Pattern Expr
+	Regex   *regexp.Regexp
 }
 
-func (f FuncMatch) EvaluateFunc(Value) Value {
-	panic("unimplemented")
-}
-
-func (f FuncMatch) Evaluate([]Node) []Node {
-	panic("unimplemented")
-}
+func (FuncMatch) expr() {}
 
-func (f FuncMatch) EvaluateSingle([]Node) Node {
-	panic("unimplemented")
-}
+func (FuncMatch) exprSingle() {}
 
+// FuncSearch tests if the string value of Expr contains a match of the I-Regexp in Pattern.
+//
+// Regex is the compiled Pattern when Pattern is a string Literal.
 type FuncSearch struct {
-	Regex *regexp.Regexp
+	Expr    Expr
+	Pattern Expr
+	Regex   *regexp.Regexp
 }
 
-func (f FuncSearch) EvaluateFunc(Value) Value {
-	panic("unimplemented")
-}
-
-func (f FuncSearch) Evaluate([]Node) []Node {
-	panic("unimplemented")
-}
+func (FuncSearch) expr() {}
 
-func (f FuncSearch) EvaluateSingle([]Node) Node {
-	panic("unimplemented")
-}
+func (FuncSearch) exprSingle() {}
 
 type FuncValue struct {
 	Expr Expr
 }
 
-func (f FuncValue) EvaluateFunc(Value) Value {
-	panic("unimplemented")
-}
+func (FuncValue) expr() {}
 
-func (f FuncValue) Evaluate([]Node) []Node {
-	panic("unimplemented")
-}
-
-func (f FuncValue) EvaluateSingle([]Node) Node {
-	panic("unimplemented")
-}
+func (FuncValue) exprSingle() {}
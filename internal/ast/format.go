@@ -0,0 +1,244 @@
+package ast
+
+import (
+	"strconv"
+)
+
+// Format returns the canonical jsonpath string of an expression.
+//
+// Spellings of the same query, e.g. $.a and $["a"], have the same canonical string,
+// which is itself a valid query with the same results.
+func Format(e Expr) string {
+	return string(appendExpr(nil, e))
+}
+
+// AppendIndex appends the normalized path segment of an array index to b.
+func AppendIndex(b []byte, i int) []byte {
+	b = append(b, '[')
+	b = strconv.AppendInt(b, int64(i), 10)
+	return append(b, ']')
+}
+
+// AppendName appends the normalized path segment of an object member name to b.
+//
+// The name is single quoted, escaping the quote, backslash and control characters.
+func AppendName(b []byte, name string) []byte {
+	b = append(b, '[')
+	b = appendString(b, name)
+	return append(b, ']')
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendString appends a single quoted string literal to b.
+func appendString(b []byte, s string) []byte {
+	b = append(b, '\'')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\b':
+			b = append(b, '\\', 'b')
+		case '\f':
+			b = append(b, '\\', 'f')
+		case '\n':
+			b = append(b, '\\', 'n')
+		case '\r':
+			b = append(b, '\\', 'r')
+		case '\t':
+			b = append(b, '\\', 't')
+		case '\'':
+			b = append(b, '\\', '\'')
+		case '\\':
+			b = append(b, '\\', '\\')
+		default:
+			if c < 0x20 {
+				b = append(b, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xF])
+			} else {
+				b = append(b, c)
+			}
+		}
+	}
+	return append(b, '\'')
+}
+
+// precedence is the binding of the position a logical expression is formatted in.
+// Expressions that bind looser than their position are parenthesized.
+type precedence int
+
+const (
+	precedenceOr precedence = iota
+	precedenceAnd
+	precedenceNot
+)
+
+func appendExpr(b []byte, e Expr) []byte {
+	switch e := e.(type) {
+	case QueryJSONPath:
+		return appendSegments(append(b, '$'), e.Segments)
+	case QueryRel:
+		return appendSegments(append(b, '@'), e.Segments)
+	case QuerySingularAbs:
+		return appendSingular(append(b, '$'), e.Segments)
+	case QuerySingularRel:
+		return appendSingular(append(b, '@'), e.Segments)
+	case QueryHoisted:
+		return appendExpr(b, e.Query)
+	case SegmentChild:
+		return appendSelectors(b, e.Selectors)
+	case SegmentDescendant:
+		return appendSelectors(append(b, '.', '.'), e.Selectors)
+	case SegmentName:
+		return AppendName(b, e.Name)
+	case SegmentIndex:
+		return AppendIndex(b, e.Index)
+	case SelectorName:
+		return appendString(b, e.Name)
+	case SelectorIndex:
+		return strconv.AppendInt(b, int64(e.Index), 10)
+	case SelectorWildcard:
+		return append(b, '*')
+	case SelectorSlice:
+		if e.Start != nil {
+			b = strconv.AppendInt(b, int64(*e.Start), 10)
+		}
+		b = append(b, ':')
+		if e.End != nil {
+			b = strconv.AppendInt(b, int64(*e.End), 10)
+		}
+		if e.Step != 1 {
+			b = append(b, ':')
+			b = strconv.AppendInt(b, int64(e.Step), 10)
+		}
+		return b
+	case SelectorFilter:
+		return appendLogical(append(b, '?'), e.Expr, precedenceOr)
+	case Literal:
+		return appendLiteral(b, e.Value)
+	case FuncLength:
+		return appendFunc(b, "length", e.Expr)
+	case FuncCount:
+		return appendFunc(b, "count", e.Expr)
+	case FuncValue:
+		return appendFunc(b, "value", e.Expr)
+	case FuncMatch:
+		return appendFunc(b, "match", e.Expr, e.Pattern)
+	case FuncSearch:
+		return appendFunc(b, "search", e.Expr, e.Pattern)
+	case ExprLogicalOr, ExprLogicalAnd, ExprLogicalNot, ExprParen, ExprComparison, ExprLogicalConstant:
+		return appendLogical(b, e, precedenceOr)
+	default:
+		return b
+	}
+}
+
+func appendSegments(b []byte, segments []Expr) []byte {
+	for _, s := range segments {
+		b = appendExpr(b, s)
+	}
+	return b
+}
+
+func appendSingular(b []byte, segments []ExprSingle) []byte {
+	for _, s := range segments {
+		b = appendExpr(b, s)
+	}
+	return b
+}
+
+func appendSelectors(b []byte, selectors []Expr) []byte {
+	b = append(b, '[')
+	for i, s := range selectors {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = appendExpr(b, s)
+	}
+	return append(b, ']')
+}
+
+func appendFunc(b []byte, name string, args ...Expr) []byte {
+	b = append(b, name...)
+	b = append(b, '(')
+	for i, arg := range args {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = appendLogical(b, arg, precedenceOr)
+	}
+	return append(b, ')')
+}
+
+func appendLiteral(b []byte, v Value) []byte {
+	switch v := v.(type) {
+	case string:
+		return appendString(b, v)
+	case float64:
+		return strconv.AppendFloat(b, v, 'g', -1, 64)
+	case int64:
+		return strconv.AppendInt(b, v, 10)
+	case uint64:
+		return strconv.AppendUint(b, v, 10)
+	case bool:
+		return strconv.AppendBool(b, v)
+	default:
+		return append(b, "null"...)
+	}
+}
+
+// appendLogical appends a logical expression found in a position of precedence, p.
+func appendLogical(b []byte, e Expr, p precedence) []byte {
+	switch e := e.(type) {
+	case ExprLogicalOr:
+		if len(e.Exprs) == 1 {
+			return appendLogical(b, e.Exprs[0], p)
+		}
+		return appendOperands(b, e.Exprs, "||", precedenceAnd, p > precedenceOr)
+	case ExprLogicalAnd:
+		if len(e.Exprs) == 1 {
+			return appendLogical(b, e.Exprs[0], p)
+		}
+		return appendOperands(b, e.Exprs, "&&", precedenceAnd, p > precedenceAnd)
+	case ExprLogicalNot:
+		return appendLogical(append(b, '!'), e.Expr, precedenceNot)
+	case ExprParen:
+		b = append(b, '(')
+		b = appendLogical(b, e.Expr, precedenceOr)
+		return append(b, ')')
+	case ExprComparison:
+		if p == precedenceNot {
+			b = append(b, '(')
+		}
+		b = appendExpr(b, e.Left)
+		b = append(b, e.Op.String()...)
+		b = appendExpr(b, e.Right)
+		if p == precedenceNot {
+			b = append(b, ')')
+		}
+		return b
+	case ExprLogicalConstant:
+		return appendLogical(b, ExprComparison{
+			Left:  Literal{Value: true},
+			Right: Literal{Value: e.Value},
+			Op:    OpEQ,
+		}, p)
+	default:
+		return appendExpr(b, e)
+	}
+}
+
+// appendOperands appends the operands of a logical or or and, joined by op.
+// Operands are found in a position of precedence, p.
+func appendOperands(b []byte, exprs []Expr, op string, p precedence, paren bool) []byte {
+	if paren {
+		b = append(b, '(')
+	}
+	for i, e := range exprs {
+		if i > 0 {
+			b = append(b, op...)
+		}
+		b = appendLogical(b, e, p)
+	}
+	if paren {
+		b = append(b, ')')
+	}
+	return b
+}
@@ -0,0 +1,455 @@
+// Package codec reads documents in binary encodings, e.g. MessagePack and CBOR, as JSON values.
+//
+// A Format reads the items of an encoding one at a time. Documents are either decoded into
+// the values encoding/json decodes JSON into, or read lazily, as a Node over the encoded bytes
+// whose arrays and maps are only decoded once a query reads them.
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/marcfyk/go-jsonpath/internal/model"
+)
+
+// Format reads the items of an encoding.
+type Format interface {
+	// Head reads the item at offset, off, of data. It fails if the item is malformed,
+	// or if it is a scalar that has no JSON equivalent.
+	Head(data []byte, off int) (Head, error)
+	// Scalar decodes the scalar item at offset, off, whose Head has been read without failing.
+	// Numbers are an int64, uint64, float32, float64 or json.Number, and binary data is a string.
+	Scalar(data []byte, off int) any
+}
+
+// Head is the head of an item.
+type Head struct {
+	Kind model.Kind
+	// Len is the number of elements of an array or members of a map,
+	// or -1 if the array or map has an indefinite length and ends with a break.
+	Len int
+	// Integer reports if a number is an integer.
+	Integer bool
+	// Break reports if the item is a break, which ends an array or map of indefinite length.
+	Break bool
+	// End is the offset after the head of an array or map, or after any other item.
+	End int
+}
+
+// ErrMalformed is the error type when data is not well formed in its encoding.
+type ErrMalformed struct {
+	// Offset is the offset of the malformed item.
+	Offset int
+	Reason string
+}
+
+func (e ErrMalformed) Error() string {
+	return fmt.Sprintf("malformed data:%s; found at offset:%d", e.Reason, e.Offset)
+}
+
+// ErrUnsupported is the error type when data has an item that has no JSON equivalent.
+type ErrUnsupported struct {
+	// Offset is the offset of the item.
+	Offset int
+	// Value describes the item, e.g. "NaN" or "map key of type bool".
+	Value string
+}
+
+func (e ErrUnsupported) Error() string {
+	return fmt.Sprintf("value with no JSON equivalent:%s; found at offset:%d", e.Value, e.Offset)
+}
+
+// Truncated returns the error of an item at offset, off, that continues past the end of the data.
+func Truncated(off int) error {
+	return ErrMalformed{Offset: off, Reason: "unexpected end of data"}
+}
+
+// frame is an array or map whose items are being read.
+type frame struct {
+	// n is the number of items left to read, or -1 until a break.
+	n     int
+	isMap bool
+	// items is the number of items read.
+	items int
+}
+
+// next returns the frame after reading an item, and reports if the item is a map key.
+func (f *frame) next() bool {
+	if f.n > 0 {
+		f.n--
+	}
+	f.items++
+	return f.isMap && f.items%2 == 1
+}
+
+// open returns the frame of the array or map with the head, h.
+func open(h Head) frame {
+	f := frame{n: h.Len, isMap: h.Kind == model.Object}
+	if f.isMap && f.n > 0 {
+		f.n *= 2
+	}
+	return f
+}
+
+// walk reads the item at offset, off, of data, and every item nested in it, calling visit with
+// the offset and head of each item, and whether it is a map key, until visit fails.
+// Items are read from a stack rather than by recursion,
+// so deeply nested documents cannot overflow the goroutine stack.
+// It returns the offset after the item.
+func walk(f Format, data []byte, off int, visit func(off int, h Head, key bool) error) (int, error) {
+	current := frame{n: 1}
+	var stack []frame
+	for {
+		h, err := f.Head(data, off)
+		if err != nil {
+			return 0, err
+		}
+		if h.Break {
+			if current.n != -1 {
+				return 0, ErrMalformed{Offset: off, Reason: "unexpected break"}
+			}
+			if current.isMap && current.items%2 == 1 {
+				return 0, ErrMalformed{Offset: off, Reason: "map without the value of its last key"}
+			}
+			if err := visit(off, h, false); err != nil {
+				return 0, err
+			}
+			current, stack = stack[len(stack)-1], stack[:len(stack)-1]
+		} else {
+			key := current.next()
+			if key && h.Kind != model.String && !(h.Kind == model.Number && h.Integer) {
+				return 0, ErrUnsupported{Offset: off, Value: "map key that is not a string or integer"}
+			}
+			if err := visit(off, h, key); err != nil {
+				return 0, err
+			}
+			if h.Kind == model.Array || h.Kind == model.Object {
+				stack = append(stack, current)
+				current = open(h)
+			}
+		}
+		off = h.End
+		for current.n == 0 {
+			if len(stack) == 0 {
+				return off, nil
+			}
+			current, stack = stack[len(stack)-1], stack[:len(stack)-1]
+		}
+	}
+}
+
+// skip returns the offset after the item at offset, off, and every item nested in it.
+func skip(f Format, data []byte, off int) (int, error) {
+	return walk(f, data, off, func(int, Head, bool) error {
+		return nil
+	})
+}
+
+// Parse returns the Node of the item that data is the encoding of.
+// The data is validated once, without decoding it, so that reading the Node cannot fail.
+func Parse(f Format, data []byte) (*Node, error) {
+	end, err := skip(f, data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if end != len(data) {
+		return nil, ErrMalformed{Offset: end, Reason: "unexpected data after the value"}
+	}
+	h, _ := f.Head(data, 0)
+	return &Node{format: f, data: data, head: h}, nil
+}
+
+// Decode decodes the item that data is the encoding of, as encoding/json decodes JSON into an any,
+// except that maps are model.Ordered objects which keep the order of their members.
+// Of members with the same name, the last one is kept, at the position of the first one.
+func Decode(f Format, data []byte) (any, error) {
+	// containers are the arrays and maps being decoded, from the outermost one.
+	var containers []*container
+	var root any
+	end, err := walk(f, data, 0, func(off int, h Head, key bool) error {
+		var v any
+		switch {
+		case h.Break:
+			v = containers[len(containers)-1].value()
+			containers = containers[:len(containers)-1]
+		case h.Kind == model.Array || h.Kind == model.Object:
+			c := &container{isMap: h.Kind == model.Object, n: h.Len}
+			if h.Len == 0 {
+				v = c.value()
+				break
+			}
+			containers = append(containers, c)
+			return nil
+		case key:
+			containers[len(containers)-1].key = keyName(f.Scalar(data, off))
+			return nil
+		default:
+			v = f.Scalar(data, off)
+		}
+		// Arrays and maps of a known length are complete once their last item is added.
+		for len(containers) > 0 {
+			c := containers[len(containers)-1]
+			if !c.add(v) {
+				return nil
+			}
+			v = c.value()
+			containers = containers[:len(containers)-1]
+		}
+		root = v
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if end != len(data) {
+		return nil, ErrMalformed{Offset: end, Reason: "unexpected data after the value"}
+	}
+	return root, nil
+}
+
+// container is an array or map being decoded.
+type container struct {
+	isMap bool
+	// n is the number of elements or members left to add, or -1 until a break.
+	n     int
+	array []any
+	// object are the members of a map, whose positions are looked up in names.
+	object model.Ordered
+	names  map[string]int
+	// key is the name of the member being decoded.
+	key string
+}
+
+// add adds the decoded value, v, as the next element or member,
+// and reports if the array or map is complete.
+func (c *container) add(v any) bool {
+	if c.isMap {
+		c.object, c.names = addMember(c.object, c.names, c.key, v)
+	} else {
+		c.array = append(c.array, v)
+	}
+	if c.n > 0 {
+		c.n--
+	}
+	return c.n == 0
+}
+
+// value returns the decoded array or map.
+func (c *container) value() any {
+	if c.isMap {
+		if c.object == nil {
+			return model.Ordered{}
+		}
+		return c.object
+	}
+	if c.array == nil {
+		return []any{}
+	}
+	return c.array
+}
+
+// indexedMembers is the number of members a map needs
+// for its members to be looked up by a map rather than by scanning them.
+const indexedMembers = 16
+
+// addMember adds the member, name, to the members of a map, whose positions are in names
+// if there are enough of them. A member with the same name as an earlier one replaces its value.
+func addMember(members model.Ordered, names map[string]int, name string, v any) (model.Ordered, map[string]int) {
+	if i, ok := position(members, names, name); ok {
+		members[i].Value = v
+		return members, names
+	}
+	if names != nil {
+		names[name] = len(members)
+	}
+	members = append(members, model.Member{Name: name, Value: v})
+	if names == nil && len(members) == indexedMembers {
+		names = make(map[string]int, 2*indexedMembers)
+		for i, m := range members {
+			names[m.Name] = i
+		}
+	}
+	return members, names
+}
+
+// position returns the position of the member, name, of the members of a map.
+func position(members model.Ordered, names map[string]int, name string) (int, bool) {
+	if names != nil {
+		i, ok := names[name]
+		return i, ok
+	}
+	for i, m := range members {
+		if m.Name == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// keyName returns the member name of a map key, which is a string or an integer.
+func keyName(k any) string {
+	switch x := k.(type) {
+	case string:
+		return x
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case uint64:
+		return strconv.FormatUint(x, 10)
+	case fmt.Stringer:
+		return x.String()
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+// Node is an item of encoded data, which is read as a JSON value without decoding it up front.
+//
+// The elements of an array and members of a map are decoded the first time any of them is read,
+// with nested arrays and maps as Nodes that are skipped over rather than decoded.
+// So queries only decode the arrays and maps along the paths they select,
+// and the scalars directly in them.
+//
+// A Node is safe for concurrent use.
+type Node struct {
+	format Format
+	data   []byte
+	// off is the offset of the item in data.
+	off  int
+	head Head
+
+	once sync.Once
+	// elements are the elements of an array.
+	elements []any
+	// members are the members of a map, whose positions are looked up in names.
+	members model.Ordered
+	names   map[string]int
+}
+
+// Offset returns the offset of the item in the encoded data.
+func (n *Node) Offset() int {
+	return n.off
+}
+
+// MarshalJSON marshals the JSON value of the item.
+func (n *Node) MarshalJSON() ([]byte, error) {
+	end, err := skip(n.format, n.data, n.off)
+	if err != nil {
+		return nil, err
+	}
+	v, err := Decode(n.format, n.data[n.off:end])
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// Kind returns the JSON type of the item.
+func (n *Node) Kind() model.Kind {
+	return n.head.Kind
+}
+
+// Len returns the number of elements of an array or members of a map.
+func (n *Node) Len() int {
+	switch n.head.Kind {
+	case model.Array:
+		if n.head.Len >= 0 {
+			return n.head.Len
+		}
+		n.expand()
+		return len(n.elements)
+	case model.Object:
+		n.expand()
+		return len(n.members)
+	default:
+		return 0
+	}
+}
+
+// Index returns the element at index, i, of an array.
+func (n *Node) Index(i int) any {
+	if n.head.Kind != model.Array {
+		return nil
+	}
+	n.expand()
+	if i < 0 || len(n.elements) <= i {
+		return nil
+	}
+	return n.elements[i]
+}
+
+// Member returns the member, name, of a map.
+func (n *Node) Member(name string) (any, bool) {
+	if n.head.Kind != model.Object {
+		return nil, false
+	}
+	n.expand()
+	i, ok := position(n.members, n.names, name)
+	if !ok {
+		return nil, false
+	}
+	return n.members[i].Value, true
+}
+
+// Keys returns the names of the members of a map, in the order of the data.
+func (n *Node) Keys() []string {
+	if n.head.Kind != model.Object {
+		return nil
+	}
+	n.expand()
+	keys := make([]string, len(n.members))
+	for i, m := range n.members {
+		keys[i] = m.Name
+	}
+	return keys
+}
+
+// Scalar returns the decoded value of a scalar.
+func (n *Node) Scalar() any {
+	switch n.head.Kind {
+	case model.Array, model.Object:
+		return nil
+	default:
+		return n.format.Scalar(n.data, n.off)
+	}
+}
+
+// expand decodes the elements or members of an array or map, once.
+// The data has been validated, so reading it cannot fail.
+func (n *Node) expand() {
+	n.once.Do(func() {
+		off := n.head.End
+		var key string
+		for i := 0; n.head.Len < 0 || i < n.head.Len*n.items(); i++ {
+			h, _ := n.format.Head(n.data, off)
+			if h.Break {
+				return
+			}
+			var v any
+			if h.Kind == model.Array || h.Kind == model.Object {
+				v = &Node{format: n.format, data: n.data, off: off, head: h}
+				off, _ = skip(n.format, n.data, off)
+			} else {
+				v = n.format.Scalar(n.data, off)
+				off = h.End
+			}
+			switch {
+			case n.head.Kind == model.Array:
+				n.elements = append(n.elements, v)
+			case i%2 == 0:
+				key = keyName(v)
+			default:
+				n.members, n.names = addMember(n.members, n.names, key, v)
+			}
+		}
+	})
+}
+
+// items returns the number of items per element or member.
+func (n *Node) items() int {
+	if n.head.Kind == model.Object {
+		return 2
+	}
+	return 1
+}
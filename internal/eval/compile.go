@@ -0,0 +1,510 @@
+package eval
+
+import (
+	"reflect"
+	"slices"
+
+	"github.com/marcfyk/go-jsonpath/internal/ast"
+	"github.com/marcfyk/go-jsonpath/internal/model"
+)
+
+// segment appends the nodes it selects from the input node, n, to out.
+//
+// Both segments and selectors compile to a segment,
+// since a segment with a single selector behaves like the selector.
+type segment func(c *context, n ast.Node, out []ast.Node) []ast.Node
+
+// positions is what the segments a compiler compiles record of the positions of the nodes they select.
+type positions int
+
+const (
+	// noPositions records nothing, for queries inside filters, which only need the values of their nodes.
+	noPositions positions = iota
+	// locations renders the Location of every node.
+	locations
+	// trails links the Trail of every node to the trail of the node it is selected from,
+	// for evaluations that edit the nodes.
+	trails
+)
+
+// compiler lowers expressions into closures.
+type compiler struct {
+	// pos is what the compiled segments record of the positions of the nodes they select.
+	pos positions
+	// options configures the evaluation of the compiled segments.
+	options Options
+	// hoisted are the paths of the hoisted queries, by slot.
+	hoisted *[]path
+}
+
+// valuesOnly returns a compiler for segments whose nodes do not need their positions.
+func (c compiler) valuesOnly() compiler {
+	c.pos = noPositions
+	return c
+}
+
+func (c compiler) segments(exprs []ast.Expr) ([]segment, error) {
+	segments := make([]segment, 0, len(exprs))
+	for i := 0; i < len(exprs); {
+		// Runs of singular segments are walked as one path,
+		// which skips the nodelists in between them.
+		if p := singularPrefix(exprs[i:]); len(p) > 0 {
+			segments = append(segments, c.path(p))
+			i += len(p)
+			continue
+		}
+		s, err := c.segment(exprs[i])
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, s)
+		i++
+	}
+	return segments, nil
+}
+
+func (c compiler) segment(e ast.Expr) (segment, error) {
+	switch e := e.(type) {
+	case ast.SegmentChild:
+		return c.selectors(e.Selectors)
+	case ast.SegmentDescendant:
+		s, err := c.selectors(e.Selectors)
+		if err != nil {
+			return nil, err
+		}
+		indexed, err := c.indexed(e.Selectors)
+		if err != nil {
+			return nil, err
+		}
+		return c.descendant(s, indexed), nil
+	default:
+		return nil, ErrUnsupportedExpr{Expr: e}
+	}
+}
+
+func (c compiler) selectors(exprs []ast.Expr) (segment, error) {
+	selectors := make([]segment, len(exprs))
+	for i, e := range exprs {
+		s, err := c.selector(e)
+		if err != nil {
+			return nil, err
+		}
+		selectors[i] = s
+	}
+	if len(selectors) == 1 {
+		return selectors[0], nil
+	}
+	return func(ctx *context, n ast.Node, out []ast.Node) []ast.Node {
+		for _, s := range selectors {
+			out = s(ctx, n, out)
+		}
+		return out
+	}, nil
+}
+
+func (c compiler) selector(e ast.Expr) (segment, error) {
+	switch e := e.(type) {
+	case ast.SelectorName:
+		return c.path(path{nameStep(e.Name)}), nil
+	case ast.SelectorIndex:
+		return c.path(path{indexStep(e.Index)}), nil
+	case ast.SelectorWildcard:
+		return c.wildcard(), nil
+	case ast.SelectorSlice:
+		return c.slice(e), nil
+	case ast.SelectorFilter:
+		return c.filter(e)
+	default:
+		return nil, ErrUnsupportedExpr{Expr: e}
+	}
+}
+
+// members returns the function that calls f with the members of an object, in the order they are selected in.
+func (c compiler) members() func(m model.Value, f func(name string, e ast.Value) bool) {
+	if c.options.SortedKeys {
+		return model.Value.SortedMembers
+	}
+	return model.Value.Members
+}
+
+// path compiles the steps of a singular query into a segment.
+func (c compiler) path(p path) segment {
+	pos := c.pos
+	return func(ctx *context, n ast.Node, out []ast.Node) []ast.Node {
+		n, ok := p.walkNode(n, pos)
+		if !ok || !ctx.visit(n) {
+			return out
+		}
+		return append(out, n)
+	}
+}
+
+func (c compiler) wildcard() segment {
+	pos := c.pos
+	members := c.members()
+	return func(ctx *context, n ast.Node, out []ast.Node) []ast.Node {
+		switch m := model.Of(n.Value); m.Kind() {
+		case model.Array:
+			for i := range m.Len() {
+				c := childIndex(n, i, m.Index(i), pos)
+				if !ctx.visit(c) {
+					break
+				}
+				out = append(out, c)
+			}
+		case model.Object:
+			members(m, func(k string, e ast.Value) bool {
+				c := childName(n, k, e, pos)
+				if !ctx.visit(c) {
+					return false
+				}
+				out = append(out, c)
+				return true
+			})
+		}
+		return out
+	}
+}
+
+func (c compiler) slice(e ast.SelectorSlice) segment {
+	pos := c.pos
+	return func(ctx *context, n ast.Node, out []ast.Node) []ast.Node {
+		m := model.Of(n.Value)
+		if m.Kind() != model.Array {
+			return out
+		}
+		lower, upper := sliceBounds(e, m.Len())
+		switch {
+		case e.Step > 0:
+			for i := lower; i < upper; i += e.Step {
+				c := childIndex(n, i, m.Index(i), pos)
+				if !ctx.visit(c) {
+					break
+				}
+				out = append(out, c)
+			}
+		case e.Step < 0:
+			for i := upper; lower < i; i += e.Step {
+				c := childIndex(n, i, m.Index(i), pos)
+				if !ctx.visit(c) {
+					break
+				}
+				out = append(out, c)
+			}
+		}
+		return out
+	}
+}
+
+// sliceBounds returns the bounds of the slice selector, e, for an array of length n.
+// Elements are selected from lower up to upper when stepping forwards,
+// and from upper down to lower when stepping backwards, excluding the end bound.
+func sliceBounds(e ast.SelectorSlice, n int) (int, int) {
+	var start, end int
+	if e.Step >= 0 {
+		start, end = 0, n
+	} else {
+		start, end = n-1, -n-1
+	}
+	if e.Start != nil {
+		start = normalize(*e.Start, n)
+	}
+	if e.End != nil {
+		end = normalize(*e.End, n)
+	}
+	if e.Step >= 0 {
+		return min(max(start, 0), n), min(max(end, 0), n)
+	}
+	return min(max(end, -1), n-1), min(max(start, -1), n-1)
+}
+
+// normalize converts an index, i, which may count from the end of an array
+// of length n, into an index that counts from the start.
+func normalize(i, n int) int {
+	if i < 0 {
+		return n + i
+	}
+	return i
+}
+
+func (c compiler) filter(e ast.SelectorFilter) (segment, error) {
+	p, err := c.logical(e.Expr)
+	if err != nil {
+		return nil, err
+	}
+	pos := c.pos
+	members := c.members()
+	return func(ctx *context, n ast.Node, out []ast.Node) []ast.Node {
+		switch m := model.Of(n.Value); m.Kind() {
+		case model.Array:
+			for i := range m.Len() {
+				e := m.Index(i)
+				if !ctx.visit(ast.Node{Value: e}) {
+					break
+				}
+				if p(ctx, e) {
+					out = append(out, childIndex(n, i, e, pos))
+				}
+			}
+		case model.Object:
+			members(m, func(k string, e ast.Value) bool {
+				if !ctx.visit(ast.Node{Value: e}) {
+					return false
+				}
+				if p(ctx, e) {
+					out = append(out, childName(n, k, e, pos))
+				}
+				return true
+			})
+		}
+		return out
+	}, nil
+}
+
+// descendant applies the segment, s, to the node and all of its descendants.
+// A node is visited before its descendants, and array elements are visited in order.
+//
+// Nodes are visited from a stack rather than by recursion, so deeply nested documents
+// cannot overflow the goroutine stack. The evaluation fails with ErrMaxDepth if a node
+// is nested deeper than the maximum depth below the node the segment starts from,
+// and with ErrCycle if a node is its own descendant.
+//
+// Arrays and objects with enough children to meet the parallel threshold
+// have their children visited concurrently, with results kept in the same order.
+//
+// Nodes of an indexed document are visited by indexed instead.
+func (c compiler) descendant(s segment, indexed indexedSegment) segment {
+	pos := c.pos
+	threshold := c.options.ParallelThreshold
+	maxDepth := c.options.MaxDepth
+	sorted := c.options.SortedKeys
+	members := c.members()
+	var visit func(ctx *context, n ast.Node, depth int, out []ast.Node) []ast.Node
+	visit = func(ctx *context, n ast.Node, depth int, out []ast.Node) []ast.Node {
+		stack := append(ctx.frames(), frame{node: n, depth: depth})
+		// ancestors are the arrays and objects nested deeper than model.CycleDepth
+		// that the node being visited descends from.
+		var ancestors map[model.ID]bool
+		for len(stack) > 0 && ctx.err == nil {
+			f := stack[len(stack)-1]
+			// Popped frames are cleared so that pooled stacks do not retain documents.
+			stack[len(stack)-1] = frame{}
+			stack = stack[:len(stack)-1]
+			if f.leave {
+				id, _ := model.Identify(f.node.Value)
+				delete(ancestors, id)
+				continue
+			}
+			if maxDepth > 0 && f.depth > maxDepth {
+				ctx.fail(ErrMaxDepth{Depth: maxDepth})
+				break
+			}
+			if !ctx.visit(f.node) {
+				break
+			}
+			out = s(ctx, f.node, out)
+			m := model.Of(f.node.Value)
+			if m.Kind() != model.Array && m.Kind() != model.Object {
+				continue
+			}
+			// Only documents with cycles, or uncommonly deep nesting, are checked for cycles.
+			if f.depth > model.CycleDepth {
+				if id, ok := model.Identify(f.node.Value); ok {
+					if ancestors[id] {
+						ctx.fail(ErrCycle{Type: reflect.TypeOf(f.node.Value)})
+						break
+					}
+					if ancestors == nil {
+						ancestors = make(map[model.ID]bool)
+					}
+					ancestors[id] = true
+					stack = append(stack, frame{node: ast.Node{Value: f.node.Value}, leave: true})
+				}
+			}
+			// Nodes deeper than model.CycleDepth are visited by this goroutine, so that their ancestors
+			// are all in the same set, and a cycle is found rather than split between workers forever.
+			if threshold > 0 && m.Len() >= threshold && ctx.workers != nil && f.depth <= model.CycleDepth {
+				if m.Kind() == model.Array {
+					out = ctx.parallel(m.Len(), func(ctx *context, i int, out []ast.Node) []ast.Node {
+						return visit(ctx, childIndex(f.node, i, m.Index(i), pos), f.depth+1, out)
+					}, out)
+					continue
+				}
+				keys := m.Keys()
+				if sorted && m.Unordered() {
+					slices.Sort(keys)
+				}
+				out = ctx.parallel(len(keys), func(ctx *context, i int, out []ast.Node) []ast.Node {
+					e, _ := m.Member(keys[i])
+					return visit(ctx, childName(f.node, keys[i], e, pos), f.depth+1, out)
+				}, out)
+				continue
+			}
+			if m.Kind() == model.Array {
+				// Children are pushed in reverse, so that they are popped in order.
+				for i := m.Len() - 1; i >= 0; i-- {
+					stack = append(stack, frame{
+						node:  childIndex(f.node, i, m.Index(i), pos),
+						depth: f.depth + 1,
+					})
+				}
+				continue
+			}
+			// Members are reversed once pushed, so that they are popped in the order of the object.
+			start := len(stack)
+			members(m, func(k string, e ast.Value) bool {
+				stack = append(stack, frame{
+					node:  childName(f.node, k, e, pos),
+					depth: f.depth + 1,
+				})
+				return true
+			})
+			slices.Reverse(stack[start:])
+		}
+		ctx.releaseFrames(stack)
+		return out
+	}
+	if pos != locations {
+		// Nodes without locations cannot be found in an index.
+		return func(ctx *context, n ast.Node, out []ast.Node) []ast.Node {
+			return visit(ctx, n, 0, out)
+		}
+	}
+	return func(ctx *context, n ast.Node, out []ast.Node) []ast.Node {
+		if ctx.index != nil {
+			if i, ok := ctx.index.position(n); ok {
+				if maxDepth > 0 && ctx.index.nodes[i].height > maxDepth {
+					ctx.fail(ErrMaxDepth{Depth: maxDepth})
+					return out
+				}
+				return indexed(ctx, i, out)
+			}
+		}
+		return visit(ctx, n, 0, out)
+	}
+}
+
+// frame is a node waiting to be visited by a descendant segment,
+// nested depth levels below the node the segment started from.
+//
+// A frame that leaves a node marks the end of its descendants.
+type frame struct {
+	node  ast.Node
+	depth int
+	leave bool
+}
+
+// indexedSegment appends the nodes a descendant segment selects from the node at position i
+// of the indexed document, and from its descendants, to out.
+type indexedSegment func(c *context, i int, out []ast.Node) []ast.Node
+
+// indexed compiles the selectors, exprs, of a descendant segment for indexed documents.
+func (c compiler) indexed(exprs []ast.Expr) (indexedSegment, error) {
+	names := make([]string, 0, len(exprs))
+	for _, e := range exprs {
+		if e, ok := e.(ast.SelectorName); ok {
+			names = append(names, e.Name)
+		}
+	}
+	if len(names) == len(exprs) {
+		return func(ctx *context, i int, out []ast.Node) []ast.Node {
+			start := len(out)
+			out = ctx.index.members(names, i, out)
+			for j, n := range out[start:] {
+				if !ctx.visit(n) {
+					return out[:start+j]
+				}
+			}
+			return out
+		}, nil
+	}
+	selectors := make([]indexedSegment, len(exprs))
+	for i, e := range exprs {
+		s, err := c.indexedSelector(e)
+		if err != nil {
+			return nil, err
+		}
+		selectors[i] = s
+	}
+	return func(ctx *context, i int, out []ast.Node) []ast.Node {
+		for d, end := i, ctx.index.nodes[i].end; d < end; d++ {
+			if !ctx.visit(ctx.index.nodes[d].node) {
+				break
+			}
+			for _, s := range selectors {
+				out = s(ctx, d, out)
+			}
+		}
+		return out
+	}, nil
+}
+
+// indexedSelector compiles a selector that selects from the node at position i of the indexed document.
+// Selectors that select several members of an object select them in the order of the index.
+func (c compiler) indexedSelector(e ast.Expr) (indexedSegment, error) {
+	switch e := e.(type) {
+	case ast.SelectorWildcard:
+		return func(ctx *context, i int, out []ast.Node) []ast.Node {
+			nodes := ctx.index.nodes
+			for c := i + 1; c < nodes[i].end; c = nodes[c].end {
+				if !ctx.visit(nodes[c].node) {
+					break
+				}
+				out = append(out, nodes[c].node)
+			}
+			return out
+		}, nil
+	case ast.SelectorFilter:
+		p, err := c.logical(e.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx *context, i int, out []ast.Node) []ast.Node {
+			nodes := ctx.index.nodes
+			for c := i + 1; c < nodes[i].end; c = nodes[c].end {
+				if !ctx.visit(ast.Node{Value: nodes[c].node.Value}) {
+					break
+				}
+				if p(ctx, nodes[c].node.Value) {
+					out = append(out, nodes[c].node)
+				}
+			}
+			return out
+		}, nil
+	default:
+		s, err := c.selector(e)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx *context, i int, out []ast.Node) []ast.Node {
+			return s(ctx, ctx.index.nodes[i].node, out)
+		}, nil
+	}
+}
+
+// singularPath returns the steps of a query if all of its segments are singular.
+func singularPath(segments []ast.Expr) (path, bool) {
+	p := singularPrefix(segments)
+	return p, len(p) == len(segments)
+}
+
+// singularPrefix returns the steps of the longest run of singular segments at the start of segments.
+// A segment is singular if it is a child segment with a single name or index selector.
+func singularPrefix(segments []ast.Expr) path {
+	var p path
+	for _, s := range segments {
+		c, ok := s.(ast.SegmentChild)
+		if !ok || len(c.Selectors) != 1 {
+			break
+		}
+		switch selector := c.Selectors[0].(type) {
+		case ast.SelectorName:
+			p = append(p, nameStep(selector.Name))
+		case ast.SelectorIndex:
+			p = append(p, indexStep(selector.Index))
+		default:
+			return p
+		}
+	}
+	return p
+}
@@ -0,0 +1,552 @@
+// Package eval evaluates jsonpath queries by compiling their abstract syntax tree into closures.
+//
+// Compiling resolves everything that is known before a document is seen,
+// such as which selectors apply and the normalized path of member names,
+// so evaluating a query only does the work that depends on the document.
+package eval
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/marcfyk/go-jsonpath/internal/ast"
+)
+
+// ErrUnsupportedExpr is the error type when the compiler encounters
+// an expression it cannot compile at its position in the tree.
+type ErrUnsupportedExpr struct {
+	// Expr is the expression.
+	Expr ast.Expr
+}
+
+func (e ErrUnsupportedExpr) Error() string {
+	return fmt.Sprintf("unsupported expression:%T", e.Expr)
+}
+
+// ErrMaxDepth is the error type when a descendant segment visits a node nested deeper
+// than the MaxDepth of the Options below the node the segment starts from.
+type ErrMaxDepth struct {
+	// Depth is the maximum depth.
+	Depth int
+}
+
+func (e ErrMaxDepth) Error() string {
+	return fmt.Sprintf("document nesting exceeds the maximum depth:%d", e.Depth)
+}
+
+// ErrCycle is the error type when a document contains a value that is its own descendant,
+// e.g. a struct that points to itself.
+type ErrCycle struct {
+	// Type is the Go type of the value.
+	Type reflect.Type
+}
+
+func (e ErrCycle) Error() string {
+	return fmt.Sprintf("document contains a cycle through a value of type:%s", e.Type)
+}
+
+// Limit is a resource limit of an evaluation, see Options.
+type Limit int
+
+const (
+	LimitResults Limit = iota + 1
+	LimitVisited
+	LimitRegexpBytes
+	LimitBytes
+)
+
+func (l Limit) String() string {
+	switch l {
+	case LimitResults:
+		return "max results"
+	case LimitVisited:
+		return "max visited nodes"
+	case LimitRegexpBytes:
+		return "max regexp bytes"
+	case LimitBytes:
+		return "max bytes"
+	default:
+		return "unknown limit"
+	}
+}
+
+// ErrLimitExceeded is the error type when an evaluation uses more of a resource
+// than its limit allows.
+type ErrLimitExceeded struct {
+	// Limit is the limit that is exceeded.
+	Limit Limit
+	// Max is the value of the limit.
+	Max int
+}
+
+func (e ErrLimitExceeded) Error() string {
+	return fmt.Sprintf("evaluation exceeds the %s:%d", e.Limit, e.Max)
+}
+
+// Options configures the evaluation of a Program.
+type Options struct {
+	// ParallelThreshold is the number of children an array or object needs
+	// for a descendant segment to visit them concurrently.
+	// Descendant segments are always visited sequentially if it is 0.
+	ParallelThreshold int
+	// Workers is the maximum number of goroutines visiting descendants concurrently
+	// in one evaluation. It defaults to GOMAXPROCS if it is less than 1.
+	Workers int
+	// MaxDepth is the maximum nesting of the nodes a descendant segment visits,
+	// below the node the segment starts from. There is no maximum if it is 0.
+	MaxDepth int
+	// MaxResults is the maximum number of nodes an evaluation selects.
+	MaxResults int
+	// MaxVisited is the maximum number of nodes the segments of an evaluation visit,
+	// including those of the queries in filters.
+	MaxVisited int
+	// MaxRegexpBytes is the maximum total length of the patterns an evaluation compiles,
+	// which are the patterns of match and search that are not string literals.
+	MaxRegexpBytes int
+	// MaxBytes is the approximate maximum number of bytes an evaluation allocates
+	// for the nodes it visits and the patterns it compiles.
+	MaxBytes int
+	// SortedKeys selects the members of maps in the order of their names,
+	// rather than in the iteration order of the map.
+	SortedKeys bool
+}
+
+// limited reports if any resource limit is set.
+func (o Options) limited() bool {
+	return o.MaxVisited > 0 || o.MaxRegexpBytes > 0 || o.MaxBytes > 0
+}
+
+// Program is a compiled jsonpath query.
+//
+// A Program is safe for concurrent use.
+type Program struct {
+	// segments are the compiled segments of the query, applied in order.
+	segments []segment
+	// values are the segments compiled without locations, for evaluations that only need values.
+	values []segment
+	// trailed returns the segments compiled with trails, for evaluations that edit the nodes they select,
+	// which are compiled the first time they are needed.
+	trailed func() []segment
+	// path is the query if it is a singular query, in which case segments is empty.
+	path path
+	// singular reports if the query is a singular query.
+	singular bool
+	// hoisted are the paths of the hoisted queries, by slot,
+	// which are walked from the root once per evaluation.
+	hoisted []path
+	// options configures the evaluation.
+	options Options
+	// contexts is the pool of contexts reused between evaluations.
+	contexts sync.Pool
+}
+
+// Compile compiles the query, q, into a Program.
+func Compile(q ast.QueryJSONPath, options Options) (*Program, error) {
+	p := &Program{options: options}
+	p.contexts.New = func() any { return &context{pool: &p.contexts} }
+	if path, ok := singularPath(q.Segments); ok {
+		p.path = path
+		p.singular = true
+		return p, nil
+	}
+	c := compiler{pos: locations, options: options, hoisted: &p.hoisted}
+	segments, err := c.segments(q.Segments)
+	if err != nil {
+		return nil, err
+	}
+	values, err := c.valuesOnly().segments(q.Segments)
+	if err != nil {
+		return nil, err
+	}
+	p.segments = segments
+	p.values = values
+	p.trailed = sync.OnceValue(func() []segment {
+		// The query compiled above, so it compiles again without errors, and hoists the same paths,
+		// which are recorded apart so that evaluations reading p.hoisted do not race with them.
+		c := compiler{pos: trails, options: options, hoisted: new([]path)}
+		trailed, _ := c.segments(q.Segments)
+		return trailed
+	})
+	return p, nil
+}
+
+// IsSingular reports if the compiled query is a singular query,
+// which selects at most one node.
+func (p *Program) IsSingular() bool {
+	return p.singular
+}
+
+// Step is a name or index selector of a singular query.
+type Step struct {
+	Name    string
+	Index   int
+	IsIndex bool
+}
+
+// Path returns the steps of the compiled query, if it is a singular query.
+func (p *Program) Path() ([]Step, bool) {
+	if !p.singular {
+		return nil, false
+	}
+	steps := make([]Step, len(p.path))
+	for i, s := range p.path {
+		steps[i] = Step{Name: s.name, Index: s.index, IsIndex: s.isIndex}
+	}
+	return steps, true
+}
+
+// Select returns the nodes selected from the root node, whose value is root.
+func (p *Program) Select(root ast.Value) ([]ast.Node, error) {
+	n := ast.Node{Location: "$", Value: root}
+	if p.singular {
+		// Singular queries are walked directly, which needs no context.
+		n, ok := p.path.walkNode(n, locations)
+		if !ok {
+			return []ast.Node{}, nil
+		}
+		return []ast.Node{n}, nil
+	}
+	return p.selectNodes(p.context(root), n)
+}
+
+// SelectIndex returns the nodes selected from the root node of the indexed document, ix.
+func (p *Program) SelectIndex(ix *Index) ([]ast.Node, error) {
+	if p.singular {
+		return p.Select(ix.Root())
+	}
+	c := p.context(ix.Root())
+	c.index = ix
+	return p.selectNodes(c, ix.nodes[0].node)
+}
+
+// selectNodes returns the nodes selected from the root node, n, and releases the context, c.
+func (p *Program) selectNodes(c *context, n ast.Node) ([]ast.Node, error) {
+	out := c.run(p.segments, n, c.buffer(), p.options.MaxResults)
+	err := c.err
+	var nodes []ast.Node
+	if err == nil {
+		nodes = slices.Clone(out)
+	}
+	c.release(out)
+	p.release(c)
+	return nodes, err
+}
+
+// SelectSteps returns the steps from the root to the nodes selected from the root node, whose value is root,
+// in the order Select selects them. The steps are recorded as the nodes are visited, rather than rendered
+// as locations, and indices are normalized, so they are the steps of the locations Select returns.
+func (p *Program) SelectSteps(root ast.Value) ([][]Step, error) {
+	n := ast.Node{Value: root}
+	if p.singular {
+		n, ok := p.path.walkNode(n, trails)
+		if !ok {
+			return [][]Step{}, nil
+		}
+		return [][]Step{trailSteps(ast.TrailOf(n))}, nil
+	}
+	c := p.context(root)
+	out := c.run(p.trailed(), n, c.buffer(), p.options.MaxResults)
+	err := c.err
+	var steps [][]Step
+	if err == nil {
+		steps = make([][]Step, len(out))
+		for i, n := range out {
+			steps[i] = trailSteps(ast.TrailOf(n))
+		}
+	}
+	c.release(out)
+	p.release(c)
+	return steps, err
+}
+
+// trailSteps returns the steps from the root along the trail, t.
+func trailSteps(t *ast.Trail) []Step {
+	depth := 0
+	for e := t; e != nil; e = e.Parent {
+		depth++
+	}
+	steps := make([]Step, depth)
+	for ; t != nil; t = t.Parent {
+		depth--
+		steps[depth] = Step{Name: t.Name, Index: t.Index, IsIndex: t.IsIndex}
+	}
+	return steps
+}
+
+// SelectValues returns the values of the nodes selected from the root node, whose value is root.
+//
+// Unlike Select, the locations of the nodes are never rendered.
+func (p *Program) SelectValues(root ast.Value) ([]ast.Value, error) {
+	if p.singular {
+		v, ok := p.path.walk(root)
+		if !ok {
+			return []ast.Value{}, nil
+		}
+		return []ast.Value{v}, nil
+	}
+	return p.selectValues(p.context(root), p.values, ast.Node{Value: root})
+}
+
+// SelectValuesIndex returns the values of the nodes selected from the root node of the indexed document, ix.
+//
+// Indexed documents have their locations rendered already, which descendant segments need to find nodes in the index,
+// so the segments that keep locations are evaluated.
+func (p *Program) SelectValuesIndex(ix *Index) ([]ast.Value, error) {
+	if p.singular {
+		return p.SelectValues(ix.Root())
+	}
+	c := p.context(ix.Root())
+	c.index = ix
+	return p.selectValues(c, p.segments, ix.nodes[0].node)
+}
+
+// selectValues returns the values of the nodes selected by the segments from the root node, n,
+// and releases the context, c.
+func (p *Program) selectValues(c *context, segments []segment, n ast.Node) ([]ast.Value, error) {
+	out := c.run(segments, n, c.buffer(), p.options.MaxResults)
+	err := c.err
+	var values []ast.Value
+	if err == nil {
+		values = make([]ast.Value, len(out))
+		for i, n := range out {
+			values[i] = n.Value
+		}
+	}
+	c.release(out)
+	p.release(c)
+	return values, err
+}
+
+// context returns a context from the pool for evaluating the document at root.
+func (p *Program) context(root ast.Value) *context {
+	c := p.contexts.Get().(*context)
+	c.root = root
+	if p.options.limited() {
+		c.options = &p.options
+		c.budget = &c.own
+	}
+	for _, h := range p.hoisted {
+		v, ok := h.walk(root)
+		if !ok {
+			v = ast.Nothing
+		}
+		c.hoisted = append(c.hoisted, v)
+	}
+	if p.options.ParallelThreshold > 0 {
+		workers := p.options.Workers
+		if workers < 1 {
+			workers = runtime.GOMAXPROCS(0)
+		}
+		// The evaluating goroutine is a worker too, so it does not need a token.
+		c.workers = make(chan struct{}, workers-1)
+	}
+	return c
+}
+
+// release returns a context obtained from context to the pool.
+func (p *Program) release(c *context) {
+	c.err = nil
+	c.options = nil
+	c.budget = nil
+	c.own = budget{}
+	c.root = nil
+	c.index = nil
+	clear(c.hoisted)
+	c.hoisted = c.hoisted[:0]
+	c.workers = nil
+	p.contexts.Put(c)
+}
+
+// maxRegexps is the number of dynamically compiled patterns a context keeps.
+const maxRegexps = 64
+
+// context holds the state of one evaluation.
+//
+// Contexts are pooled by Program, so the buffers they hold
+// are reused between evaluations.
+type context struct {
+	// pool is the pool the context belongs to.
+	pool *sync.Pool
+	// root is the value of the root node.
+	root ast.Value
+	// index is the indexed document being evaluated, if it is indexed.
+	index *Index
+	// hoisted are the values of the hoisted queries, by slot.
+	hoisted []ast.Value
+	// workers holds a token for every goroutine visiting descendants concurrently,
+	// or is nil if descendants are visited sequentially.
+	workers chan struct{}
+	// err is the error that stopped the evaluation, if any.
+	err error
+	// options are the options of the evaluation if it has resource limits, otherwise nil.
+	options *Options
+	// budget is the resources used by the evaluation, shared by the contexts of its goroutines,
+	// if it has resource limits.
+	budget *budget
+	// own is the budget of an evaluation started with this context.
+	own budget
+	// buffers are the nodelists available for reuse.
+	buffers [][]ast.Node
+	// stacks are the stacks of descendant segments available for reuse.
+	stacks [][]frame
+	// regexps are the patterns compiled from values in documents.
+	regexps map[regexpKey]*regexp.Regexp
+}
+
+// regexpKey identifies a compiled pattern.
+type regexpKey struct {
+	pattern  string
+	anchored bool
+}
+
+// fork returns a context for evaluating part of the same evaluation on another goroutine.
+func (c *context) fork() *context {
+	f := c.pool.Get().(*context)
+	f.root = c.root
+	f.options = c.options
+	f.budget = c.budget
+	f.index = c.index
+	f.hoisted = c.hoisted
+	f.workers = c.workers
+	return f
+}
+
+// join returns a context obtained from fork to its pool.
+func (c *context) join() {
+	c.err = nil
+	c.options = nil
+	c.budget = nil
+	c.root = nil
+	c.index = nil
+	// The hoisted values are shared with the forking context.
+	c.hoisted = nil
+	c.workers = nil
+	c.pool.Put(c)
+}
+
+// buffer returns an empty nodelist.
+func (c *context) buffer() []ast.Node {
+	n := len(c.buffers)
+	if n == 0 {
+		return make([]ast.Node, 0, 8)
+	}
+	b := c.buffers[n-1]
+	c.buffers = c.buffers[:n-1]
+	return b
+}
+
+// release returns a nodelist obtained from buffer for reuse.
+// The nodes are cleared so that pooled contexts do not retain documents.
+func (c *context) release(b []ast.Node) {
+	clear(b)
+	c.buffers = append(c.buffers, b[:0])
+}
+
+// fail stops the evaluation with err, unless it is already stopped.
+func (c *context) fail(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// budget is the resources used by an evaluation.
+type budget struct {
+	visited     atomic.Int64
+	regexpBytes atomic.Int64
+	bytes       atomic.Int64
+}
+
+// nodeBytes is the approximate size of a node, a string header, an interface and a pointer,
+// excluding the bytes of its location.
+const nodeBytes = 40
+
+// visit accounts for a node that a segment visits, and reports if the evaluation continues.
+func (c *context) visit(n ast.Node) bool {
+	if c.options == nil {
+		return true
+	}
+	return c.charge(1, nodeBytes+int64(len(n.Location)))
+}
+
+// charge adds visited nodes and allocated bytes to the budget of the evaluation,
+// and reports if the evaluation continues.
+func (c *context) charge(visited, bytes int64) bool {
+	if limit := c.options.MaxVisited; limit > 0 && c.budget.visited.Add(visited) > int64(limit) {
+		c.fail(ErrLimitExceeded{Limit: LimitVisited, Max: limit})
+	}
+	if limit := c.options.MaxBytes; limit > 0 && c.budget.bytes.Add(bytes) > int64(limit) {
+		c.fail(ErrLimitExceeded{Limit: LimitBytes, Max: limit})
+	}
+	return c.err == nil
+}
+
+// chargeRegexp accounts for compiling a pattern, and reports if the evaluation continues.
+func (c *context) chargeRegexp(pattern string) bool {
+	if c.options == nil {
+		return true
+	}
+	n := int64(len(pattern))
+	if limit := c.options.MaxRegexpBytes; limit > 0 && c.budget.regexpBytes.Add(n) > int64(limit) {
+		c.fail(ErrLimitExceeded{Limit: LimitRegexpBytes, Max: limit})
+		return false
+	}
+	return c.charge(0, n)
+}
+
+// frames returns an empty stack for a descendant segment.
+func (c *context) frames() []frame {
+	n := len(c.stacks)
+	if n == 0 {
+		return make([]frame, 0, 16)
+	}
+	s := c.stacks[n-1]
+	c.stacks = c.stacks[:n-1]
+	return s
+}
+
+// releaseFrames returns a stack obtained from frames for reuse.
+func (c *context) releaseFrames(s []frame) {
+	clear(s)
+	c.stacks = append(c.stacks, s[:0])
+}
+
+// run applies the segments in order to the node, n, and appends the resulting nodes to out.
+// The evaluation fails if more than maxResults nodes are appended, unless it is 0.
+func (c *context) run(segments []segment, n ast.Node, out []ast.Node, maxResults int) []ast.Node {
+	if len(segments) == 0 {
+		return append(out, n)
+	}
+	curr := append(c.buffer(), n)
+	next := c.buffer()
+	last := len(segments) - 1
+	for _, s := range segments[:last] {
+		for _, n := range curr {
+			next = s(c, n, next)
+		}
+		clear(curr)
+		curr, next = next, curr[:0]
+		if c.err != nil {
+			break
+		}
+	}
+	if c.err == nil {
+		start := len(out)
+		for _, n := range curr {
+			out = segments[last](c, n, out)
+			if maxResults > 0 && len(out)-start > maxResults {
+				c.fail(ErrLimitExceeded{Limit: LimitResults, Max: maxResults})
+			}
+			if c.err != nil {
+				break
+			}
+		}
+	}
+	c.release(curr)
+	c.release(next)
+	return out
+}
@@ -0,0 +1,382 @@
+package eval
+
+import (
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/marcfyk/go-jsonpath/internal/ast"
+	"github.com/marcfyk/go-jsonpath/internal/iregexp"
+	"github.com/marcfyk/go-jsonpath/internal/model"
+)
+
+// predicate is a compiled logical expression, which tests the current node's value, v.
+type predicate func(c *context, v ast.Value) bool
+
+// valueFunc is a compiled expression of ValueType, evaluated against the current node's value, v.
+// It returns ast.Nothing if the expression yields no value.
+type valueFunc func(c *context, v ast.Value) ast.Value
+
+// nodesFunc is a compiled expression of NodesType, evaluated against the current node's value, v.
+// It appends the nodes it yields to out.
+type nodesFunc func(c *context, v ast.Value, out []ast.Node) []ast.Node
+
+// logical compiles a logical expression.
+func (c compiler) logical(e ast.Expr) (predicate, error) {
+	switch e := e.(type) {
+	case ast.ExprLogicalOr:
+		return c.logicalAll(e.Exprs, true)
+	case ast.ExprLogicalAnd:
+		return c.logicalAll(e.Exprs, false)
+	case ast.ExprLogicalNot:
+		p, err := c.logical(e.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx *context, v ast.Value) bool {
+			return !p(ctx, v)
+		}, nil
+	case ast.ExprParen:
+		return c.logical(e.Expr)
+	case ast.ExprLogicalConstant:
+		v := e.Value
+		return func(*context, ast.Value) bool {
+			return v
+		}, nil
+	case ast.ExprComparison:
+		return c.comparison(e)
+	case ast.QueryRel, ast.QueryJSONPath:
+		return c.exists(e)
+	case ast.QueryHoisted:
+		v, err := c.value(e)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx *context, _ ast.Value) bool {
+			return v(ctx, nil) != ast.Nothing
+		}, nil
+	case ast.FuncMatch:
+		return c.regexp(e.Expr, e.Pattern, e.Regex, true)
+	case ast.FuncSearch:
+		return c.regexp(e.Expr, e.Pattern, e.Regex, false)
+	default:
+		return nil, ErrUnsupportedExpr{Expr: e}
+	}
+}
+
+// logicalAll compiles the operands of a logical or, if isOr is true, or a logical and.
+// Both short-circuit, returning as soon as an operand decides the result.
+func (c compiler) logicalAll(exprs []ast.Expr, isOr bool) (predicate, error) {
+	predicates := make([]predicate, len(exprs))
+	for i, e := range exprs {
+		p, err := c.logical(e)
+		if err != nil {
+			return nil, err
+		}
+		predicates[i] = p
+	}
+	// The parser wraps every operand in both an or and an and,
+	// so single operands are unwrapped rather than looped over.
+	if len(predicates) == 1 {
+		return predicates[0], nil
+	}
+	return func(ctx *context, v ast.Value) bool {
+		for _, p := range predicates {
+			if p(ctx, v) == isOr {
+				return isOr
+			}
+		}
+		return !isOr
+	}, nil
+}
+
+func (c compiler) comparison(e ast.ExprComparison) (predicate, error) {
+	left, err := c.value(e.Left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := c.value(e.Right)
+	if err != nil {
+		return nil, err
+	}
+	f := e.Op.Func()
+	return func(ctx *context, v ast.Value) bool {
+		return f(left(ctx, v), right(ctx, v))
+	}, nil
+}
+
+// exists compiles a test of whether a query selects any node.
+func (c compiler) exists(e ast.Expr) (predicate, error) {
+	if p, isRel, ok := singularQuery(e); ok {
+		return func(ctx *context, v ast.Value) bool {
+			if !isRel {
+				v = ctx.root
+			}
+			_, ok := p.walk(v)
+			return ok
+		}, nil
+	}
+	nodes, err := c.nodes(e)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx *context, v ast.Value) bool {
+		out := nodes(ctx, v, ctx.buffer())
+		ok := len(out) > 0
+		ctx.release(out)
+		return ok
+	}, nil
+}
+
+// singularQuery returns the steps of e if it is a query with only singular segments,
+// and whether it is relative to the current node.
+func singularQuery(e ast.Expr) (path, bool, bool) {
+	switch e := e.(type) {
+	case ast.QueryRel:
+		p, ok := singularPath(e.Segments)
+		return p, true, ok
+	case ast.QueryJSONPath:
+		p, ok := singularPath(e.Segments)
+		return p, false, ok
+	default:
+		return nil, false, false
+	}
+}
+
+// nodes compiles a filter query.
+func (c compiler) nodes(e ast.Expr) (nodesFunc, error) {
+	var segments []ast.Expr
+	isRel := false
+	switch e := e.(type) {
+	case ast.QueryRel:
+		segments = e.Segments
+		isRel = true
+	case ast.QueryJSONPath:
+		segments = e.Segments
+	default:
+		return nil, ErrUnsupportedExpr{Expr: e}
+	}
+	compiled, err := c.valuesOnly().segments(segments)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx *context, v ast.Value, out []ast.Node) []ast.Node {
+		if !isRel {
+			v = ctx.root
+		}
+		return ctx.run(compiled, ast.Node{Value: v}, out, 0)
+	}, nil
+}
+
+// value compiles an expression of ValueType.
+func (c compiler) value(e ast.Expr) (valueFunc, error) {
+	switch e := e.(type) {
+	case ast.Literal:
+		v := e.Value
+		return func(*context, ast.Value) ast.Value {
+			return v
+		}, nil
+	case ast.QuerySingularRel:
+		p, err := singularSteps(e.Segments)
+		if err != nil {
+			return nil, err
+		}
+		return func(_ *context, v ast.Value) ast.Value {
+			if v, ok := p.walk(v); ok {
+				return v
+			}
+			return ast.Nothing
+		}, nil
+	case ast.QuerySingularAbs:
+		p, err := singularSteps(e.Segments)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx *context, _ ast.Value) ast.Value {
+			if v, ok := p.walk(ctx.root); ok {
+				return v
+			}
+			return ast.Nothing
+		}, nil
+	case ast.QueryHoisted:
+		p, err := singularSteps(e.Query.Segments)
+		if err != nil {
+			return nil, err
+		}
+		c.hoist(e.Slot, p)
+		slot := e.Slot
+		return func(ctx *context, _ ast.Value) ast.Value {
+			return ctx.hoisted[slot]
+		}, nil
+	case ast.FuncLength:
+		arg, err := c.value(e.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx *context, v ast.Value) ast.Value {
+			return length(arg(ctx, v))
+		}, nil
+	case ast.FuncCount:
+		return c.count(e.Expr)
+	case ast.FuncValue:
+		return c.single(e.Expr)
+	default:
+		return nil, ErrUnsupportedExpr{Expr: e}
+	}
+}
+
+// hoist records the path of the hoisted query in slot,
+// so that the value of the query is walked once per evaluation.
+func (c compiler) hoist(slot int, p path) {
+	for len(*c.hoisted) <= slot {
+		*c.hoisted = append(*c.hoisted, nil)
+	}
+	(*c.hoisted)[slot] = p
+}
+
+// singularSteps converts the segments of a singular query into steps.
+func singularSteps(segments []ast.ExprSingle) (path, error) {
+	p := make(path, len(segments))
+	for i, s := range segments {
+		switch s := s.(type) {
+		case ast.SegmentName:
+			p[i] = nameStep(s.Name)
+		case ast.SegmentIndex:
+			p[i] = indexStep(s.Index)
+		default:
+			return nil, ErrUnsupportedExpr{Expr: s}
+		}
+	}
+	return p, nil
+}
+
+// count compiles the count function, which returns the number of nodes in its argument.
+func (c compiler) count(e ast.Expr) (valueFunc, error) {
+	switch e.(type) {
+	case ast.QueryRel, ast.QueryJSONPath:
+		nodes, err := c.nodes(e)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx *context, v ast.Value) ast.Value {
+			out := nodes(ctx, v, ctx.buffer())
+			n := len(out)
+			ctx.release(out)
+			return float64(n)
+		}, nil
+	default:
+		// A function of ValueType is a nodelist of its result, if any.
+		arg, err := c.value(e)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx *context, v ast.Value) ast.Value {
+			if arg(ctx, v) == ast.Nothing {
+				return float64(0)
+			}
+			return float64(1)
+		}, nil
+	}
+}
+
+// single compiles the value function, which returns the value of the only node in its argument.
+func (c compiler) single(e ast.Expr) (valueFunc, error) {
+	switch e.(type) {
+	case ast.QueryRel, ast.QueryJSONPath:
+		nodes, err := c.nodes(e)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx *context, v ast.Value) ast.Value {
+			out := nodes(ctx, v, ctx.buffer())
+			r := ast.Nothing
+			if len(out) == 1 {
+				r = out[0].Value
+			}
+			ctx.release(out)
+			return r
+		}, nil
+	default:
+		// A function of ValueType is a nodelist of its result, if any.
+		return c.value(e)
+	}
+}
+
+// length returns the length of a string, array or object.
+// The length of a string is its number of Unicode scalar values.
+func length(v ast.Value) ast.Value {
+	if v == ast.Nothing {
+		return ast.Nothing
+	}
+	switch m := model.Of(v); m.Kind() {
+	case model.String:
+		return float64(utf8.RuneCountInString(m.Scalar().(string)))
+	case model.Array, model.Object:
+		return float64(m.Len())
+	default:
+		return ast.Nothing
+	}
+}
+
+// regexp compiles the match function if anchored is true, otherwise the search function.
+//
+// rg is the compiled pattern if it is a string literal.
+// Patterns that are not valid I-Regexps evaluate to false.
+func (c compiler) regexp(arg, pattern ast.Expr, rg *regexp.Regexp, anchored bool) (predicate, error) {
+	value, err := c.value(arg)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := pattern.(ast.Literal); ok {
+		return func(ctx *context, v ast.Value) bool {
+			s, ok := str(value(ctx, v))
+			return ok && rg != nil && rg.MatchString(s)
+		}, nil
+	}
+	dynamic, err := c.value(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx *context, v ast.Value) bool {
+		s, ok := str(value(ctx, v))
+		if !ok {
+			return false
+		}
+		p, ok := str(dynamic(ctx, v))
+		if !ok {
+			return false
+		}
+		rg := ctx.regexp(p, anchored)
+		return rg != nil && rg.MatchString(s)
+	}, nil
+}
+
+// str returns the value of a string.
+func str(v ast.Value) (string, bool) {
+	if v == ast.Nothing {
+		return "", false
+	}
+	s, ok := model.Of(v).Scalar().(string)
+	return s, ok
+}
+
+// regexp returns the compiled pattern, or nil if it is not a valid I-Regexp.
+// Compiled patterns are kept by the context so that they can be reused by later evaluations,
+// and only the patterns compiled by this evaluation count towards its limits.
+func (c *context) regexp(pattern string, anchored bool) *regexp.Regexp {
+	k := regexpKey{pattern: pattern, anchored: anchored}
+	if rg, ok := c.regexps[k]; ok {
+		return rg
+	}
+	if !c.chargeRegexp(pattern) {
+		return nil
+	}
+	if c.regexps == nil || len(c.regexps) == maxRegexps {
+		c.regexps = make(map[regexpKey]*regexp.Regexp)
+	}
+	rg, err := iregexp.Compile(pattern, anchored)
+	if err != nil {
+		rg = nil
+	}
+	c.regexps[k] = rg
+	return rg
+}
@@ -0,0 +1,178 @@
+package eval
+
+import (
+	"reflect"
+	"slices"
+
+	"github.com/marcfyk/go-jsonpath/internal/ast"
+	"github.com/marcfyk/go-jsonpath/internal/model"
+)
+
+// Index is a document whose nodes are laid out in the order descendant segments visit them,
+// with their locations rendered once.
+//
+// The descendants of a node are the positions after it up to the end of its subtree,
+// so descendant segments evaluated against an Index are loops over a range of positions
+// rather than walks of the document, and members are looked up by name.
+//
+// An Index is never modified once built, so it is safe for concurrent use.
+type Index struct {
+	// nodes are the nodes of the document in pre-order.
+	nodes []indexNode
+	// positions are the positions of the nodes, by location.
+	positions map[ast.Location]int
+	// names are the object members, by name, ordered by the position of their parent.
+	names map[string][]member
+}
+
+type indexNode struct {
+	node ast.Node
+	// end is the position after the last descendant of the node.
+	end int
+	// height is the number of levels of descendants below the node.
+	height int
+}
+
+// member is an object member, at position child, of the object at position parent.
+type member struct {
+	parent int
+	child  int
+}
+
+// NewIndex builds the Index of the document at root.
+//
+// Object members are laid out in the order of their object, or the order of their names for maps,
+// which is the order descendant segments select them in.
+//
+// Nodes are laid out from a stack rather than by recursion,
+// so deeply nested documents cannot overflow the goroutine stack.
+// NewIndex returns ErrCycle if a node of the document is its own descendant.
+func NewIndex(root ast.Value) (*Index, error) {
+	ix := &Index{
+		positions: make(map[ast.Location]int),
+		names:     make(map[string][]member),
+	}
+	// parents are the positions of the parents of the nodes, or -1 for the root.
+	var parents []int
+	type pending struct {
+		node   ast.Node
+		parent int
+		depth  int
+		// name is the member name of the node, if its parent is an object.
+		name     string
+		isMember bool
+		// leave marks the end of the descendants of the node.
+		leave bool
+	}
+	// ancestors are the arrays and objects nested deeper than model.CycleDepth
+	// that the node being laid out descends from.
+	var ancestors map[model.ID]bool
+	stack := []pending{{node: ast.Node{Location: "$", Value: root}, parent: -1}}
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if p.leave {
+			id, _ := model.Identify(p.node.Value)
+			delete(ancestors, id)
+			continue
+		}
+		i := len(ix.nodes)
+		ix.nodes = append(ix.nodes, indexNode{node: p.node, end: i + 1})
+		parents = append(parents, p.parent)
+		ix.positions[p.node.Location] = i
+		if p.isMember {
+			ix.names[p.name] = append(ix.names[p.name], member{parent: p.parent, child: i})
+		}
+		m := model.Of(p.node.Value)
+		if m.Kind() != model.Array && m.Kind() != model.Object {
+			continue
+		}
+		if p.depth > model.CycleDepth {
+			if id, ok := model.Identify(p.node.Value); ok {
+				if ancestors[id] {
+					return nil, ErrCycle{Type: reflect.TypeOf(p.node.Value)}
+				}
+				if ancestors == nil {
+					ancestors = make(map[model.ID]bool)
+				}
+				ancestors[id] = true
+				stack = append(stack, pending{node: ast.Node{Value: p.node.Value}, leave: true})
+			}
+		}
+		if m.Kind() == model.Array {
+			// Children are pushed in reverse, so that they are laid out in order.
+			for j := m.Len() - 1; j >= 0; j-- {
+				stack = append(stack, pending{
+					node:   childIndex(p.node, j, m.Index(j), locations),
+					parent: i,
+					depth:  p.depth + 1,
+				})
+			}
+			continue
+		}
+		// Members are reversed once pushed, so that they are laid out in the order of the object.
+		start := len(stack)
+		m.SortedMembers(func(k string, e ast.Value) bool {
+			stack = append(stack, pending{
+				node:     childName(p.node, k, e, locations),
+				parent:   i,
+				depth:    p.depth + 1,
+				name:     k,
+				isMember: true,
+			})
+			return true
+		})
+		slices.Reverse(stack[start:])
+	}
+	// Descendants are laid out after their ancestors,
+	// so visiting the nodes backwards completes every subtree before its parent.
+	for i := len(ix.nodes) - 1; i > 0; i-- {
+		parent := &ix.nodes[parents[i]]
+		parent.end = max(parent.end, ix.nodes[i].end)
+		parent.height = max(parent.height, ix.nodes[i].height+1)
+	}
+	for _, members := range ix.names {
+		slices.SortStableFunc(members, func(a, b member) int {
+			return a.parent - b.parent
+		})
+	}
+	return ix, nil
+}
+
+// Root returns the value of the root node of the document.
+func (ix *Index) Root() ast.Value {
+	return ix.nodes[0].node.Value
+}
+
+// position returns the position of the node, n, if it is a node of the document.
+func (ix *Index) position(n ast.Node) (int, bool) {
+	i, ok := ix.positions[n.Location]
+	return i, ok
+}
+
+// members appends the members named one of names, of the node at position i and its descendants, to out.
+// Members are ordered by the position of their parent, then by the order of names.
+func (ix *Index) members(names []string, i int, out []ast.Node) []ast.Node {
+	end := ix.nodes[i].end
+	var found []member
+	for _, name := range names {
+		members := ix.names[name]
+		j, _ := slices.BinarySearchFunc(members, i, func(m member, i int) int {
+			return m.parent - i
+		})
+		for ; j < len(members) && members[j].parent < end; j++ {
+			if len(names) == 1 {
+				out = append(out, ix.nodes[members[j].child].node)
+				continue
+			}
+			found = append(found, members[j])
+		}
+	}
+	slices.SortStableFunc(found, func(a, b member) int {
+		return a.parent - b.parent
+	})
+	for _, m := range found {
+		out = append(out, ix.nodes[m.child].node)
+	}
+	return out
+}
@@ -0,0 +1,59 @@
+package eval
+
+import (
+	"sync"
+
+	"github.com/marcfyk/go-jsonpath/internal/ast"
+)
+
+// parallel calls visit for the children 0..n-1 of a node and appends their results to out,
+// in the same order as if the children were visited sequentially.
+//
+// The children are split into one chunk per worker. A chunk is visited on a new goroutine
+// if a worker is free, otherwise it is visited on the calling goroutine.
+func (c *context) parallel(n int, visit func(c *context, i int, out []ast.Node) []ast.Node, out []ast.Node) []ast.Node {
+	chunks := min(n, cap(c.workers)+1)
+	results := make([][]ast.Node, chunks)
+	errs := make([]error, chunks)
+	var wg sync.WaitGroup
+	for k := range chunks {
+		lo, hi := k*n/chunks, (k+1)*n/chunks
+		visitChunk := func(c *context) {
+			var r []ast.Node
+			for i := lo; i < hi; i++ {
+				r = visit(c, i, r)
+			}
+			results[k] = r
+		}
+		// The last chunk is always visited by the calling goroutine,
+		// which would otherwise sit idle until the other chunks are done.
+		if k == chunks-1 {
+			visitChunk(c)
+			break
+		}
+		select {
+		case c.workers <- struct{}{}:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				f := c.fork()
+				visitChunk(f)
+				errs[k] = f.err
+				f.join()
+				<-c.workers
+			}()
+		default:
+			visitChunk(c)
+		}
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			c.fail(err)
+		}
+	}
+	for _, r := range results {
+		out = append(out, r...)
+	}
+	return out
+}
@@ -0,0 +1,129 @@
+package eval
+
+import (
+	"github.com/marcfyk/go-jsonpath/internal/ast"
+	"github.com/marcfyk/go-jsonpath/internal/model"
+)
+
+// step is a name or index selector of a singular query.
+type step struct {
+	name    string
+	index   int
+	isIndex bool
+	// text is the normalized path of the step if it does not depend on the document,
+	// i.e. the step is a name or a non-negative index.
+	text string
+}
+
+func nameStep(name string) step {
+	return step{name: name, text: string(ast.AppendName(nil, name))}
+}
+
+func indexStep(index int) step {
+	s := step{index: index, isIndex: true}
+	if index >= 0 {
+		s.text = string(ast.AppendIndex(nil, index))
+	}
+	return s
+}
+
+// path is the steps of a singular query.
+type path []step
+
+// walk returns the value selected by the path from v.
+func (p path) walk(v ast.Value) (ast.Value, bool) {
+	for _, s := range p {
+		var ok bool
+		if v, _, ok = s.apply(v); !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// walkNode returns the node selected by the path from n, whose position is recorded as pos records it.
+func (p path) walkNode(n ast.Node, pos positions) (ast.Node, bool) {
+	switch pos {
+	case locations:
+		var scratch [128]byte
+		b := append(scratch[:0], n.Location...)
+		v := n.Value
+		for _, s := range p {
+			var i int
+			var ok bool
+			if v, i, ok = s.apply(v); !ok {
+				return ast.Node{}, false
+			}
+			if s.text != "" {
+				b = append(b, s.text...)
+			} else {
+				b = ast.AppendIndex(b, i)
+			}
+		}
+		return ast.Node{Location: ast.Location(b), Value: v}, true
+	case trails:
+		t, v := ast.TrailOf(n), n.Value
+		for _, s := range p {
+			var i int
+			var ok bool
+			if v, i, ok = s.apply(v); !ok {
+				return ast.Node{}, false
+			}
+			if s.isIndex {
+				t = &ast.Trail{Parent: t, Index: i, IsIndex: true}
+			} else {
+				t = &ast.Trail{Parent: t, Name: s.name}
+			}
+		}
+		return ast.Trailed(v, t), true
+	default:
+		v, ok := p.walk(n.Value)
+		return ast.Node{Value: v}, ok
+	}
+}
+
+// apply returns the value selected by the step from v,
+// along with the normalized index of the element if the step is an index.
+func (s step) apply(v ast.Value) (ast.Value, int, bool) {
+	m := model.Of(v)
+	if s.isIndex {
+		if m.Kind() != model.Array {
+			return nil, 0, false
+		}
+		n := m.Len()
+		i := normalize(s.index, n)
+		if i < 0 || n <= i {
+			return nil, 0, false
+		}
+		return m.Index(i), i, true
+	}
+	e, ok := m.Member(s.name)
+	return e, 0, ok
+}
+
+// childIndex returns the node of the element, e, at index, i, of the array at n, whose position is recorded as pos records it.
+func childIndex(n ast.Node, i int, e ast.Value, pos positions) ast.Node {
+	switch pos {
+	case locations:
+		var scratch [128]byte
+		return ast.Node{Location: ast.Location(ast.AppendIndex(append(scratch[:0], n.Location...), i)), Value: e}
+	case trails:
+		return ast.Trailed(e, &ast.Trail{Parent: ast.TrailOf(n), Index: i, IsIndex: true})
+	default:
+		return ast.Node{Value: e}
+	}
+}
+
+// childName returns the node of the member, name, whose value is e, of the object at n,
+// whose position is recorded as pos records it.
+func childName(n ast.Node, name string, e ast.Value, pos positions) ast.Node {
+	switch pos {
+	case locations:
+		var scratch [128]byte
+		return ast.Node{Location: ast.Location(ast.AppendName(append(scratch[:0], n.Location...), name)), Value: e}
+	case trails:
+		return ast.Trailed(e, &ast.Trail{Parent: ast.TrailOf(n), Name: name})
+	default:
+		return ast.Node{Value: e}
+	}
+}
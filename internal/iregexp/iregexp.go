@@ -0,0 +1,125 @@
+// Package iregexp compiles I-Regexp patterns (RFC9485) into Go regular expressions.
+//
+// I-Regexp is an interoperable subset of regular expression syntax.
+// Most of it is shared with the RE2 syntax of package regexp, but
+// some constructs differ in meaning and have to be translated:
+//   - "." matches any codepoint except line feed and carriage return.
+//   - "^" and "$" are ordinary characters rather than anchors.
+//
+// Constructs outside of I-Regexp, such as flag groups, lazy quantifiers
+// or escapes like \d, are rejected rather than passed through.
+package iregexp
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalidPattern is the error type when a pattern is not a valid I-Regexp.
+type ErrInvalidPattern struct {
+	// Pattern is the I-Regexp pattern.
+	Pattern string
+	// Index is the byte offset where the pattern is invalid.
+	Index int
+}
+
+func (e ErrInvalidPattern) Error() string {
+	return fmt.Sprintf("invalid I-Regexp pattern:%q; found at index:%d", e.Pattern, e.Index)
+}
+
+// singleCharEscapes are the characters that may follow a backslash.
+const singleCharEscapes = `()*+-.?[\]^{|}nrt`
+
+// Compile translates pattern into a regexp.Regexp.
+//
+// If anchored is true, the regexp must match the entire input, as required by the match function.
+// Otherwise, the regexp matches any substring of the input, as required by the search function.
+func Compile(pattern string, anchored bool) (*regexp.Regexp, error) {
+	translated, err := translate(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if anchored {
+		translated = `\A(?:` + translated + `)\z`
+	}
+	return regexp.Compile(translated)
+}
+
+// translate rewrites an I-Regexp pattern into the equivalent RE2 syntax.
+func translate(pattern string) (string, error) {
+	var b strings.Builder
+	b.Grow(len(pattern))
+	inClass := false
+	// quantified reports if the previous atom already has a quantifier.
+	quantified := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\':
+			if i+1 == len(pattern) {
+				return "", ErrInvalidPattern{Pattern: pattern, Index: i}
+			}
+			e := pattern[i+1]
+			switch {
+			case strings.IndexByte(singleCharEscapes, e) >= 0:
+				b.WriteString(pattern[i : i+2])
+				i++
+			case e == 'p' || e == 'P':
+				end := strings.IndexByte(pattern[i:], '}')
+				if i+2 == len(pattern) || pattern[i+2] != '{' || end < 0 {
+					return "", ErrInvalidPattern{Pattern: pattern, Index: i}
+				}
+				b.WriteString(pattern[i : i+end+1])
+				i += end
+			default:
+				return "", ErrInvalidPattern{Pattern: pattern, Index: i}
+			}
+			quantified = false
+		case inClass:
+			if c == ']' {
+				inClass = false
+			}
+			b.WriteByte(c)
+		case c == '[':
+			inClass = true
+			b.WriteByte(c)
+			quantified = false
+		case c == '.':
+			b.WriteString(`[^\n\r]`)
+			quantified = false
+		case c == '^' || c == '$':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+			quantified = false
+		case c == '*' || c == '+' || c == '?' || c == '{':
+			if quantified {
+				return "", ErrInvalidPattern{Pattern: pattern, Index: i}
+			}
+			if c == '{' {
+				end := strings.IndexByte(pattern[i:], '}')
+				if end < 0 {
+					return "", ErrInvalidPattern{Pattern: pattern, Index: i}
+				}
+				b.WriteString(pattern[i : i+end+1])
+				i += end
+			} else {
+				b.WriteByte(c)
+			}
+			quantified = true
+		case c == '(':
+			if strings.HasPrefix(pattern[i+1:], "?") {
+				return "", ErrInvalidPattern{Pattern: pattern, Index: i}
+			}
+			b.WriteByte(c)
+			quantified = false
+		default:
+			b.WriteByte(c)
+			quantified = false
+		}
+	}
+	if inClass {
+		return "", ErrInvalidPattern{Pattern: pattern, Index: len(pattern)}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,59 @@
+package iregexp_test
+
+import (
+	"testing"
+
+	"github.com/marcfyk/go-jsonpath/internal/iregexp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern  string
+		input    string
+		expected bool
+	}{
+		{"a.c", "abc", true},
+		{"a.c", "a\nc", false},
+		{"a.c", "a\rc", false},
+		{"a.c", "xabcx", false},
+		{"[^a]", "\n", true},
+		{"a^b$", "a^b$", true},
+		{`\p{Lu}+`, "ABC", true},
+		{`\p{Lu}+`, "AbC", false},
+		{"a{2,3}", "aaa", true},
+		{`\.`, ".", true},
+	}
+	for _, c := range cases {
+		t.Run(c.pattern, func(t *testing.T) {
+			rg, err := iregexp.Compile(c.pattern, true)
+			assert.Nil(t, err)
+			assert.Equal(t, c.expected, rg.MatchString(c.input))
+		})
+	}
+}
+
+func TestSearch(t *testing.T) {
+	rg, err := iregexp.Compile("b.", false)
+	assert.Nil(t, err)
+	assert.True(t, rg.MatchString("abc"))
+	assert.False(t, rg.MatchString("ab\n"))
+}
+
+func TestInvalidPatterns(t *testing.T) {
+	patterns := []string{
+		`\d`,
+		`a*?`,
+		`a+*`,
+		`(?i)a`,
+		`[a`,
+		`a\`,
+		`\p`,
+	}
+	for _, pattern := range patterns {
+		t.Run(pattern, func(t *testing.T) {
+			_, err := iregexp.Compile(pattern, true)
+			assert.NotNil(t, err)
+		})
+	}
+}
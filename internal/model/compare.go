@@ -0,0 +1,84 @@
+package model
+
+// Equal reports if two values are equal.
+// Arrays and objects are equal if their elements and members are equal.
+//
+// Values with pointer cycles are compared up to the point where the same pair of values
+// is compared again, which is taken to be equal.
+func Equal(v1, v2 any) bool {
+	return equal(v1, v2, 0, nil)
+}
+
+// pair is a pair of values compared by Equal.
+type pair struct {
+	v1, v2 ID
+}
+
+// equal reports if two values nested depth levels deep are equal.
+// seen are the pairs of values being compared nested deeper than CycleDepth.
+func equal(v1, v2 any, depth int, seen map[pair]bool) bool {
+	m1, m2 := Of(v1), Of(v2)
+	if m1.kind != m2.kind {
+		return false
+	}
+	switch m1.kind {
+	case Null:
+		return true
+	case Bool, String:
+		return m1.v == m2.v
+	case Number:
+		c, ok := m1.num.compare(m2.num)
+		return ok && c == 0
+	case Array, Object:
+		if m1.Len() != m2.Len() {
+			return false
+		}
+	default:
+		return false
+	}
+	if depth > CycleDepth {
+		id1, ok1 := Identify(v1)
+		id2, ok2 := Identify(v2)
+		if ok1 && ok2 {
+			p := pair{v1: id1, v2: id2}
+			if seen[p] {
+				return true
+			}
+			if seen == nil {
+				seen = make(map[pair]bool)
+			}
+			seen[p] = true
+			defer delete(seen, p)
+		}
+	}
+	if m1.kind == Array {
+		for i := range m1.Len() {
+			if !equal(m1.Index(i), m2.Index(i), depth+1, seen) {
+				return false
+			}
+		}
+		return true
+	}
+	eq := true
+	m1.Members(func(name string, e1 any) bool {
+		e2, ok := m2.Member(name)
+		eq = ok && equal(e1, e2, depth+1, seen)
+		return eq
+	})
+	return eq
+}
+
+// Less reports if v1 is less than v2, which are both numbers or both strings.
+// Numbers are compared exactly, whether they are integers or floating point numbers.
+func Less(v1, v2 any) bool {
+	m1, m2 := Of(v1), Of(v2)
+	switch {
+	case m1.kind == Number && m2.kind == Number:
+		c, ok := m1.num.compare(m2.num)
+		return ok && c < 0
+	case m1.kind == String && m2.kind == String:
+		return m1.v.(string) < m2.v.(string)
+	default:
+		return false
+	}
+}
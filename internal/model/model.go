@@ -0,0 +1,480 @@
+// Package model reads documents made of arbitrary Go values as JSON values.
+//
+// The values produced by encoding/json when unmarshalling into an any,
+// i.e. float64, string, nil, bool, map[string]any and []any, are read directly,
+// as are Ordered objects.
+// Other Go values are read by reflection, the way encoding/json would marshal them:
+//   - Structs are objects whose members are their exported fields, named and omitted according to
+//     their json tags, with the fields of embedded structs promoted.
+//   - Maps are objects if their keys are strings, integers or implement encoding.TextMarshaler.
+//   - Slices and arrays are arrays, except byte slices, which are base64 encoded strings.
+//   - Pointers and interfaces are the value they refer to, or null if they are nil.
+//   - Values implementing json.Marshaler or encoding.TextMarshaler are the value they marshal to.
+//
+// Integers and json.Number values are read exactly, without rounding them to a float64.
+//
+// Values that encoding/json cannot marshal, such as channels, functions,
+// or values whose MarshalJSON fails, are Invalid.
+//
+// Values implementing Node are read through its methods instead.
+package model
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strconv"
+)
+
+// Kind is the JSON type of a Value.
+type Kind int
+
+const (
+	Invalid Kind = iota
+	Null
+	Bool
+	Number
+	String
+	Array
+	Object
+)
+
+// Node is a value of a document that is read through its methods rather than by its Go type,
+// which lets documents be trees other than the Go values read by reflection,
+// e.g. a custom DOM or the rows of a database query.
+//
+// The methods are called according to the Kind of the Node:
+//   - Len returns the number of elements of an array or members of an object.
+//   - Index returns the element of an array at an index from 0 up to Len.
+//   - Member returns the member of an object by name, or false if it has none by that name.
+//   - Keys returns the names of the members of an object, in the order they are selected in.
+//   - Scalar returns the value of a scalar: nil for null, a bool for a boolean,
+//     any Go number or json.Number for a number, and a string for a string.
+//
+// Elements and members are either Nodes themselves or any other Go value.
+type Node interface {
+	Kind() Kind
+	Len() int
+	Index(i int) any
+	Member(name string) (any, bool)
+	Keys() []string
+	Scalar() any
+}
+
+// MemberVisitor is implemented by Nodes that visit the members of an object in one pass,
+// which Members calls rather than Member for every key, e.g. when looking up a member
+// is not a constant time operation.
+type MemberVisitor interface {
+	// Members calls f with the name and value of every member, in the order of Keys, until f returns false.
+	Members(f func(name string, e any) bool)
+}
+
+// Value is a Go value read as a JSON value.
+//
+// Value is the Node of any Go value, which reads Nodes through their methods,
+// plain values directly and other values by reflection.
+type Value struct {
+	// v is the plain value, i.e. a bool, string, []any, map[string]any or Ordered,
+	// or the original value if it is an array or object read by reflection or as a Node.
+	v any
+	// rv is the reflected array or object, if v is read by reflection.
+	rv reflect.Value
+	// node is the array or object, if v is a Node.
+	node Node
+	// num is the value of a number.
+	num  number
+	kind Kind
+}
+
+// Of reads v as a JSON value.
+func Of(v any) Value {
+	switch x := v.(type) {
+	case nil:
+		return Value{kind: Null}
+	case bool:
+		return Value{v: v, kind: Bool}
+	case float64:
+		return Value{num: number{f: x}, kind: Number}
+	case int:
+		return Value{num: number{form: formInt, i: int64(x)}, kind: Number}
+	case int64:
+		return Value{num: number{form: formInt, i: x}, kind: Number}
+	case uint64:
+		return Value{num: number{form: formUint, u: x}, kind: Number}
+	case json.Number:
+		n, ok := parseNumber(x)
+		if !ok {
+			return Value{}
+		}
+		return Value{num: n, kind: Number}
+	case string:
+		return Value{v: v, kind: String}
+	case []any:
+		return Value{v: v, kind: Array}
+	case map[string]any:
+		return Value{v: v, kind: Object}
+	case Ordered:
+		return Value{v: v, kind: Object}
+	case Value:
+		return x
+	case Node:
+		return ofNode(x)
+	}
+	return reflected(v, reflect.ValueOf(v))
+}
+
+// ofNode reads the Node, n.
+func ofNode(n Node) Value {
+	if rv := reflect.ValueOf(n); rv.Kind() == reflect.Pointer && rv.IsNil() {
+		return Value{kind: Null}
+	}
+	switch k := n.Kind(); k {
+	case Array, Object:
+		return Value{v: n, node: n, kind: k}
+	case Null, Bool, Number, String:
+		if s := Of(n.Scalar()); s.kind == k {
+			return s
+		}
+	}
+	return Value{}
+}
+
+var (
+	numberType        = reflect.TypeFor[json.Number]()
+	marshalerType     = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// reflected reads the reflected value, rv, of v.
+func reflected(v any, rv reflect.Value) Value {
+	for {
+		if !rv.IsValid() {
+			return Value{kind: Null}
+		}
+		if (rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface) && rv.IsNil() {
+			return Value{kind: Null}
+		}
+		if rv.Type() == numberType {
+			return Of(rv.Interface())
+		}
+		if rv.Type().Implements(marshalerType) {
+			return marshalJSON(rv.Interface().(json.Marshaler))
+		}
+		if rv.Type().Implements(textMarshalerType) {
+			text, err := rv.Interface().(encoding.TextMarshaler).MarshalText()
+			if err != nil {
+				return Value{}
+			}
+			return Value{v: string(text), kind: String}
+		}
+		switch rv.Kind() {
+		case reflect.Pointer, reflect.Interface:
+			rv = rv.Elem()
+		case reflect.Bool:
+			return Value{v: rv.Bool(), kind: Bool}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return Value{num: number{form: formInt, i: rv.Int()}, kind: Number}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return Value{num: number{form: formUint, u: rv.Uint()}, kind: Number}
+		case reflect.Float32:
+			return Value{num: float32Number(rv.Float()), kind: Number}
+		case reflect.Float64:
+			return Value{num: number{f: rv.Float()}, kind: Number}
+		case reflect.String:
+			return Value{v: rv.String(), kind: String}
+		case reflect.Slice:
+			if rv.IsNil() {
+				return Value{kind: Null}
+			}
+			if rv.Type().Elem().Kind() == reflect.Uint8 && !implementsMarshaler(rv.Type().Elem()) {
+				return Value{v: base64.StdEncoding.EncodeToString(rv.Bytes()), kind: String}
+			}
+			return Value{v: v, rv: rv, kind: Array}
+		case reflect.Array:
+			return Value{v: v, rv: rv, kind: Array}
+		case reflect.Map:
+			if rv.IsNil() {
+				return Value{kind: Null}
+			}
+			if !validKey(rv.Type().Key()) {
+				return Value{}
+			}
+			return Value{v: v, rv: rv, kind: Object}
+		case reflect.Struct:
+			return Value{v: v, rv: rv, kind: Object}
+		default:
+			return Value{}
+		}
+	}
+}
+
+// marshalJSON reads the value that m marshals to.
+// Numbers are decoded as json.Number, so that they are read exactly.
+func marshalJSON(m json.Marshaler) Value {
+	b, err := m.MarshalJSON()
+	if err != nil {
+		return Value{}
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return Value{}
+	}
+	return Of(v)
+}
+
+// implementsMarshaler reports if values of type t, or pointers to them, marshal themselves.
+func implementsMarshaler(t reflect.Type) bool {
+	p := reflect.PointerTo(t)
+	return t.Implements(marshalerType) || t.Implements(textMarshalerType) ||
+		p.Implements(marshalerType) || p.Implements(textMarshalerType)
+}
+
+// validKey reports if maps with keys of type t are objects.
+func validKey(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return t.Implements(textMarshalerType)
+}
+
+// Kind returns the JSON type of the value.
+func (v Value) Kind() Kind {
+	return v.kind
+}
+
+// Scalar returns the value of a null, boolean or string as nil, bool or string,
+// and the value of a number as an int64 or uint64 if it is an integer read exactly, or a float64.
+// The value of an array or object is the Go value it was read from.
+func (v Value) Scalar() any {
+	if v.kind == Number {
+		return v.num.value()
+	}
+	return v.v
+}
+
+// Len returns the number of elements of an array or members of an object, or 0 for other values.
+func (v Value) Len() int {
+	switch x := v.v.(type) {
+	case []any:
+		return len(x)
+	case map[string]any:
+		return len(x)
+	case Ordered:
+		return len(x)
+	}
+	switch {
+	case v.node != nil:
+		return v.node.Len()
+	case v.kind == Array, v.kind == Object && v.rv.Kind() == reflect.Map:
+		return v.rv.Len()
+	case v.kind == Object:
+		n := 0
+		v.Members(func(string, any) bool {
+			n++
+			return true
+		})
+		return n
+	default:
+		return 0
+	}
+}
+
+// Index returns the element at index, i, of an array, which must be in range.
+func (v Value) Index(i int) any {
+	if x, ok := v.v.([]any); ok {
+		return x[i]
+	}
+	switch {
+	case v.kind != Array:
+		return nil
+	case v.node != nil:
+		return v.node.Index(i)
+	default:
+		return child(v.rv.Index(i))
+	}
+}
+
+// Member returns the member, name, of an object.
+func (v Value) Member(name string) (any, bool) {
+	if x, ok := v.v.(map[string]any); ok {
+		e, ok := x[name]
+		return e, ok
+	}
+	if x, ok := v.v.(Ordered); ok {
+		return x.member(name)
+	}
+	if v.kind != Object {
+		return nil, false
+	}
+	if v.node != nil {
+		return v.node.Member(name)
+	}
+	if v.rv.Kind() == reflect.Struct {
+		s := structOf(v.rv.Type())
+		i, ok := s.names[name]
+		if !ok {
+			return nil, false
+		}
+		fv, ok := s.fields[i].value(v.rv)
+		if !ok {
+			return nil, false
+		}
+		return child(fv), true
+	}
+	if v.rv.Type().Key().Kind() == reflect.String {
+		e := v.rv.MapIndex(reflect.ValueOf(name).Convert(v.rv.Type().Key()))
+		if !e.IsValid() {
+			return nil, false
+		}
+		return e.Interface(), true
+	}
+	var found any
+	ok := false
+	v.Members(func(k string, e any) bool {
+		if k == name {
+			found, ok = e, true
+		}
+		return !ok
+	})
+	return found, ok
+}
+
+// Members calls f with the name and value of every member of an object, until f returns false.
+// Members of maps are in the iteration order of the map, and members of other objects in their order.
+func (v Value) Members(f func(name string, e any) bool) {
+	if x, ok := v.v.(map[string]any); ok {
+		for k, e := range x {
+			if !f(k, e) {
+				return
+			}
+		}
+		return
+	}
+	if x, ok := v.v.(Ordered); ok {
+		for _, m := range x {
+			if !f(m.Name, m.Value) {
+				return
+			}
+		}
+		return
+	}
+	if v.kind != Object {
+		return
+	}
+	if mv, ok := v.node.(MemberVisitor); ok {
+		mv.Members(f)
+		return
+	}
+	if v.node != nil {
+		for _, k := range v.node.Keys() {
+			e, ok := v.node.Member(k)
+			if ok && !f(k, e) {
+				return
+			}
+		}
+		return
+	}
+	if v.rv.Kind() == reflect.Struct {
+		for _, fd := range structOf(v.rv.Type()).fields {
+			fv, ok := fd.value(v.rv)
+			if ok && !f(fd.name, child(fv)) {
+				return
+			}
+		}
+		return
+	}
+	it := v.rv.MapRange()
+	for it.Next() {
+		k, ok := keyName(it.Key())
+		if ok && !f(k, it.Value().Interface()) {
+			return
+		}
+	}
+}
+
+// Keys returns the names of the members of an object.
+func (v Value) Keys() []string {
+	if v.node != nil && v.kind == Object {
+		return v.node.Keys()
+	}
+	keys := make([]string, 0, v.Len())
+	v.Members(func(name string, _ any) bool {
+		keys = append(keys, name)
+		return true
+	})
+	return keys
+}
+
+// child returns the Go value of an element or field.
+// Addressable values whose pointer marshals itself are returned as the pointer,
+// as encoding/json would marshal them.
+func child(rv reflect.Value) any {
+	if rv.CanAddr() && rv.Kind() != reflect.Pointer && rv.Kind() != reflect.Interface {
+		p := reflect.PointerTo(rv.Type())
+		if !rv.Type().Implements(marshalerType) && p.Implements(marshalerType) ||
+			!rv.Type().Implements(textMarshalerType) && p.Implements(textMarshalerType) {
+			return rv.Addr().Interface()
+		}
+	}
+	return rv.Interface()
+}
+
+// keyName returns the member name of a map key.
+func keyName(k reflect.Value) (string, bool) {
+	if k.Kind() == reflect.String {
+		return k.String(), true
+	}
+	if m, ok := k.Interface().(encoding.TextMarshaler); ok {
+		if k.Kind() == reflect.Pointer && k.IsNil() {
+			return "", true
+		}
+		text, err := m.MarshalText()
+		return string(text), err == nil
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), true
+	default:
+		return "", false
+	}
+}
+
+// CycleDepth is the nesting below which values are checked for cycles,
+// which only documents with pointer cycles or uncommonly deep nesting reach.
+const CycleDepth = 1000
+
+// ID identifies the array or object a pointer, map or slice refers to.
+type ID struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+// Identify returns the ID of v, if v is a pointer, map or slice.
+// Values with the same ID are the same array or object, which is how cycles are detected.
+func Identify(v any) (ID, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Map:
+		if rv.IsNil() {
+			return ID{}, false
+		}
+		return ID{ptr: rv.Pointer(), typ: rv.Type()}, true
+	case reflect.Slice:
+		if rv.IsNil() {
+			return ID{}, false
+		}
+		return ID{ptr: rv.Pointer(), typ: rv.Type(), len: rv.Len()}, true
+	default:
+		return ID{}, false
+	}
+}
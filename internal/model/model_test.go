@@ -0,0 +1,243 @@
+package model_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/marcfyk/go-jsonpath/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+type Inner struct {
+	Z       int `json:"z"`
+	Shadow  int
+	Both    int `json:"both"`
+	private int
+}
+
+type Other struct {
+	Both int `json:"both"`
+}
+
+type Outer struct {
+	A       string `json:"a"`
+	B       int    `json:"b,omitempty"`
+	C       *Outer `json:"c,omitempty"`
+	Skipped string `json:"-"`
+	Dash    string `json:"-,"`
+	Plain   bool
+	Tags    map[string]string `json:"tags"`
+	Bytes   []byte            `json:"bytes"`
+	Array   [2]int            `json:"array"`
+	Nil     []int             `json:"nil"`
+	Shadow  string
+	private string
+	Inner
+	*Other
+}
+
+// toJSON converts v to the plain value encoding/json unmarshals its JSON into.
+func toJSON(t *testing.T, v any) any {
+	b, err := json.Marshal(v)
+	assert.Nil(t, err)
+	var plain any
+	assert.Nil(t, json.Unmarshal(b, &plain))
+	return plain
+}
+
+func TestStructs(t *testing.T) {
+	values := []any{
+		Outer{A: "x", Tags: map[string]string{"k": "v"}, Bytes: []byte("hi"), Array: [2]int{1, 2}},
+		&Outer{A: "y", B: 2, C: &Outer{A: "z"}, Skipped: "s", Dash: "d", Plain: true, Shadow: "outer",
+			Inner: Inner{Z: 3, Shadow: 4, Both: 5}, Other: &Other{Both: 6}},
+	}
+	for _, v := range values {
+		// Go values are read as encoding/json would marshal them.
+		assert.True(t, model.Equal(toJSON(t, v), v))
+		assert.True(t, model.Equal(v, toJSON(t, v)))
+	}
+
+	m := model.Of(values[1])
+	assert.Equal(t, model.Object, m.Kind())
+	assert.ElementsMatch(t, []string{
+		"a", "b", "c", "-", "Plain", "tags", "bytes", "array", "nil", "Shadow", "z",
+	}, m.Keys())
+	assert.Equal(t, 11, m.Len())
+	b, ok := m.Member("b")
+	assert.True(t, ok)
+	assert.Equal(t, 2, b)
+	_, ok = m.Member("Skipped")
+	assert.False(t, ok)
+	_, ok = m.Member("both")
+	assert.False(t, ok)
+	shadow, _ := m.Member("Shadow")
+	assert.Equal(t, "outer", shadow)
+
+	m = model.Of(values[0])
+	_, ok = m.Member("b")
+	assert.False(t, ok)
+	bytes, _ := m.Member("bytes")
+	assert.Equal(t, "aGk=", model.Of(bytes).Scalar())
+	null, _ := m.Member("nil")
+	assert.Equal(t, model.Null, model.Of(null).Kind())
+	_, ok = m.Member("z")
+	assert.True(t, ok)
+}
+
+type Key struct {
+	A, B string
+}
+
+func (k Key) MarshalText() ([]byte, error) {
+	return []byte(k.A + "-" + k.B), nil
+}
+
+type Name string
+
+func TestMaps(t *testing.T) {
+	cases := []struct {
+		name string
+		v    any
+	}{
+		{"string keys", map[Name]int{"a": 1}},
+		{"integer keys", map[int]string{1: "a", -2: "b"}},
+		{"unsigned keys", map[uint8]bool{7: true}},
+		{"text keys", map[Key]float32{{"a", "b"}: 1.5}},
+		{"interface values", map[string]any{"a": []int{1}, "b": map[string]any{}}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.True(t, model.Equal(toJSON(t, c.v), c.v))
+			m := model.Of(c.v)
+			for _, k := range m.Keys() {
+				_, ok := m.Member(k)
+				assert.True(t, ok)
+			}
+		})
+	}
+	assert.Equal(t, model.Invalid, model.Of(map[[2]int]int{}).Kind())
+	assert.Equal(t, model.Invalid, model.Of(make(chan int)).Kind())
+}
+
+type Celsius float64
+
+func (c Celsius) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]any{"celsius": float64(c)})
+}
+
+type Counter struct {
+	n int
+}
+
+func (c *Counter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.n)
+}
+
+type Broken struct{}
+
+func (Broken) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("broken")
+}
+
+func TestMarshalers(t *testing.T) {
+	when := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.Equal(t, "2024-01-02T03:04:05Z", model.Of(when).Scalar())
+	assert.Equal(t, "a-b", model.Of(Key{"a", "b"}).Scalar())
+
+	temperature := model.Of(Celsius(21.5))
+	assert.Equal(t, model.Object, temperature.Kind())
+	// Numbers that values marshal to are read exactly.
+	c, _ := temperature.Member("celsius")
+	assert.Equal(t, json.Number("21.5"), c)
+
+	// Addressable values marshal themselves with their pointer methods.
+	counters := []Counter{{n: 1}, {n: 2}}
+	assert.Equal(t, int64(2), model.Of(model.Of(counters).Index(1)).Scalar())
+	assert.True(t, model.Equal(toJSON(t, counters), counters))
+	assert.Equal(t, model.Null, model.Of((*Counter)(nil)).Kind())
+
+	assert.Equal(t, model.Invalid, model.Of(Broken{}).Kind())
+	assert.False(t, model.Equal(Broken{}, Broken{}))
+}
+
+func TestCompare(t *testing.T) {
+	assert.True(t, model.Equal(3, 3.0))
+	assert.True(t, model.Equal(uint8(3), int64(3)))
+	assert.True(t, model.Equal(Name("a"), "a"))
+	assert.False(t, model.Equal(1, "1"))
+	assert.True(t, model.Equal((*int)(nil), nil))
+	assert.True(t, model.Equal([]int{1, 2}, [2]float32{1, 2}))
+	assert.False(t, model.Equal([]int{1, 2}, []int{2, 1}))
+	assert.True(t, model.Less(1, 1.5))
+	assert.True(t, model.Less(Name("a"), "b"))
+	assert.False(t, model.Less(1, "2"))
+	assert.False(t, model.Less([]int{1}, []int{2}))
+}
+
+func TestNumbers(t *testing.T) {
+	const snowflake = 1234567890123456789
+	cases := []struct {
+		v1, v2 any
+		// expected is -1, 0 or 1 if v1 is less than, equal to or greater than v2.
+		expected int
+	}{
+		{int64(snowflake), int64(snowflake - 1), 1},
+		{int64(snowflake), json.Number("1234567890123456789"), 0},
+		{json.Number("1234567890123456788"), uint64(snowflake), -1},
+		{int64(snowflake), float64(snowflake), 1},
+		{uint64(1 << 63), int64(-1 << 63), 1},
+		{uint64(1<<64 - 1), float64(1 << 64), -1},
+		{int64(-1 << 63), float64(-1 << 63), 0},
+		{int64(3), 3.5, -1},
+		{int64(-3), -3.5, 1},
+		{uint8(3), json.Number("3.0"), 0},
+		{float32(0.1), 0.1, 0},
+		{json.Number("1e2"), 100, 0},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%T(%v) %T(%v)", c.v1, c.v1, c.v2, c.v2), func(t *testing.T) {
+			assert.Equal(t, c.expected == 0, model.Equal(c.v1, c.v2))
+			assert.Equal(t, c.expected < 0, model.Less(c.v1, c.v2))
+			assert.Equal(t, c.expected > 0, model.Less(c.v2, c.v1))
+		})
+	}
+	assert.Equal(t, model.Invalid, model.Of(json.Number("x")).Kind())
+	assert.False(t, model.Equal(math.NaN(), math.NaN()))
+	assert.Equal(t, uint64(1<<64-1), model.Of(json.Number("18446744073709551615")).Scalar())
+}
+
+type Link struct {
+	Next *Link `json:"next"`
+}
+
+func TestEqualCycles(t *testing.T) {
+	a, b := &Link{}, &Link{}
+	a.Next, b.Next = a, &Link{Next: b}
+	assert.True(t, model.Equal(a, b))
+	assert.False(t, model.Equal(a, &Link{Next: &Link{}}))
+}
+
+func TestOrdered(t *testing.T) {
+	o := model.Ordered{{Name: "b", Value: 1}, {Name: "a", Value: []any{2}}, {Name: "b", Value: 3}}
+	m := model.Of(o)
+	assert.Equal(t, model.Object, m.Kind())
+	assert.Equal(t, []string{"b", "a", "b"}, m.Keys())
+	// The first member with a name is the member of that name.
+	b, ok := m.Member("b")
+	assert.True(t, ok)
+	assert.Equal(t, 1, b)
+	assert.False(t, m.Unordered())
+	assert.True(t, model.Equal(model.Ordered{{Name: "a", Value: 1}, {Name: "b", Value: 2}}, map[string]any{"b": 2, "a": 1}))
+	assert.Equal(t, model.Object, model.Of(model.Ordered(nil)).Kind())
+
+	var names []string
+	model.Of(map[int]bool{10: true, 9: true, 1: true}).SortedMembers(func(name string, _ any) bool {
+		names = append(names, name)
+		return true
+	})
+	assert.Equal(t, []string{"1", "10", "9"}, names)
+}
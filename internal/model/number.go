@@ -0,0 +1,120 @@
+package model
+
+import (
+	"cmp"
+	"encoding/json"
+	"math"
+	"strconv"
+)
+
+// form is how a number is held.
+type form int
+
+const (
+	formFloat form = iota
+	formInt
+	formUint
+)
+
+// number is a JSON number, held exactly.
+// Integers read from Go integers, or from json.Number values that fit in 64 bits,
+// are held as an int64 or uint64, and other numbers as a float64.
+type number struct {
+	form form
+	f    float64
+	i    int64
+	u    uint64
+}
+
+// value returns the number as a float64, int64 or uint64.
+func (n number) value() any {
+	switch n.form {
+	case formInt:
+		return n.i
+	case formUint:
+		return n.u
+	default:
+		return n.f
+	}
+}
+
+// parseNumber parses the text of a json.Number.
+func parseNumber(s json.Number) (number, bool) {
+	if i, err := strconv.ParseInt(string(s), 10, 64); err == nil {
+		return number{form: formInt, i: i}, true
+	}
+	if u, err := strconv.ParseUint(string(s), 10, 64); err == nil {
+		return number{form: formUint, u: u}, true
+	}
+	f, err := strconv.ParseFloat(string(s), 64)
+	return number{f: f}, err == nil
+}
+
+// float32Number returns the number encoding/json marshals a float32 to,
+// which is its shortest decimal representation rather than its exact value.
+func float32Number(f float64) number {
+	f, _ = strconv.ParseFloat(strconv.FormatFloat(f, 'g', -1, 32), 64)
+	return number{f: f}
+}
+
+// compare compares two numbers exactly, returning false if either is NaN.
+func (n number) compare(m number) (int, bool) {
+	switch {
+	case n.form == formFloat && m.form == formFloat:
+		if math.IsNaN(n.f) || math.IsNaN(m.f) {
+			return 0, false
+		}
+		return cmp.Compare(n.f, m.f), true
+	case n.form == formFloat:
+		c, ok := m.compareFloat(n.f)
+		return -c, ok
+	case m.form == formFloat:
+		return n.compareFloat(m.f)
+	case n.form == formInt && m.form == formInt:
+		return cmp.Compare(n.i, m.i), true
+	case n.form == formUint && m.form == formUint:
+		return cmp.Compare(n.u, m.u), true
+	case n.form == formInt:
+		if n.i < 0 {
+			return -1, true
+		}
+		return cmp.Compare(uint64(n.i), m.u), true
+	default:
+		if m.i < 0 {
+			return 1, true
+		}
+		return cmp.Compare(n.u, uint64(m.i)), true
+	}
+}
+
+// compareFloat compares the integer, n, with f exactly,
+// rather than after rounding n to a float64.
+func (n number) compareFloat(f float64) (int, bool) {
+	if math.IsNaN(f) {
+		return 0, false
+	}
+	t := math.Trunc(f)
+	if n.form == formInt {
+		switch {
+		case f < -0x1p63:
+			return 1, true
+		case f >= 0x1p63:
+			return -1, true
+		}
+		if c := cmp.Compare(n.i, int64(t)); c != 0 {
+			return c, true
+		}
+	} else {
+		switch {
+		case f < 0:
+			return 1, true
+		case f >= 0x1p64:
+			return -1, true
+		}
+		if c := cmp.Compare(n.u, uint64(t)); c != 0 {
+			return c, true
+		}
+	}
+	// n is the integer part of f, so the fraction of f decides.
+	return cmp.Compare(t, f), true
+}
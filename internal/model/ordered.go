@@ -0,0 +1,85 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Member is a member of an Ordered object.
+type Member struct {
+	Name  string
+	Value any
+}
+
+// Ordered is an object that keeps the order of its members, which are selected in that order.
+//
+// Members are looked up by name by scanning them, and the first member with a name
+// is the member of that name. An Ordered with no members, including a nil Ordered, is an empty object.
+type Ordered []Member
+
+// MarshalJSON marshals the object with its members in order.
+func (o Ordered) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, err := json.Marshal(m.Name)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(name)
+		b.WriteByte(':')
+		value, err := json.Marshal(m.Value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// member returns the value of the first member, name.
+func (o Ordered) member(name string) (any, bool) {
+	for _, m := range o {
+		if m.Name == name {
+			return m.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Unordered reports if the value is an object whose members have no order, i.e. a map.
+func (v Value) Unordered() bool {
+	if _, ok := v.v.(map[string]any); ok {
+		return true
+	}
+	return v.kind == Object && v.node == nil && v.rv.Kind() == reflect.Map
+}
+
+// SortedMembers calls f with the name and value of every member of an object, until f returns false,
+// with the members of maps in the order of their names. Other objects keep their own order.
+func (v Value) SortedMembers(f func(name string, e any) bool) {
+	if !v.Unordered() {
+		v.Members(f)
+		return
+	}
+	members := make([]Member, 0, v.Len())
+	v.Members(func(name string, e any) bool {
+		members = append(members, Member{Name: name, Value: e})
+		return true
+	})
+	slices.SortFunc(members, func(a, b Member) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	for _, m := range members {
+		if !f(m.Name, m.Value) {
+			return
+		}
+	}
+}
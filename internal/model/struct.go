@@ -0,0 +1,182 @@
+package model
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// structType is the members of a struct type.
+type structType struct {
+	// fields are the fields that are members, in the order of their declaration.
+	fields []field
+	// names are the positions of the fields, by member name.
+	names map[string]int
+}
+
+// field is a struct field that is a member.
+type field struct {
+	name string
+	// index is the index sequence of the field, through the embedded structs it is promoted from.
+	index []int
+	// omitEmpty reports if the field is omitted when it is empty.
+	omitEmpty bool
+	// tagged reports if the name of the field is set by its json tag.
+	tagged bool
+}
+
+// value returns the value of the field of the struct, rv.
+// It returns false if the field is omitted, or is promoted through a nil pointer.
+func (f field) value(rv reflect.Value) (reflect.Value, bool) {
+	for i, x := range f.index {
+		if i > 0 && rv.Kind() == reflect.Pointer {
+			if rv.IsNil() {
+				return reflect.Value{}, false
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(x)
+	}
+	if f.omitEmpty && isEmpty(rv) {
+		return reflect.Value{}, false
+	}
+	return rv, true
+}
+
+// isEmpty reports if a value is empty, as defined by the omitempty option of encoding/json.
+func isEmpty(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.Interface, reflect.Pointer:
+		return rv.IsZero()
+	default:
+		return false
+	}
+}
+
+// structTypes are the struct types read so far.
+var structTypes sync.Map
+
+// structOf returns the members of the struct type, t.
+func structOf(t reflect.Type) *structType {
+	if s, ok := structTypes.Load(t); ok {
+		return s.(*structType)
+	}
+	fields := typeFields(t)
+	s := &structType{fields: fields, names: make(map[string]int, len(fields))}
+	for i, f := range fields {
+		s.names[f.name] = i
+	}
+	actual, _ := structTypes.LoadOrStore(t, s)
+	return actual.(*structType)
+}
+
+// typeFields returns the fields of the struct type, t, that are members,
+// following the rules of encoding/json:
+//   - Unexported fields and fields tagged "-" are not members.
+//   - Fields of embedded structs without a name in their tag are promoted.
+//   - Of the fields with the same name, the least nested one is the member,
+//     or the only tagged one of the least nested fields. Otherwise, none is.
+func typeFields(t reflect.Type) []field {
+	type embedded struct {
+		t     reflect.Type
+		index []int
+	}
+	var all []field
+	// depths are the nesting of the fields in all.
+	var depths []int
+	visited := make(map[reflect.Type]bool)
+	current := []embedded{{t: t}}
+	for depth := 0; len(current) > 0; depth++ {
+		var next []embedded
+		for _, e := range current {
+			if visited[e.t] {
+				continue
+			}
+			visited[e.t] = true
+			for i := range e.t.NumField() {
+				sf := e.t.Field(i)
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				if sf.Anonymous {
+					if !sf.IsExported() && ft.Kind() != reflect.Struct {
+						continue
+					}
+				} else if !sf.IsExported() {
+					continue
+				}
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, options, _ := strings.Cut(tag, ",")
+				index := append(slices.Clone(e.index), i)
+				if name == "" && sf.Anonymous && ft.Kind() == reflect.Struct {
+					next = append(next, embedded{t: ft, index: index})
+					continue
+				}
+				f := field{name: name, index: index, tagged: name != ""}
+				if name == "" {
+					f.name = sf.Name
+				}
+				for options != "" {
+					var option string
+					option, options, _ = strings.Cut(options, ",")
+					f.omitEmpty = f.omitEmpty || option == "omitempty"
+				}
+				all = append(all, f)
+				depths = append(depths, depth)
+			}
+		}
+		current = next
+	}
+
+	byName := make(map[string][]int)
+	for i, f := range all {
+		byName[f.name] = append(byName[f.name], i)
+	}
+	var fields []field
+	for i, f := range all {
+		dominant, ok := dominantField(byName[f.name], all, depths)
+		if ok && dominant == i {
+			fields = append(fields, f)
+		}
+	}
+	slices.SortFunc(fields, func(a, b field) int {
+		return slices.Compare(a.index, b.index)
+	})
+	return fields
+}
+
+// dominantField returns the position in all of the member among the fields with the same name, candidates.
+func dominantField(candidates []int, all []field, depths []int) (int, bool) {
+	least := depths[candidates[0]]
+	for _, c := range candidates {
+		least = min(least, depths[c])
+	}
+	var nested, tagged []int
+	for _, c := range candidates {
+		if depths[c] == least {
+			nested = append(nested, c)
+			if all[c].tagged {
+				tagged = append(tagged, c)
+			}
+		}
+	}
+	switch {
+	case len(nested) == 1:
+		return nested[0], true
+	case len(tagged) == 1:
+		return tagged[0], true
+	default:
+		return 0, false
+	}
+}
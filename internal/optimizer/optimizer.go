@@ -0,0 +1,359 @@
+// Package optimizer rewrites the abstract syntax tree of a jsonpath query
+// into an equivalent tree that is cheaper to evaluate.
+//
+// Every rewrite keeps the nodes selected by the query, and their order, unchanged:
+//   - comparisons of two literals are folded into constants, which propagate through ! && and ||.
+//   - the single operand wrappers and parentheses the parser emits are flattened.
+//   - duplicate selectors are removed from queries whose nodes are only tested for existence,
+//     and duplicate operands are removed from && and ||.
+//     Duplicate selectors elsewhere select duplicate nodes, so they are kept.
+//   - absolute singular queries inside filters are hoisted, see ast.QueryHoisted.
+//   - operands of && and || are reordered so that cheaper tests run first.
+package optimizer
+
+import (
+	"slices"
+	"unicode/utf8"
+
+	"github.com/marcfyk/go-jsonpath/internal/ast"
+)
+
+// Optimize returns the optimized tree of the query, q.
+func Optimize(q ast.QueryJSONPath) ast.QueryJSONPath {
+	o := optimizer{slots: make(map[string]int)}
+	return ast.QueryJSONPath{Segments: o.segments(q.Segments)}
+}
+
+type optimizer struct {
+	// slots are the slots of the hoisted queries, by their canonical string.
+	slots map[string]int
+}
+
+func (o *optimizer) segments(segments []ast.Expr) []ast.Expr {
+	out := make([]ast.Expr, len(segments))
+	for i, s := range segments {
+		switch s := s.(type) {
+		case ast.SegmentChild:
+			out[i] = ast.SegmentChild{Selectors: o.selectors(s.Selectors)}
+		case ast.SegmentDescendant:
+			out[i] = ast.SegmentDescendant{Selectors: o.selectors(s.Selectors)}
+		default:
+			out[i] = s
+		}
+	}
+	return out
+}
+
+func (o *optimizer) selectors(selectors []ast.Expr) []ast.Expr {
+	out := make([]ast.Expr, len(selectors))
+	for i, s := range selectors {
+		f, ok := s.(ast.SelectorFilter)
+		if !ok {
+			out[i] = s
+			continue
+		}
+		e := o.logical(f.Expr)
+		if c, ok := e.(ast.ExprLogicalConstant); ok && c.Value {
+			// A filter that holds for every child selects the same nodes as a wildcard.
+			out[i] = ast.SelectorWildcard{}
+			continue
+		}
+		out[i] = ast.SelectorFilter{Expr: e}
+	}
+	return out
+}
+
+// exists optimizes the segments of a query whose nodes are only tested for existence.
+func (o *optimizer) exists(segments []ast.Expr) []ast.Expr {
+	segments = o.segments(segments)
+	for i, s := range segments {
+		switch s := s.(type) {
+		case ast.SegmentChild:
+			segments[i] = ast.SegmentChild{Selectors: unique(s.Selectors)}
+		case ast.SegmentDescendant:
+			segments[i] = ast.SegmentDescendant{Selectors: unique(s.Selectors)}
+		}
+	}
+	return segments
+}
+
+// unique returns exprs without the expressions that have the same canonical string as an earlier one.
+func unique(exprs []ast.Expr) []ast.Expr {
+	seen := make(map[string]bool, len(exprs))
+	out := exprs[:0:0]
+	for _, e := range exprs {
+		k := ast.Format(e)
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		out = append(out, e)
+	}
+	return out
+}
+
+func (o *optimizer) logical(e ast.Expr) ast.Expr {
+	switch e := e.(type) {
+	case ast.ExprLogicalOr:
+		return o.operands(e.Exprs, true)
+	case ast.ExprLogicalAnd:
+		return o.operands(e.Exprs, false)
+	case ast.ExprParen:
+		// The tree already groups the operands, so parentheses are redundant.
+		return o.logical(e.Expr)
+	case ast.ExprLogicalNot:
+		switch inner := o.logical(e.Expr).(type) {
+		case ast.ExprLogicalConstant:
+			return ast.ExprLogicalConstant{Value: !inner.Value}
+		case ast.ExprLogicalNot:
+			return inner.Expr
+		default:
+			return ast.ExprLogicalNot{Expr: inner}
+		}
+	case ast.ExprComparison:
+		left, right := o.value(e.Left), o.value(e.Right)
+		l, lok := left.(ast.Literal)
+		r, rok := right.(ast.Literal)
+		if lok && rok {
+			return ast.ExprLogicalConstant{Value: e.Op.Func()(l.Value, r.Value)}
+		}
+		return ast.ExprComparison{Left: left, Right: right, Op: e.Op}
+	case ast.QueryRel:
+		return ast.QueryRel{Segments: o.exists(e.Segments)}
+	case ast.QueryJSONPath:
+		segments := o.exists(e.Segments)
+		if q, ok := singular(segments); ok {
+			return o.hoist(q)
+		}
+		return ast.QueryJSONPath{Segments: segments}
+	case ast.FuncMatch:
+		return ast.FuncMatch{Expr: o.arg(e.Expr), Pattern: o.arg(e.Pattern), Regex: e.Regex}
+	case ast.FuncSearch:
+		return ast.FuncSearch{Expr: o.arg(e.Expr), Pattern: o.arg(e.Pattern), Regex: e.Regex}
+	default:
+		return e
+	}
+}
+
+// operands optimizes the operands of a logical or, if isOr is true, or a logical and.
+func (o *optimizer) operands(exprs []ast.Expr, isOr bool) ast.Expr {
+	var operands []ast.Expr
+	var flatten func(e ast.Expr)
+	flatten = func(e ast.Expr) {
+		switch e := e.(type) {
+		case ast.ExprLogicalOr:
+			if isOr || len(e.Exprs) == 1 {
+				for _, e := range e.Exprs {
+					flatten(e)
+				}
+				return
+			}
+		case ast.ExprLogicalAnd:
+			if !isOr || len(e.Exprs) == 1 {
+				for _, e := range e.Exprs {
+					flatten(e)
+				}
+				return
+			}
+		}
+		operands = append(operands, e)
+	}
+	for _, e := range exprs {
+		flatten(o.logical(e))
+	}
+	kept := operands[:0]
+	for _, e := range operands {
+		c, ok := e.(ast.ExprLogicalConstant)
+		if !ok {
+			kept = append(kept, e)
+			continue
+		}
+		if c.Value == isOr {
+			// The operand decides the result regardless of the others.
+			return c
+		}
+	}
+	operands = unique(kept)
+	switch len(operands) {
+	case 0:
+		// Every operand was the identity of the operator.
+		return ast.ExprLogicalConstant{Value: !isOr}
+	case 1:
+		return operands[0]
+	}
+	// Operands are evaluated until one decides the result,
+	// so cheaper operands are moved first. The sort is stable to keep the written order otherwise.
+	slices.SortStableFunc(operands, func(a, b ast.Expr) int {
+		return cost(a) - cost(b)
+	})
+	if isOr {
+		return ast.ExprLogicalOr{Exprs: operands}
+	}
+	return ast.ExprLogicalAnd{Exprs: operands}
+}
+
+// value optimizes an expression of ValueType.
+func (o *optimizer) value(e ast.ExprSingle) ast.ExprSingle {
+	switch e := e.(type) {
+	case ast.QuerySingularAbs:
+		return o.hoist(e)
+	case ast.FuncLength:
+		arg := o.arg(e.Expr)
+		if l, ok := arg.(ast.Literal); ok {
+			if s, ok := l.Value.(string); ok {
+				return ast.Literal{Value: float64(utf8.RuneCountInString(s))}
+			}
+		}
+		return ast.FuncLength{Expr: arg}
+	case ast.FuncCount:
+		return ast.FuncCount{Expr: o.nodes(e.Expr)}
+	case ast.FuncValue:
+		return ast.FuncValue{Expr: o.nodes(e.Expr)}
+	default:
+		return e
+	}
+}
+
+// arg optimizes an argument of ValueType.
+func (o *optimizer) arg(e ast.Expr) ast.Expr {
+	if s, ok := e.(ast.ExprSingle); ok {
+		return o.value(s)
+	}
+	return e
+}
+
+// nodes optimizes an argument of NodesType, whose nodes are counted or taken,
+// so its duplicate selectors are kept.
+func (o *optimizer) nodes(e ast.Expr) ast.Expr {
+	switch e := e.(type) {
+	case ast.QueryRel:
+		return ast.QueryRel{Segments: o.segments(e.Segments)}
+	case ast.QueryJSONPath:
+		return ast.QueryJSONPath{Segments: o.segments(e.Segments)}
+	default:
+		return o.arg(e)
+	}
+}
+
+// hoist returns the hoisted query of q, sharing the slot of any equal query.
+func (o *optimizer) hoist(q ast.QuerySingularAbs) ast.QueryHoisted {
+	k := ast.Format(q)
+	slot, ok := o.slots[k]
+	if !ok {
+		slot = len(o.slots)
+		o.slots[k] = slot
+	}
+	return ast.QueryHoisted{Slot: slot, Query: q}
+}
+
+// singular returns segments as a singular query if each segment is a child segment
+// with a single name or index selector.
+func singular(segments []ast.Expr) (ast.QuerySingularAbs, bool) {
+	q := ast.QuerySingularAbs{Segments: make([]ast.ExprSingle, len(segments))}
+	for i, s := range segments {
+		c, ok := s.(ast.SegmentChild)
+		if !ok || len(c.Selectors) != 1 {
+			return ast.QuerySingularAbs{}, false
+		}
+		switch selector := c.Selectors[0].(type) {
+		case ast.SelectorName:
+			q.Segments[i] = ast.SegmentName{Name: selector.Name}
+		case ast.SelectorIndex:
+			q.Segments[i] = ast.SegmentIndex{Index: selector.Index}
+		default:
+			return ast.QuerySingularAbs{}, false
+		}
+	}
+	return q, true
+}
+
+// cost estimates the relative cost of evaluating an expression against a node.
+//
+// Walking a singular query is cheap, while queries that select many nodes
+// and regular expressions are expensive.
+func cost(e ast.Expr) int {
+	switch e := e.(type) {
+	case ast.Literal, ast.ExprLogicalConstant:
+		return 0
+	case ast.QueryHoisted:
+		return 1
+	case ast.QuerySingularRel:
+		return 1 + len(e.Segments)
+	case ast.ExprLogicalNot:
+		return cost(e.Expr)
+	case ast.ExprParen:
+		return cost(e.Expr)
+	case ast.ExprLogicalOr:
+		return costAll(e.Exprs)
+	case ast.ExprLogicalAnd:
+		return costAll(e.Exprs)
+	case ast.ExprComparison:
+		return cost(e.Left) + cost(e.Right) + 1
+	case ast.QueryRel:
+		return costSegments(e.Segments)
+	case ast.QueryJSONPath:
+		return costSegments(e.Segments)
+	case ast.FuncLength:
+		return cost(e.Expr) + 1
+	case ast.FuncCount:
+		return cost(e.Expr) + 1
+	case ast.FuncValue:
+		return cost(e.Expr) + 1
+	case ast.FuncMatch:
+		return cost(e.Expr) + cost(e.Pattern) + 20
+	case ast.FuncSearch:
+		return cost(e.Expr) + cost(e.Pattern) + 20
+	default:
+		return 1
+	}
+}
+
+func costAll(exprs []ast.Expr) int {
+	n := 0
+	for _, e := range exprs {
+		n += cost(e)
+	}
+	return n
+}
+
+// costSegments estimates the cost of a query, where every segment that may select
+// several nodes multiplies the cost of the segments after it.
+func costSegments(segments []ast.Expr) int {
+	n, fanout := 0, 1
+	for _, s := range segments {
+		var selectors []ast.Expr
+		switch s := s.(type) {
+		case ast.SegmentChild:
+			selectors = s.Selectors
+		case ast.SegmentDescendant:
+			selectors = s.Selectors
+			fanout *= 100
+		}
+		for _, selector := range selectors {
+			switch selector := selector.(type) {
+			case ast.SelectorName, ast.SelectorIndex:
+				n += fanout
+			case ast.SelectorFilter:
+				n += fanout * 10 * (cost(selector.Expr) + 1)
+			default:
+				n += fanout * 10
+			}
+		}
+		if len(selectors) > 1 || !singularSelector(selectors) {
+			fanout *= 10
+		}
+	}
+	return n + 1
+}
+
+func singularSelector(selectors []ast.Expr) bool {
+	if len(selectors) != 1 {
+		return false
+	}
+	switch selectors[0].(type) {
+	case ast.SelectorName, ast.SelectorIndex:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,138 @@
+package optimizer_test
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/marcfyk/go-jsonpath/internal/ast"
+	"github.com/marcfyk/go-jsonpath/internal/eval"
+	"github.com/marcfyk/go-jsonpath/internal/optimizer"
+	"github.com/marcfyk/go-jsonpath/internal/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func parse(t *testing.T, query string) ast.QueryJSONPath {
+	t.Helper()
+	p := parser.New(query)
+	e, err := p.Parse()
+	assert.Nil(t, err)
+	return e.(ast.QueryJSONPath)
+}
+
+func TestOptimize(t *testing.T) {
+	cases := []struct {
+		query    string
+		expected string
+	}{
+		{`$.a`, `$['a']`},
+		{`$[0,0]`, `$[0,0]`},
+		{`$[?1 == 1]`, `$[*]`},
+		{`$[?1 == 2]`, `$[?true==false]`},
+		{`$[?1 == 2 || @.a]`, `$[?@['a']]`},
+		{`$[?!(1 == 2) && @.a]`, `$[?@['a']]`},
+		{`$[?length('ab') == 2]`, `$[*]`},
+		{`$[?(@.a)]`, `$[?@['a']]`},
+		{`$[?!(!@.a)]`, `$[?@['a']]`},
+		{`$[?(@.a || @.b) || @.c]`, `$[?@['a']||@['b']||@['c']]`},
+		{`$[?(@.a || @.b) && @.c]`, `$[?@['c']&&(@['a']||@['b'])]`},
+		{`$[?@.a && @.a]`, `$[?@['a']]`},
+		{`$[?@[0,0,1]]`, `$[?@[0,1]]`},
+		{`$[?count(@[0,0]) == 2]`, `$[?count(@[0,0])==2]`},
+		{`$[?@.b < $.limit]`, `$[?@['b']<$['limit']]`},
+		{`$[?@..x && @.a]`, `$[?@['a']&&@..['x']]`},
+		{`$[?match(@.a, 'x.*') && @.b == 1]`, `$[?@['b']==1&&match(@['a'],'x.*')]`},
+	}
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			assert.Equal(t, c.expected, ast.Format(optimizer.Optimize(parse(t, c.query))))
+		})
+	}
+}
+
+func TestHoist(t *testing.T) {
+	q := optimizer.Optimize(parse(t, `$[?@.a == $.x && @.b != $.y && @.c == $.x]`))
+	filter := q.Segments[0].(ast.SegmentChild).Selectors[0].(ast.SelectorFilter)
+	var slots []int
+	for _, e := range filter.Expr.(ast.ExprLogicalAnd).Exprs {
+		slots = append(slots, e.(ast.ExprComparison).Right.(ast.QueryHoisted).Slot)
+	}
+	assert.Equal(t, []int{0, 1, 0}, slots)
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	queries := []string{
+		`$.a["b\n'c"][0][-1]`,
+		`$..*`,
+		`$[1:3,::-1,:2:2]`,
+		`$[?@.a == 'x' && (@.b || !@.c)]`,
+		`$[?!(@.a > 1)]`,
+		`$[?(@.a || @.b) && @.c]`,
+		`$[?search(@.a, $.p) || count(@.*) >= 1e3]`,
+		`$[?value(@..x) == null]`,
+	}
+	for _, query := range queries {
+		t.Run(query, func(t *testing.T) {
+			formatted := ast.Format(parse(t, query))
+			assert.Equal(t, formatted, ast.Format(parse(t, formatted)))
+		})
+	}
+}
+
+const document = `{
+	"limit": 3,
+	"names": ["a", "b"],
+	"items": [
+		{"name": "a", "price": 1, "tags": ["x", "y"]},
+		{"name": "b", "price": 5, "tags": []},
+		{"name": "c", "price": 3, "sub": {"name": "a", "price": 2}},
+		{"price": null},
+		[1, 2, 3]
+	]
+}`
+
+func TestEquivalence(t *testing.T) {
+	var doc any
+	assert.Nil(t, json.Unmarshal([]byte(document), &doc))
+	queries := []string{
+		`$.items[?@.price < $.limit]`,
+		`$.items[?@.price < $.limit && @.price > 1]`,
+		`$..[?@.price <= $.limit || @.name == $.names[1]]`,
+		`$.items[?$.missing]`,
+		`$.items[?!$.limit]`,
+		`$.items[?1 == 1]`,
+		`$.items[?1 == 2 || @.tags]`,
+		`$.items[?@.tags[0,0,1] && !(@.name == 'b')]`,
+		`$.items[?count(@.tags[0,0]) == 2]`,
+		`$.items[?match(@.name, '[ab]') && @.price >= 1]`,
+		`$.items[?length(@.name) == length('a')]`,
+		`$.items[?value($.items[0].price) == @.price]`,
+		`$.items[?@..name && (@.price || @.sub)]`,
+		`$.items[0,0,1]`,
+	}
+	for _, query := range queries {
+		t.Run(query, func(t *testing.T) {
+			q := parse(t, query)
+			plain, err := eval.Compile(q, eval.Options{})
+			assert.Nil(t, err)
+			optimized, err := eval.Compile(optimizer.Optimize(q), eval.Options{})
+			assert.Nil(t, err)
+			expected, err := plain.Select(doc)
+			assert.Nil(t, err)
+			actual, err := optimized.Select(doc)
+			assert.Nil(t, err)
+			assert.Equal(t, locations(expected), locations(actual))
+		})
+	}
+}
+
+// locations returns the sorted locations of nodes,
+// since members of objects are selected in an unspecified order.
+func locations(nodes []ast.Node) []ast.Location {
+	l := make([]ast.Location, len(nodes))
+	for i, n := range nodes {
+		l[i] = n.Location
+	}
+	slices.Sort(l)
+	return l
+}
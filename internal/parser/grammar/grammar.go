@@ -62,16 +62,16 @@ const (
 	FuncValue  = "value"
 )
 
-var (
-	Null = [...]rune{'n', 'u', 'l', 'l'}
-
-	True  = [...]rune{'t', 'r', 'u', 'e'}
-	False = [...]rune{'f', 'a', 'l', 's', 'e'}
-
-	And = [...]rune{Ampersand, Ampersand}
-	Or  = [...]rune{Pipe, Pipe}
+const (
+	Null = "null"
 
-	DescendantPrefix = [...]rune{Dot, Dot}
+	True  = "true"
+	False = "false"
+)
 
-	Escapable = [...]rune{BS, FF, LF, CR, HT, Slash, BackSlash}
+// MinInt and MaxInt are the bounds of integers that can be represented exactly
+// in the I-JSON number range.
+const (
+	MinInt = -(1<<53 - 1)
+	MaxInt = 1<<53 - 1
 )
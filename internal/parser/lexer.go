@@ -0,0 +1,395 @@
+package parser
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/marcfyk/go-jsonpath/internal/parser/grammar"
+)
+
+// tokenKind is the lexical category of a token.
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenRoot
+	tokenCurrent
+	tokenDot
+	tokenDotDot
+	tokenBracketOpen
+	tokenBracketClose
+	tokenParenOpen
+	tokenParenClose
+	tokenComma
+	tokenColon
+	tokenQuestion
+	tokenAsterisk
+	tokenNot
+	tokenAnd
+	tokenOr
+	tokenEq
+	tokenNe
+	tokenLt
+	tokenLte
+	tokenGt
+	tokenGte
+	tokenString
+	tokenNumber
+	tokenName
+)
+
+// token is a lexeme scanned from a jsonpath string.
+type token struct {
+	kind tokenKind
+	// start is the byte offset of the first byte of the token.
+	start int
+	// end is the byte offset after the last byte of the token.
+	end int
+	// space reports if the token is preceded by blank space.
+	space bool
+	// value is the decoded contents of string literals and names.
+	value string
+	// isInt reports if a number token has neither a fraction nor an exponent.
+	isInt bool
+}
+
+// lexer scans the UTF-8 bytes of a jsonpath string into tokens.
+//
+// The lexer produces tokens on demand and never revisits a byte
+// it has already scanned.
+type lexer struct {
+	src string
+	pos int
+}
+
+// errorUnexpectedCodepoint returns an ErrUnexpectedCodepoint for the codepoint at index.
+func (l *lexer) errorUnexpectedCodepoint(index int) ErrUnexpectedCodepoint {
+	var c *rune
+	if index < len(l.src) {
+		r, _ := utf8.DecodeRuneInString(l.src[index:])
+		c = &r
+	}
+	return ErrUnexpectedCodepoint{
+		codepoint: c,
+		index:     index,
+	}
+}
+
+// next scans the next token.
+func (l *lexer) next() (token, error) {
+	start := l.pos
+	for l.pos < len(l.src) && isBlankSpace(rune(l.src[l.pos])) {
+		l.pos++
+	}
+	t := token{start: l.pos, space: l.pos > start}
+	if l.pos == len(l.src) {
+		t.kind = tokenEOF
+		t.end = l.pos
+		return t, nil
+	}
+	var err error
+	switch c := l.src[l.pos]; c {
+	case grammar.Dollar:
+		t.kind = l.single(tokenRoot)
+	case grammar.At:
+		t.kind = l.single(tokenCurrent)
+	case grammar.Dot:
+		t.kind = l.pair(grammar.Dot, tokenDot, tokenDotDot)
+	case grammar.BracketOpen:
+		t.kind = l.single(tokenBracketOpen)
+	case grammar.BracketClose:
+		t.kind = l.single(tokenBracketClose)
+	case grammar.ParenthesisOpen:
+		t.kind = l.single(tokenParenOpen)
+	case grammar.ParenthesisClose:
+		t.kind = l.single(tokenParenClose)
+	case grammar.Comma:
+		t.kind = l.single(tokenComma)
+	case grammar.Colon:
+		t.kind = l.single(tokenColon)
+	case grammar.Question:
+		t.kind = l.single(tokenQuestion)
+	case grammar.Asterisk:
+		t.kind = l.single(tokenAsterisk)
+	case grammar.Bang:
+		t.kind = l.pair(grammar.Eq, tokenNot, tokenNe)
+	case grammar.Lt:
+		t.kind = l.pair(grammar.Eq, tokenLt, tokenLte)
+	case grammar.Gt:
+		t.kind = l.pair(grammar.Eq, tokenGt, tokenGte)
+	case grammar.Eq:
+		t.kind, err = l.double(grammar.Eq, tokenEq)
+	case grammar.Ampersand:
+		t.kind, err = l.double(grammar.Ampersand, tokenAnd)
+	case grammar.Pipe:
+		t.kind, err = l.double(grammar.Pipe, tokenOr)
+	case grammar.QuoteDouble, grammar.QuoteSingle:
+		t.kind = tokenString
+		t.value, err = l.string(c)
+	default:
+		switch {
+		case c == grammar.Minus || isDigit(rune(c)):
+			t.kind = tokenNumber
+			t.isInt, err = l.number()
+		default:
+			t.kind = tokenName
+			t.value, err = l.name()
+		}
+	}
+	if err != nil {
+		return token{}, err
+	}
+	t.end = l.pos
+	return t, nil
+}
+
+// single consumes a one byte token.
+func (l *lexer) single(kind tokenKind) tokenKind {
+	l.pos++
+	return kind
+}
+
+// pair consumes a one byte token, or a two byte token if the second byte is c.
+func (l *lexer) pair(c byte, one, two tokenKind) tokenKind {
+	l.pos++
+	if l.pos < len(l.src) && l.src[l.pos] == c {
+		l.pos++
+		return two
+	}
+	return one
+}
+
+// double consumes a two byte token where the second byte must be c.
+func (l *lexer) double(c byte, kind tokenKind) (tokenKind, error) {
+	l.pos++
+	if l.pos == len(l.src) || l.src[l.pos] != c {
+		return tokenEOF, l.errorUnexpectedCodepoint(l.pos)
+	}
+	l.pos++
+	return kind, nil
+}
+
+// name consumes a member-name-shorthand, which also covers
+// function names and the true, false and null keywords.
+func (l *lexer) name() (string, error) {
+	start := l.pos
+	r, size := utf8.DecodeRuneInString(l.src[l.pos:])
+	if r == utf8.RuneError || !isNameFirst(r) {
+		return "", l.errorUnexpectedCodepoint(l.pos)
+	}
+	l.pos += size
+	for l.pos < len(l.src) {
+		r, size := utf8.DecodeRuneInString(l.src[l.pos:])
+		if r == utf8.RuneError && size == 1 {
+			return "", l.errorUnexpectedCodepoint(l.pos)
+		}
+		if !isNameChar(r) {
+			break
+		}
+		l.pos += size
+	}
+	return l.src[start:l.pos], nil
+}
+
+// number consumes a number and reports whether it is an int,
+// i.e. it has neither a fraction nor an exponent.
+func (l *lexer) number() (bool, error) {
+	if l.src[l.pos] == grammar.Minus {
+		l.pos++
+	}
+	if !l.matchBy(isDigit) {
+		return false, l.errorUnexpectedCodepoint(l.pos)
+	}
+	if l.src[l.pos] == '0' {
+		l.pos++
+	} else {
+		l.digits()
+	}
+	isInt := true
+	if l.match(grammar.Dot) && l.peekBy(1, isDigit) {
+		l.pos++
+		l.digits()
+		isInt = false
+	}
+	if l.match('e') || l.match('E') {
+		offset := 1
+		if l.peekBy(1, func(r rune) bool { return r == grammar.Minus || r == grammar.Plus }) {
+			offset++
+		}
+		if l.peekBy(offset, isDigit) {
+			l.pos += offset
+			l.digits()
+			isInt = false
+		}
+	}
+	return isInt, nil
+}
+
+// digits consumes a run of zero or more digits.
+func (l *lexer) digits() {
+	for l.matchBy(isDigit) {
+		l.pos++
+	}
+}
+
+// string consumes a string literal delimited by quote and returns its decoded value.
+func (l *lexer) string(quote byte) (string, error) {
+	l.pos++
+	start := l.pos
+	// Strings without escapes are sliced out of the source without copying.
+	for l.pos < len(l.src) && l.src[l.pos] != quote && l.src[l.pos] != grammar.Esc {
+		if err := l.unescaped(); err != nil {
+			return "", err
+		}
+	}
+	if l.pos == len(l.src) {
+		return "", l.errorUnexpectedCodepoint(l.pos)
+	}
+	if l.src[l.pos] == quote {
+		l.pos++
+		return l.src[start : l.pos-1], nil
+	}
+	var b strings.Builder
+	b.WriteString(l.src[start:l.pos])
+	for {
+		if l.pos == len(l.src) {
+			return "", l.errorUnexpectedCodepoint(l.pos)
+		}
+		switch c := l.src[l.pos]; c {
+		case quote:
+			l.pos++
+			return b.String(), nil
+		case grammar.Esc:
+			r, err := l.escaped(quote)
+			if err != nil {
+				return "", err
+			}
+			b.WriteRune(r)
+		default:
+			start := l.pos
+			if err := l.unescaped(); err != nil {
+				return "", err
+			}
+			b.WriteString(l.src[start:l.pos])
+		}
+	}
+}
+
+// unescaped consumes a codepoint that may appear in a string literal without escaping.
+// Either quote may appear here since the closing quote is checked by the caller.
+func (l *lexer) unescaped() error {
+	r, size := utf8.DecodeRuneInString(l.src[l.pos:])
+	if (r == utf8.RuneError && size == 1) || !(isUnescaped(r) || isQuote(r)) {
+		return l.errorUnexpectedCodepoint(l.pos)
+	}
+	l.pos += size
+	return nil
+}
+
+// escaped consumes an escape sequence and returns the codepoint it denotes.
+func (l *lexer) escaped(quote byte) (rune, error) {
+	l.pos++
+	if l.pos == len(l.src) {
+		return 0, l.errorUnexpectedCodepoint(l.pos)
+	}
+	c := l.src[l.pos]
+	l.pos++
+	switch c {
+	case quote:
+		return rune(quote), nil
+	case grammar.BS:
+		return '\b', nil
+	case grammar.FF:
+		return '\f', nil
+	case grammar.LF:
+		return '\n', nil
+	case grammar.CR:
+		return '\r', nil
+	case grammar.HT:
+		return '\t', nil
+	case grammar.Slash:
+		return '/', nil
+	case grammar.BackSlash:
+		return '\\', nil
+	case grammar.UnicodeEscape:
+		return l.hexChar()
+	default:
+		return 0, l.errorUnexpectedCodepoint(l.pos - 1)
+	}
+}
+
+// hexChar consumes the hex digits of a unicode escape,
+// including the low surrogate escape that must follow a high surrogate.
+func (l *lexer) hexChar() (rune, error) {
+	start := l.pos
+	r, err := l.hex4()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case 0xDC00 <= r && r <= 0xDFFF:
+		return 0, l.errorUnexpectedCodepoint(start)
+	case 0xD800 <= r && r <= 0xDBFF:
+		if !strings.HasPrefix(l.src[l.pos:], `\u`) {
+			return 0, l.errorUnexpectedCodepoint(l.pos)
+		}
+		l.pos += 2
+		start := l.pos
+		low, err := l.hex4()
+		if err != nil {
+			return 0, err
+		}
+		if low < 0xDC00 || 0xDFFF < low {
+			return 0, l.errorUnexpectedCodepoint(start)
+		}
+		return 0x10000 + (r-0xD800)<<10 + (low - 0xDC00), nil
+	default:
+		return r, nil
+	}
+}
+
+// hex4 consumes four hex digits.
+func (l *lexer) hex4() (rune, error) {
+	var r rune
+	for range 4 {
+		if l.pos == len(l.src) {
+			return 0, l.errorUnexpectedCodepoint(l.pos)
+		}
+		d, ok := hexValue(l.src[l.pos])
+		if !ok {
+			return 0, l.errorUnexpectedCodepoint(l.pos)
+		}
+		r = r<<4 | d
+		l.pos++
+	}
+	return r, nil
+}
+
+// match returns if the current byte equals c.
+func (l *lexer) match(c byte) bool {
+	return l.pos < len(l.src) && l.src[l.pos] == c
+}
+
+// matchBy returns if the current byte satisfies the predicate, f.
+func (l *lexer) matchBy(f func(rune) bool) bool {
+	return l.peekBy(0, f)
+}
+
+// peekBy returns if the byte offset bytes after the current byte satisfies the predicate, f.
+func (l *lexer) peekBy(offset int, f func(rune) bool) bool {
+	i := l.pos + offset
+	return i < len(l.src) && f(rune(l.src[i]))
+}
+
+func hexValue(c byte) (rune, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return rune(c - '0'), true
+	case 'A' <= c && c <= 'F':
+		return rune(c-'A') + 10, true
+	case 'a' <= c && c <= 'f':
+		return rune(c-'a') + 10, true
+	default:
+		return 0, false
+	}
+}
@@ -1,433 +1,379 @@
 // Package parser implements the parsing component of jsonpath expressions.
 //
-// The parser component should take in jsonpath expressions or tokens output from a lexer,
-// and output an IR of the jsonpath expression.
+// The parser component takes in the tokens output from a lexer,
+// and outputs an IR of the jsonpath expression.
 package parser
 
 import (
-	"errors"
 	"fmt"
-	"math"
 	"regexp"
+	"strconv"
 
 	"github.com/marcfyk/go-jsonpath/internal/ast"
+	"github.com/marcfyk/go-jsonpath/internal/iregexp"
 	"github.com/marcfyk/go-jsonpath/internal/parser/grammar"
 )
 
+// DefaultMaxDepth is the MaxDepth of the Parser returned by New.
+const DefaultMaxDepth = 512
+
 func New(jsonpath string) Parser {
-	if jsonpath == "" {
-		return Parser{Codepoints: []rune{}, Index: 0}
-	}
-	codepoints := []rune(jsonpath)
 	return Parser{
-		Codepoints: codepoints,
-		Index:      0,
+		lexer:    lexer{src: jsonpath},
+		Index:    0,
+		MaxDepth: DefaultMaxDepth,
 	}
 }
 
-// ErrUnexpectedCodepoint is the error type when the parser encounters a codepoint
+// ErrUnexpectedCodepoint is the error type when the lexer encounters a codepoint
 // it does not expect at its given state.
 type ErrUnexpectedCodepoint struct {
-	// codepoint is the actual unicode codepoint encountered by the parser.
+	// codepoint is the actual unicode codepoint encountered by the lexer,
+	// or nil at the end of the jsonpath string.
 	codepoint *rune
-	// index is the index of the unicode codepoint.
+	// index is the byte offset of the unicode codepoint.
 	index int
 }
 
 func (e ErrUnexpectedCodepoint) Error() string {
+	if e.codepoint == nil {
+		return fmt.Sprintf("unexpected end of jsonpath; found at index:%d", e.index)
+	}
 	return fmt.Sprintf(
-		"unexpected codepoint:%v; found at index:%d",
-		e.codepoint, e.index)
+		"unexpected codepoint:%q; found at index:%d",
+		*e.codepoint, e.index)
 }
 
-// Parser is a recursive descent parser that scans jsonpath strings.
-type Parser struct {
-	// Codepoints is a slice of unicode Codepoints from the given jsonpath string.
-	Codepoints []rune
-	// Index is the zero-based Index of the current codepoint.
+// ErrUnexpectedToken is the error type when the parser encounters a token
+// it does not expect at its given state.
+type ErrUnexpectedToken struct {
+	// Token is the text of the token, which is empty at the end of the jsonpath string.
+	Token string
+	// Index is the byte offset of the token.
 	Index int
 }
 
-// IsDone returns if the parser has consumed all codepoints in its buffer.
-func (p *Parser) IsDone() bool {
-	return p.Index == len(p.Codepoints)
+func (e ErrUnexpectedToken) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("unexpected end of jsonpath; found at index:%d", e.Index)
+	}
+	return fmt.Sprintf("unexpected token:%q; found at index:%d", e.Token, e.Index)
 }
 
-// Parse parses the codepoints in the Parser according to the jsonpath grammar rules.
-func (p *Parser) Parse() (ast.Expr, error) {
-	return p.queryJSONPath()
+// ErrOutOfRange is the error type when a number in the query cannot be represented exactly,
+// i.e. integers beyond ±(2^53-1) or numbers beyond the range of float64.
+type ErrOutOfRange struct {
+	// Number is the text of the number.
+	Number string
+	// Index is the byte offset of the number.
+	Index int
 }
 
-// errorUnsupportedCodepoint returns an ErrUnexpectedCodepoint error with the current state of
-// the Parser's index.
-func (p *Parser) errorUnsupportedCodepoint() ErrUnexpectedCodepoint {
-	var c *rune
-	if 0 <= p.Index && p.Index < len(p.Codepoints) {
-		c = &p.Codepoints[p.Index]
-	}
-	return ErrUnexpectedCodepoint{
-		codepoint: c,
-		index:     p.Index,
-	}
+func (e ErrOutOfRange) Error() string {
+	return fmt.Sprintf("number out of range:%s; found at index:%d", e.Number, e.Index)
+}
+
+// ErrWrongTypeExpr is the error type when an expression is used in a position
+// that does not allow its type, e.g. a non-singular query in a comparison.
+type ErrWrongTypeExpr struct {
+	// Index is the byte offset of the expression.
+	Index int
+	// ExpectedType is the type required.
+	ExpectedType interface{}
+	// ActualType is the type of the expression in the query.
+	ActualType interface{}
 }
 
-// shift will move the index to the next codepoint
-// based on the sequence of codepoints.
-func (p *Parser) shift() {
-	p.Index = min(p.Index+1, len(p.Codepoints))
+func (e ErrWrongTypeExpr) Error() string {
+	return fmt.Sprintf(
+		"invalid type at index:%d; expected:%v; actual:%v",
+		e.Index, e.ExpectedType, e.ActualType)
+}
+
+// ErrMaxDepth is the error type when expressions in the query are nested deeper than
+// the MaxDepth of the Parser, e.g. parentheses, filters or function arguments.
+type ErrMaxDepth struct {
+	// Depth is the maximum depth.
+	Depth int
+	// Index is the byte offset of the expression that exceeds the maximum depth.
+	Index int
 }
 
-// matchBy will return if current codepoint is true or false
-// based on the application of predicate, f, on the current codepoint.
-// If the index is out of range, it is always false.
-func (p *Parser) matchBy(f func(rune) bool) bool {
+func (e ErrMaxDepth) Error() string {
+	return fmt.Sprintf("nesting exceeds the maximum depth:%d; found at index:%d", e.Depth, e.Index)
+}
 
-	return 0 <= p.Index && p.Index < len(p.Codepoints) && f(p.Codepoints[p.Index])
+// Parser is a predictive recursive descent parser that scans jsonpath strings.
+//
+// The Parser reads tokens from its lexer with one token of lookahead and never backtracks,
+// so every byte of the jsonpath string is scanned once.
+type Parser struct {
+	// lexer is the source of tokens.
+	lexer lexer
+	// tok is the current token.
+	tok token
+	// Index is the zero-based byte offset of the current token.
+	Index int
+	// MaxDepth is the maximum nesting of logical expressions and function expressions,
+	// each of which is parsed by a recursive call. There is no maximum if it is 0.
+	MaxDepth int
+	// depth is the nesting of the expression being parsed.
+	depth int
 }
 
-// match returns if the current codepoint equals a given codepoint.
-func (p *Parser) match(codepoint rune) bool {
-	return p.matchBy(func(r rune) bool { return r == codepoint })
+// IsDone returns if the parser has consumed all bytes in its buffer.
+func (p *Parser) IsDone() bool {
+	return p.Index == len(p.lexer.src)
 }
 
-// expectBy attempts to match the current codepoint by predicate, f.
-// If the predicate evaluates to true, the parser shifts to the next codepoint.
-// If the predicate evaluates to false, then ErrUnexpectedToken is returned.
-func (p *Parser) expectBy(f func(rune) bool) error {
-	if !p.matchBy(f) {
-		return p.errorUnsupportedCodepoint()
+// Parse parses the jsonpath string in the Parser according to the jsonpath grammar rules.
+func (p *Parser) Parse() (ast.Expr, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
 	}
-	p.shift()
+	if p.tok.space {
+		return nil, p.errorUnexpectedToken()
+	}
+	q, err := p.queryJSONPath()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokenEOF || p.tok.space {
+		return nil, p.errorUnexpectedToken()
+	}
+	return q, nil
+}
+
+// errorUnexpectedToken returns an ErrUnexpectedToken error with the current token.
+func (p *Parser) errorUnexpectedToken() ErrUnexpectedToken {
+	return ErrUnexpectedToken{
+		Token: p.lexer.src[p.tok.start:p.tok.end],
+		Index: p.tok.start,
+	}
+}
+
+// advance scans the next token.
+func (p *Parser) advance() error {
+	t, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.tok = t
+	p.Index = t.start
 	return nil
 }
 
-// expect attempts to match the current codepoint against a given codepoint.
-// The behavior and side effects on whether the match is successful is the same as expectBy.
-func (p *Parser) expect(codepoint rune) error {
-	return p.expectBy(func(r rune) bool { return r == codepoint })
+// expect checks that the current token is of the given kind and advances to the next token.
+// If the current token is of a different kind, ErrUnexpectedToken is returned.
+func (p *Parser) expect(kind tokenKind) error {
+	if p.tok.kind != kind {
+		return p.errorUnexpectedToken()
+	}
+	return p.advance()
 }
 
-// queryJSONPath will parse the given codepoints based on the jsonpath grammar rules.
-func (p *Parser) queryJSONPath() (ast.Expr, error) {
-	if err := p.identRootNode(); err != nil {
-		return nil, err
+// queryJSONPath parses a query starting with the root identifier.
+func (p *Parser) queryJSONPath() (ast.QueryJSONPath, error) {
+	if err := p.expect(tokenRoot); err != nil {
+		return ast.QueryJSONPath{}, err
 	}
 	segments, err := p.segments()
 	if err != nil {
-		return nil, err
+		return ast.QueryJSONPath{}, err
 	}
 	return ast.QueryJSONPath{
 		Segments: segments,
 	}, nil
 }
 
-func (p *Parser) identRootNode() error {
-	return p.expect(grammar.Dollar)
-}
-
-func (p *Parser) blankSpace() {
-	for p.expectBy(isBlankSpace) == nil {
+// queryRel parses a query starting with the current node identifier.
+func (p *Parser) queryRel() (ast.QueryRel, error) {
+	if err := p.expect(tokenCurrent); err != nil {
+		return ast.QueryRel{}, err
+	}
+	segments, err := p.segments()
+	if err != nil {
+		return ast.QueryRel{}, err
 	}
+	return ast.QueryRel{
+		Segments: segments,
+	}, nil
 }
 
 func (p *Parser) segments() ([]ast.Expr, error) {
 	segments := make([]ast.Expr, 0)
 	for {
-		if p.matchBy(isBlankSpace) {
-			p.blankSpace()
+		var s ast.Expr
+		var err error
+		switch p.tok.kind {
+		case tokenDot, tokenBracketOpen:
+			s, err = p.segmentChild()
+		case tokenDotDot:
+			s, err = p.segmentDescendant()
+		default:
+			return segments, nil
 		}
-		initial := p.Index
-		s, err := p.segment()
 		if err != nil {
-			p.Index = initial
-			break
+			return nil, err
 		}
 		segments = append(segments, s)
 	}
-	return segments, nil
-}
-
-func (p *Parser) segment() (ast.Expr, error) {
-	initial := p.Index
-	if s, err := p.segmentChild(); err == nil {
-		return s, nil
-	}
-	p.Index = initial
-	return p.segmentDescendant()
 }
 
 func (p *Parser) segmentChild() (ast.Expr, error) {
-	if b, err := p.bracketedSelection(); err == nil {
-		return ast.SegmentChild{
-			Selectors: b,
-		}, nil
-	} else {
-		if err := p.expect(grammar.Dot); err != nil {
+	if p.tok.kind == tokenBracketOpen {
+		selectors, err := p.bracketedSelection()
+		if err != nil {
 			return nil, err
 		}
-		if w, err := p.selectorWildcard(); err == nil {
-			return w, nil
-		} else if n, err := p.memberNameShorthand(); err == nil {
-			return ast.SegmentChild{
-				Selectors: []ast.Expr{ast.SelectorName{Name: n}},
-			}, nil
-		} else {
-			return nil, p.errorUnsupportedCodepoint()
-		}
+		return ast.SegmentChild{
+			Selectors: selectors,
+		}, nil
 	}
-}
-
-func (p *Parser) bracketedSelection() ([]ast.Expr, error) {
-	selectors := make([]ast.Expr, 0)
-	if err := p.expect(grammar.BracketOpen); err != nil {
+	if err := p.expect(tokenDot); err != nil {
 		return nil, err
 	}
-	p.blankSpace()
-	s, err := p.selector()
+	s, err := p.shorthandSelector()
 	if err != nil {
 		return nil, err
 	}
-	selectors = append(selectors, s)
-	for {
-		if p.matchBy(isBlankSpace) {
-			p.blankSpace()
-		}
-		if err := p.expect(grammar.Comma); err != nil {
-			break
-		}
-		p.blankSpace()
-		s, err := p.selector()
+	return ast.SegmentChild{
+		Selectors: []ast.Expr{s},
+	}, nil
+}
+
+func (p *Parser) segmentDescendant() (ast.Expr, error) {
+	if err := p.expect(tokenDotDot); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokenBracketOpen && !p.tok.space {
+		selectors, err := p.bracketedSelection()
 		if err != nil {
 			return nil, err
 		}
-		selectors = append(selectors, s)
+		return ast.SegmentDescendant{
+			Selectors: selectors,
+		}, nil
 	}
-	p.blankSpace()
-	if err := p.expect(grammar.BracketClose); err != nil {
+	s, err := p.shorthandSelector()
+	if err != nil {
 		return nil, err
 	}
-	return selectors, nil
+	return ast.SegmentDescendant{
+		Selectors: []ast.Expr{s},
+	}, nil
 }
 
-func (p *Parser) selector() (ast.Expr, error) {
-	switch {
-	case p.matchBy(isQuote):
-		return p.selectorName()
-	case p.match(grammar.Asterisk):
+// shorthandSelector parses the wildcard selector or member-name-shorthand
+// that immediately follows a "." or "..".
+func (p *Parser) shorthandSelector() (ast.Expr, error) {
+	if p.tok.space {
+		return nil, p.errorUnexpectedToken()
+	}
+	switch p.tok.kind {
+	case tokenAsterisk:
 		return p.selectorWildcard()
-	case p.matchBy(func(r rune) bool { return isDigit(r) || r == '-' || r == ':' }):
-		initial := p.Index
-		if s, err := p.selectorSlice(); err == nil {
-			return s, nil
+	case tokenName:
+		name := p.tok.value
+		if err := p.advance(); err != nil {
+			return nil, err
 		}
-		p.Index = initial
-		return p.selectorIndex()
+		return ast.SelectorName{Name: name}, nil
 	default:
-		return p.selectorFilter()
-	}
-}
-
-func (p *Parser) selectorName() (ast.SelectorName, error) {
-	s, err := p.literalString()
-	if err != nil {
-		return ast.SelectorName{}, err
+		return nil, p.errorUnexpectedToken()
 	}
-	return ast.SelectorName{Name: s}, nil
 }
 
-func (p *Parser) literalString() (string, error) {
-	if p.expect(grammar.QuoteDouble) == nil {
-		start := p.Index
-		for p.quotedDouble() == nil {
-		}
-		if err := p.expect(grammar.QuoteDouble); err != nil {
-			return "", err
-		}
-		end := p.Index - 1
-		return string(p.Codepoints[start:end]), nil
-	} else if p.expect(grammar.QuoteSingle) == nil {
-		start := p.Index
-		for p.quotedSingle() == nil {
-		}
-		if err := p.expect(grammar.QuoteSingle); err != nil {
-			return "", err
-		}
-		end := p.Index - 1
-		return string(p.Codepoints[start:end]), nil
-	} else {
-		return "", p.errorUnsupportedCodepoint()
-	}
-}
-
-func (p *Parser) quotedDouble() error {
-	if p.expectBy(isUnescaped) == nil {
-		return nil
-	} else if p.expect(grammar.QuoteSingle) == nil {
-		return nil
-	} else if p.expect(grammar.Esc) == nil {
-		if p.expect(grammar.QuoteDouble) == nil {
-			return nil
-		} else if p.escapable() == nil {
-			return nil
-		} else {
-			return p.errorUnsupportedCodepoint()
-		}
-	} else {
-		return p.errorUnsupportedCodepoint()
-	}
-}
-
-func (p *Parser) quotedSingle() error {
-	if p.expectBy(isUnescaped) == nil {
-		return nil
-	} else if p.expect(grammar.QuoteDouble) == nil {
-		return nil
-	} else if p.expect(grammar.Esc) == nil {
-		if p.expect(grammar.QuoteSingle) == nil {
-			return nil
-		} else if p.escapable() == nil {
-			return nil
-		} else {
-			return p.errorUnsupportedCodepoint()
-		}
-	} else {
-		return p.errorUnsupportedCodepoint()
-	}
-}
-
-func (p *Parser) escapable() error {
-	for _, r := range grammar.Escapable {
-		if p.expect(r) == nil {
-			return nil
-		}
-	}
-	if err := p.expect(grammar.UnicodeEscape); err != nil {
-		return err
+func (p *Parser) bracketedSelection() ([]ast.Expr, error) {
+	if err := p.expect(tokenBracketOpen); err != nil {
+		return nil, err
 	}
-	return p.hexChar()
-}
-
-func (p *Parser) hexChar() error {
-	if p.nonSurrogate() == nil {
-		return nil
-	} else {
-		if err := p.highSurrogate(); err != nil {
-			return err
+	selectors := make([]ast.Expr, 0, 1)
+	for {
+		s, err := p.selector()
+		if err != nil {
+			return nil, err
 		}
-		if err := p.expect(grammar.BackSlash); err != nil {
-			return err
+		selectors = append(selectors, s)
+		if p.tok.kind != tokenComma {
+			break
 		}
-		if err := p.expect(grammar.UnicodeEscape); err != nil {
-			return err
+		if err := p.advance(); err != nil {
+			return nil, err
 		}
-		return p.lowSurrogate()
 	}
-}
-
-func (p *Parser) nonSurrogate() error {
-
-	if p.expect('D') == nil {
-		if err := p.expectBy(isDigit0To7); err != nil {
-			return err
-		}
-		for range 2 {
-			if err := p.expectBy(isHexDig); err != nil {
-				return err
-			}
-		}
-		return nil
-	} else {
-		predicate := func(r rune) bool {
-			return isDigit(r) ||
-				r == 'A' ||
-				r == 'B' ||
-				r == 'C' ||
-				r == 'E' ||
-				r == 'F'
-		}
-		if err := p.expectBy(predicate); err != nil {
-			return err
-		}
-		for range 3 {
-			if err := p.expectBy(isHexDig); err != nil {
-				return err
-			}
-		}
-		return nil
+	if err := p.expect(tokenBracketClose); err != nil {
+		return nil, err
 	}
+	return selectors, nil
 }
 
-func (p *Parser) highSurrogate() error {
-	if err := p.expect('D'); err != nil {
-		return err
-	}
-	predicate := func(r rune) bool {
-		return r == '8' ||
-			r == '9' ||
-			r == 'A' ||
-			r == 'B'
-	}
-	if err := p.expectBy(predicate); err != nil {
-		return err
-	}
-	for range 2 {
-		if err := p.expectBy(isHexDig); err != nil {
-			return err
-		}
+func (p *Parser) selector() (ast.Expr, error) {
+	switch p.tok.kind {
+	case tokenString:
+		return p.selectorName()
+	case tokenAsterisk:
+		return p.selectorWildcard()
+	case tokenNumber, tokenColon:
+		return p.selectorIndexOrSlice()
+	case tokenQuestion:
+		return p.selectorFilter()
+	default:
+		return nil, p.errorUnexpectedToken()
 	}
-	return nil
 }
 
-func (p *Parser) lowSurrogate() error {
-	if err := p.expect('D'); err != nil {
-		return err
-	}
-	predicate := func(r rune) bool {
-		return r == 'C' ||
-			r == 'D' ||
-			r == 'E' ||
-			r == 'F'
-	}
-	if err := p.expectBy(predicate); err != nil {
-		return err
-	}
-	for range 2 {
-		if err := p.hexChar(); err != nil {
-			return err
-		}
+func (p *Parser) selectorName() (ast.Expr, error) {
+	name := p.tok.value
+	if err := p.expect(tokenString); err != nil {
+		return nil, err
 	}
-	return nil
+	return ast.SelectorName{Name: name}, nil
 }
 
 func (p *Parser) selectorWildcard() (ast.Expr, error) {
-	if err := p.expect(grammar.Asterisk); err != nil {
+	if err := p.expect(tokenAsterisk); err != nil {
 		return nil, err
 	}
 	return ast.SelectorWildcard{}, nil
 }
 
-func (p *Parser) selectorSlice() (ast.Expr, error) {
-	start := 0
-	if s, err := p.start(); err == nil {
-		start = s
-		p.blankSpace()
+// selectorIndexOrSlice parses an index selector, or a slice selector
+// if the optional start is followed by a ":".
+func (p *Parser) selectorIndexOrSlice() (ast.Expr, error) {
+	var start *int
+	if p.tok.kind == tokenNumber {
+		n, err := p.int()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokenColon {
+			return ast.SelectorIndex{Index: n}, nil
+		}
+		start = &n
 	}
-	if err := p.expect(grammar.Colon); err != nil {
+	if err := p.expect(tokenColon); err != nil {
 		return nil, err
 	}
-	p.blankSpace()
-	end := -1
-	if e, err := p.end(); err == nil {
-		end = e
-		p.blankSpace()
+	var end *int
+	if p.tok.kind == tokenNumber {
+		n, err := p.int()
+		if err != nil {
+			return nil, err
+		}
+		end = &n
 	}
 	step := 1
-	if p.expect(grammar.Colon) == nil {
-		if p.matchBy(isBlankSpace) {
-			p.blankSpace()
+	if p.tok.kind == tokenColon {
+		if err := p.advance(); err != nil {
+			return nil, err
 		}
-		if s, err := p.step(); err == nil {
-			step = s
+		if p.tok.kind == tokenNumber {
+			n, err := p.int()
+			if err != nil {
+				return nil, err
+			}
+			step = n
 		}
 	}
 	return ast.SelectorSlice{
@@ -437,78 +383,76 @@ func (p *Parser) selectorSlice() (ast.Expr, error) {
 	}, nil
 }
 
-func (p *Parser) start() (int, error) {
-	return p.int()
-}
-
-func (p *Parser) end() (int, error) {
-	return p.int()
-}
-
-func (p *Parser) step() (int, error) {
-	return p.int()
-}
-
+// int parses an integer within the I-JSON range of exact integers.
 func (p *Parser) int() (int, error) {
-	if p.expect('0') == nil {
-		return 0, nil
-	} else {
-		isNegative := false
-		if p.expect(grammar.Minus) == nil {
-			isNegative = true
-		}
-		if err := p.expectBy(isDigit1); err != nil {
-			return 0, err
-		}
-		n := int(p.Codepoints[p.Index-1] - '0')
-		for p.expectBy(isDigit) == nil {
-			n = n*10 + int(p.Codepoints[p.Index-1]-'0')
-		}
-		if isNegative {
-			n = -n
-		}
-		return n, nil
+	text := p.lexer.src[p.tok.start:p.tok.end]
+	if p.tok.kind != tokenNumber || !p.tok.isInt || text == "-0" {
+		return 0, p.errorUnexpectedToken()
 	}
+	n, err := strconv.ParseInt(text, 10, 64)
+	if err != nil || n < grammar.MinInt || grammar.MaxInt < n {
+		return 0, ErrOutOfRange{Number: text, Index: p.tok.start}
+	}
+	if err := p.advance(); err != nil {
+		return 0, err
+	}
+	return int(n), nil
 }
 
-func (p *Parser) selectorIndex() (ast.Expr, error) {
-	n, err := p.int()
+func (p *Parser) selectorFilter() (ast.Expr, error) {
+	if err := p.expect(tokenQuestion); err != nil {
+		return nil, err
+	}
+	e, err := p.logicalExpr()
 	if err != nil {
 		return nil, err
 	}
-	return ast.SelectorIndex{Index: n}, nil
+	return ast.SelectorFilter{Expr: e}, nil
 }
 
-func (p *Parser) selectorFilter() (ast.Expr, error) {
-	if err := p.expect(grammar.Question); err != nil {
-		return nil, err
+// enter increments the depth before parsing a nested expression,
+// which must be followed by a call to leave.
+func (p *Parser) enter() error {
+	p.depth++
+	if p.MaxDepth > 0 && p.depth > p.MaxDepth {
+		return ErrMaxDepth{Depth: p.MaxDepth, Index: p.tok.start}
 	}
-	p.blankSpace()
-	return p.logicalExpr()
+	return nil
+}
+
+// leave decrements the depth after parsing a nested expression.
+func (p *Parser) leave() {
+	p.depth--
 }
 
 func (p *Parser) logicalExpr() (ast.Expr, error) {
-	return p.logicalExprOr()
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	e, err := p.basicExpr()
+	if err != nil {
+		return nil, err
+	}
+	return p.logicalExprOr(e)
 }
 
-func (p *Parser) logicalExprOr() (ast.Expr, error) {
-	exprs := make([]ast.Expr, 0)
-	e, err := p.logicalExprAnd()
+// logicalExprOr parses a logical-or-expr whose first basic-expr, first, is already parsed.
+func (p *Parser) logicalExprOr(first ast.Expr) (ast.Expr, error) {
+	e, err := p.logicalExprAnd(first)
 	if err != nil {
 		return nil, err
 	}
-	exprs = append(exprs, e)
-	for {
-		if p.matchBy(isBlankSpace) {
-			p.blankSpace()
+	exprs := []ast.Expr{e}
+	for p.tok.kind == tokenOr {
+		if err := p.advance(); err != nil {
+			return nil, err
 		}
-		initial := p.Index
-		if err := p.or(); err != nil {
-			p.Index = initial
-			break
+		first, err := p.basicExpr()
+		if err != nil {
+			return nil, err
 		}
-		p.blankSpace()
-		e, err := p.logicalExprAnd()
+		e, err := p.logicalExprAnd(first)
 		if err != nil {
 			return nil, err
 		}
@@ -519,23 +463,13 @@ func (p *Parser) logicalExprOr() (ast.Expr, error) {
 	}, nil
 }
 
-func (p *Parser) logicalExprAnd() (ast.Expr, error) {
-	exprs := make([]ast.Expr, 0)
-	e, err := p.basicExpr()
-	if err != nil {
-		return nil, err
-	}
-	exprs = append(exprs, e)
-	for {
-		if p.matchBy(isBlankSpace) {
-			p.blankSpace()
-		}
-		initial := p.Index
-		if err := p.and(); err != nil {
-			p.Index = initial
-			break
+// logicalExprAnd parses a logical-and-expr whose first basic-expr, first, is already parsed.
+func (p *Parser) logicalExprAnd(first ast.Expr) (ast.Expr, error) {
+	exprs := []ast.Expr{first}
+	for p.tok.kind == tokenAnd {
+		if err := p.advance(); err != nil {
+			return nil, err
 		}
-		p.blankSpace()
 		e, err := p.basicExpr()
 		if err != nil {
 			return nil, err
@@ -547,501 +481,339 @@ func (p *Parser) logicalExprAnd() (ast.Expr, error) {
 	}, nil
 }
 
+// basicExpr parses a paren-expr, comparison-expr or test-expr.
+//
+// Comparisons and tests share a common prefix, so the operand is parsed first
+// and the token following it decides between the two.
 func (p *Parser) basicExpr() (ast.Expr, error) {
-	initial := p.Index
-	e, err := p.parenExpr()
-	if err == nil {
-		return e, nil
-	}
-	p.Index = initial
-	e, err = p.comparisonExpr()
-	if err == nil {
-		return e, nil
+	switch p.tok.kind {
+	case tokenParenOpen:
+		return p.parenExpr()
+	case tokenNot:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		var e ast.Expr
+		var err error
+		if p.tok.kind == tokenParenOpen {
+			e, err = p.parenExpr()
+		} else {
+			e, err = p.testExpr()
+		}
+		if err != nil {
+			return nil, err
+		}
+		return ast.ExprLogicalNot{Expr: e}, nil
+	default:
+		start := p.tok.start
+		e, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return p.comparisonOrTestExpr(e, start)
 	}
-	p.Index = initial
-	return p.testExpr()
 }
 
 func (p *Parser) parenExpr() (ast.Expr, error) {
-	isNegated := false
-	if p.not() == nil {
-		isNegated = true
-	}
-	p.blankSpace()
-	if err := p.expect(grammar.ParenthesisOpen); err != nil {
+	if err := p.expect(tokenParenOpen); err != nil {
 		return nil, err
 	}
-	p.blankSpace()
 	e, err := p.logicalExpr()
 	if err != nil {
 		return nil, err
 	}
-	p.blankSpace()
-	if err := p.expect(grammar.ParenthesisClose); err != nil {
+	if err := p.expect(tokenParenClose); err != nil {
 		return nil, err
 	}
-	var expr ast.Expr = ast.ExprParen{Expr: e}
-	if isNegated {
-		expr = ast.ExprLogicalNot{Expr: expr}
-	}
-	return expr, nil
+	return ast.ExprParen{Expr: e}, nil
 }
 
-func (p *Parser) not() error {
-	return p.expect(grammar.Bang)
-}
-
-func (p *Parser) and() error {
-	for _, r := range grammar.And {
-		if err := p.expect(r); err != nil {
-			return err
-		}
+func (p *Parser) testExpr() (ast.Expr, error) {
+	start := p.tok.start
+	e, err := p.operand()
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return p.test(e, start)
 }
 
-func (p *Parser) or() error {
-	for _, r := range grammar.Or {
-		if err := p.expect(r); err != nil {
-			return err
-		}
+// comparisonOrTestExpr parses the rest of a comparison-expr if the operand e,
+// found at index start, is followed by a comparison operator.
+// Otherwise, e must be a valid test-expr.
+func (p *Parser) comparisonOrTestExpr(e ast.Expr, start int) (ast.Expr, error) {
+	op, ok := comparisonOp(p.tok.kind)
+	if !ok {
+		return p.test(e, start)
 	}
-	return nil
-}
-
-func (p *Parser) comparisonExpr() (ast.Expr, error) {
-	left, err := p.comparable()
+	left, err := p.comparable(e, start)
 	if err != nil {
 		return nil, err
 	}
-	p.blankSpace()
-	f, err := p.comparisonOp()
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	start = p.tok.start
+	e, err = p.operand()
 	if err != nil {
 		return nil, err
 	}
-	p.blankSpace()
-	right, err := p.comparable()
+	right, err := p.comparable(e, start)
 	if err != nil {
 		return nil, err
 	}
 	return ast.ExprComparison{
 		Left:  left,
 		Right: right,
-		F:     f,
+		Op:    op,
 	}, nil
 }
 
-func (p *Parser) comparable() (ast.ExprSingle, error) {
-	if v, err := p.literal(); err == nil {
-		return ast.Literal{Value: v}, nil
-	} else if e, err := p.querySingular(); err == nil {
-		return e, nil
-	} else if f, err := p.functionExpr(); err == nil {
-		f, ok := f.(ast.ExprSingle)
-		if !ok {
-			return nil, errors.New("function does not implemented ast.ExprSingle")
+// operand parses a literal, filter query or function expression.
+func (p *Parser) operand() (ast.Expr, error) {
+	switch p.tok.kind {
+	case tokenNumber:
+		return p.literalNumber()
+	case tokenString:
+		s := p.tok.value
+		if err := p.advance(); err != nil {
+			return nil, err
 		}
-		return f, nil
-	} else {
-		return nil, p.errorUnsupportedCodepoint()
-	}
-}
-
-func (p *Parser) literal() (ast.Value, error) {
-	if n, err := p.literalNumber(); err == nil {
-		return n, nil
-	} else if s, err := p.literalString(); err == nil {
-		return s, nil
-	} else if p.literalTrue() == nil {
-		return true, nil
-	} else if p.literalFalse() == nil {
-		return false, nil
-	} else if p.literalNull() == nil {
-		return nil, nil
-	} else {
-		return nil, p.errorUnsupportedCodepoint()
-	}
-}
-
-func (p *Parser) literalNumber() (float64, error) {
-	f := float64(0)
-	if n, err := p.int(); err == nil {
-		f = float64(n)
-	} else if err := p.negativeZero(); err == nil {
-		f = -0
-	} else {
-		return 0, p.errorUnsupportedCodepoint()
-	}
-	if frac, err := p.frac(); err == nil {
-		f = f + (float64(frac) / 10)
-	}
-	if exp, err := p.exp(); err != nil {
-		f = f * math.Pow10(exp)
-	}
-	return f, nil
-}
-
-func (p *Parser) negativeZero() error {
-	if err := p.expect(grammar.Minus); err != nil {
-		return err
-	}
-	return p.expect('0')
-}
-
-func (p *Parser) frac() (int, error) {
-	if err := p.expect(grammar.Dot); err != nil {
-		return 0, err
-	}
-	if err := p.expectBy(isDigit); err != nil {
-		return 0, err
-	}
-	n := int(p.Codepoints[p.Index-1] - '0')
-	for p.expectBy(isDigit) == nil {
-		n = n*10 + int(p.Codepoints[p.Index-1]-'0')
-	}
-	return n, nil
-}
-
-func (p *Parser) exp() (int, error) {
-	if err := p.expect('e'); err != nil {
-		return 0, err
-	}
-	isNegative := false
-	if p.expect(grammar.Minus) == nil {
-		isNegative = true
-	} else if p.expect(grammar.Plus) == nil {
-	}
-	if err := p.expectBy(isDigit); err != nil {
-		return 0, err
-	}
-	n := 0
-	for p.expectBy(isDigit) == nil {
-		n = n*10 + int(p.Codepoints[p.Index]-'0')
-	}
-	if isNegative {
-		n = -n
-	}
-	return n, nil
-}
-
-func (p *Parser) literalTrue() error {
-	for _, r := range grammar.True {
-		if err := p.expect(r); err != nil {
-			return err
+		return ast.Literal{Value: s}, nil
+	case tokenCurrent:
+		return p.queryRel()
+	case tokenRoot:
+		return p.queryJSONPath()
+	case tokenName:
+		unexpected := p.errorUnexpectedToken()
+		name := p.tok.value
+		if err := p.advance(); err != nil {
+			return nil, err
 		}
-	}
-	return nil
-}
-
-func (p *Parser) literalFalse() error {
-	for _, r := range grammar.False {
-		if err := p.expect(r); err != nil {
-			return err
+		if p.tok.kind == tokenParenOpen && !p.tok.space {
+			return p.functionExpr(name)
 		}
-	}
-	return nil
-}
-
-func (p *Parser) literalNull() error {
-	for _, r := range grammar.Null {
-		if err := p.expect(r); err != nil {
-			return err
+		switch name {
+		case grammar.True:
+			return ast.Literal{Value: true}, nil
+		case grammar.False:
+			return ast.Literal{Value: false}, nil
+		case grammar.Null:
+			return ast.Literal{Value: nil}, nil
+		default:
+			return nil, unexpected
 		}
-	}
-	return nil
-}
-
-func (p *Parser) querySingular() (ast.ExprSingle, error) {
-	if e, err := p.querySingularRel(); err == nil {
-		return e, err
-	} else if e, err := p.querySingularAbs(); err == nil {
-		return e, nil
-	} else {
-		return nil, p.errorUnsupportedCodepoint()
+	default:
+		return nil, p.errorUnexpectedToken()
 	}
 }
 
-func (p *Parser) querySingularRel() (ast.ExprSingle, error) {
-	if err := p.identCurrNode(); err != nil {
-		return nil, err
-	}
-	s, err := p.querySingularSegments()
+// literalNumber parses a number literal into a float64,
+// or into an int64 or uint64 if it is an integer that a float64 cannot represent exactly.
+func (p *Parser) literalNumber() (ast.Expr, error) {
+	text := p.lexer.src[p.tok.start:p.tok.end]
+	v, err := exactNumber(text, p.tok.isInt)
 	if err != nil {
-		return nil, err
+		return nil, ErrOutOfRange{Number: text, Index: p.tok.start}
 	}
-	return ast.QuerySingularRel{
-		Segments: s,
-	}, nil
-}
-
-func (p *Parser) identCurrNode() error {
-	return p.expect(grammar.At)
-}
-
-func (p *Parser) querySingularSegments() ([]ast.ExprSingle, error) {
-	segments := make([]ast.ExprSingle, 0)
-	for {
-		if p.matchBy(isBlankSpace) {
-			p.blankSpace()
-		}
-		initial := p.Index
-		if e, err := p.segmentName(); err == nil {
-			segments = append(segments, e)
-			continue
-		}
-		p.Index = initial
-		if e, err := p.segmentIndex(); err == nil {
-			segments = append(segments, e)
-			continue
-		}
-		p.Index = initial
-		break
+	if err := p.advance(); err != nil {
+		return nil, err
 	}
-	return segments, nil
+	return ast.Literal{Value: v}, nil
 }
 
-func (p *Parser) segmentName() (ast.ExprSingle, error) {
-	if p.expect(grammar.BracketOpen) == nil {
-		s, err := p.selectorName()
-		if err != nil {
-			return nil, err
-		}
-		if err := p.expect(grammar.BracketClose); err != nil {
-			return nil, err
-		}
-		return ast.SegmentName{
-			Name: s.Name,
-		}, nil
-	} else if p.expect(grammar.Dot) == nil {
-		name, err := p.memberNameShorthand()
-		if err != nil {
-			return nil, err
+// exactNumber parses the text of a number.
+func exactNumber(text string, isInt bool) (ast.Value, error) {
+	if isInt {
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			if i < grammar.MinInt || grammar.MaxInt < i {
+				return i, nil
+			}
+		} else if u, err := strconv.ParseUint(text, 10, 64); err == nil {
+			return u, nil
 		}
-		return ast.SegmentName{
-			Name: name,
-		}, nil
-	} else {
-		return nil, p.errorUnsupportedCodepoint()
 	}
+	f, err := strconv.ParseFloat(text, 64)
+	return f, err
 }
 
-func (p *Parser) memberNameShorthand() (string, error) {
-	start := p.Index
-	if err := p.expectBy(isNameFirst); err != nil {
-		return "", err
+// comparable checks that the operand e, found at index start, is a comparable.
+// Singular queries are converted to their ast.ExprSingle form.
+func (p *Parser) comparable(e ast.Expr, start int) (ast.ExprSingle, error) {
+	e, ok := valueExpr(e)
+	if !ok {
+		return nil, ErrWrongTypeExpr{Index: start, ExpectedType: typeValue, ActualType: nonSingularQuery}
 	}
-	for p.expectBy(isNameChar) == nil {
+	s, ok := e.(ast.ExprSingle)
+	if t := typeOf(e); !ok || t != typeValue {
+		return nil, ErrWrongTypeExpr{Index: start, ExpectedType: typeValue, ActualType: t}
 	}
-	end := p.Index
-	return string(p.Codepoints[start:end]), nil
+	return s, nil
 }
 
-func (p *Parser) segmentIndex() (ast.ExprSingle, error) {
-	if err := p.expect(grammar.BracketOpen); err != nil {
-		return nil, err
-	}
-	n, err := p.int()
-	if err != nil {
-		return nil, err
-	}
-	if err := p.expect(grammar.BracketClose); err != nil {
-		return nil, err
-	}
-	return ast.SegmentIndex{
-		Index: n,
-	}, nil
-}
-
-func (p *Parser) querySingularAbs() (ast.ExprSingle, error) {
-	if err := p.identRootNode(); err != nil {
-		return nil, err
-	}
-	s, err := p.querySingularSegments()
-	if err != nil {
-		return nil, err
+// test checks that the operand e, found at index start, is a valid test-expr.
+func (p *Parser) test(e ast.Expr, start int) (ast.Expr, error) {
+	switch t := typeOf(e); t {
+	case typeLogical, typeNodes:
+		return e, nil
+	default:
+		return nil, ErrWrongTypeExpr{Index: start, ExpectedType: typeLogical, ActualType: t}
+	}
+}
+
+func comparisonOp(kind tokenKind) (ast.ComparisonOp, bool) {
+	switch kind {
+	case tokenEq:
+		return ast.OpEQ, true
+	case tokenNe:
+		return ast.OpNE, true
+	case tokenLt:
+		return ast.OpLT, true
+	case tokenLte:
+		return ast.OpLTE, true
+	case tokenGt:
+		return ast.OpGT, true
+	case tokenGte:
+		return ast.OpGTE, true
+	default:
+		return 0, false
 	}
-	return ast.QuerySingularAbs{
-		Segments: s,
-	}, nil
 }
 
-func (p *Parser) functionExpr() (ast.Expr, error) {
-	name, err := p.functionName()
-	if err != nil {
-		return nil, err
-	}
+func (p *Parser) functionExpr(name string) (ast.Expr, error) {
 	if !isSupportedFunc(name) {
 		return nil, ErrUnsupportedFunction{Name: name}
 	}
-	if err := p.expect(grammar.ParenthesisOpen); err != nil {
+	if err := p.enter(); err != nil {
 		return nil, err
 	}
-	p.blankSpace()
-	args := make([]ast.Expr, 0)
-	if a, err := p.functionArgument(); err == nil {
-		args = append(args, a)
+	defer p.leave()
+	if err := p.expect(tokenParenOpen); err != nil {
+		return nil, err
+	}
+	args := make([]ast.Expr, 0, 2)
+	if p.tok.kind != tokenParenClose {
 		for {
-			if p.matchBy(isBlankSpace) {
-				p.blankSpace()
-			}
-			if err := p.expect(grammar.Comma); err != nil {
-				break
-			}
-			p.blankSpace()
 			a, err := p.functionArgument()
 			if err != nil {
 				return nil, err
 			}
 			args = append(args, a)
+			if p.tok.kind != tokenComma {
+				break
+			}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
 		}
 	}
-	p.blankSpace()
-	if err := p.expect(grammar.ParenthesisClose); err != nil {
+	if err := p.expect(tokenParenClose); err != nil {
 		return nil, err
 	}
 	return generateFunc(name, args)
 }
 
-func (p *Parser) functionName() (string, error) {
-	start := p.Index
-	if err := p.functionNameFirst(); err != nil {
-		return "", err
-	}
-	for p.functionNameChar() == nil {
-	}
-	end := p.Index
-	return string(p.Codepoints[start:end]), nil
-}
-
-func (p *Parser) functionNameFirst() error {
-	return p.expectBy(isAlphaLowercase)
-}
-
-func (p *Parser) functionNameChar() error {
-	if p.functionNameFirst() == nil {
-		return nil
-	} else if p.expect(grammar.Underscore) == nil {
-		return nil
-	} else if p.expectBy(isDigit) == nil {
-		return nil
-	} else {
-		return p.errorUnsupportedCodepoint()
-	}
-}
-
+// functionArgument parses a literal, filter query, function expression or logical-expr.
+//
+// A bare operand is returned as is, unless it is followed by an operator
+// that makes it the start of a logical-expr.
 func (p *Parser) functionArgument() (ast.Expr, error) {
-	if l, err := p.literal(); err == nil {
-		return ast.Literal{Value: l}, nil
+	switch p.tok.kind {
+	case tokenParenOpen, tokenNot:
+		return p.logicalExpr()
 	}
-	if e, err := p.selectorFilter(); err == nil {
-		return e, nil
+	start := p.tok.start
+	e, err := p.operand()
+	if err != nil {
+		return nil, err
 	}
-	if e, err := p.logicalExpr(); err == nil {
+	if _, ok := comparisonOp(p.tok.kind); !ok && p.tok.kind != tokenAnd && p.tok.kind != tokenOr {
 		return e, nil
 	}
-	e, err := p.functionExpr()
+	e, err = p.comparisonOrTestExpr(e, start)
 	if err != nil {
 		return nil, err
 	}
-	return e, nil
+	return p.logicalExprOr(e)
 }
 
-func (p *Parser) queryFilter() (ast.Expr, error) {
-	if e, err := p.queryRel(); err == nil {
-		return e, nil
-	} else if e, err := p.queryJSONPath(); err == nil {
-		return e, nil
-	} else {
-		return nil, p.errorUnsupportedCodepoint()
+// exprType is the type of an expression in the type system of function extensions.
+type exprType int
+
+const (
+	typeValue exprType = iota
+	typeLogical
+	typeNodes
+)
+
+func (t exprType) String() string {
+	switch t {
+	case typeValue:
+		return "ValueType"
+	case typeLogical:
+		return "LogicalType"
+	case typeNodes:
+		return "NodesType"
+	default:
+		return "unknown"
 	}
 }
 
-func (p *Parser) queryRel() (ast.Expr, error) {
-	if err := p.identCurrNode(); err != nil {
-		return nil, err
-	}
-	s, err := p.segments()
-	if err != nil {
-		return nil, err
+// nonSingularQuery is the actual type reported when a query
+// that is not a singular query is used as a value.
+const nonSingularQuery = "non-singular query"
+
+// typeOf returns the declared type of the expression, e.
+func typeOf(e ast.Expr) exprType {
+	switch e.(type) {
+	case ast.Literal, ast.QuerySingularRel, ast.QuerySingularAbs, ast.FuncLength, ast.FuncCount, ast.FuncValue:
+		return typeValue
+	case ast.QueryRel, ast.QueryJSONPath:
+		return typeNodes
+	default:
+		return typeLogical
 	}
-	return ast.QueryRel{
-		Segments: s,
-	}, nil
 }
 
-func (p *Parser) comparisonOp() (func(ast.Value, ast.Value) bool, error) {
-	if p.expect(grammar.Eq) == nil {
-		if err := p.expect(grammar.Eq); err != nil {
-			return nil, err
-		}
-		return ast.EQ, nil
-	} else if p.expect(grammar.Bang) == nil {
-		if err := p.expect(grammar.Eq); err != nil {
-			return nil, err
-		}
-		return ast.NE, nil
-	} else if p.expect(grammar.Lt) == nil {
-		if err := p.expect(grammar.Eq); err == nil {
-			return ast.LTE, nil
-		} else {
-			return ast.LT, nil
+// valueExpr converts queries in e to singular queries, so that they can be used as values.
+// It returns false if e is a query that is not singular.
+func valueExpr(e ast.Expr) (ast.Expr, bool) {
+	switch e := e.(type) {
+	case ast.QueryRel:
+		segments, ok := singularSegments(e.Segments)
+		if !ok {
+			return nil, false
 		}
-	} else if p.expect(grammar.Gt) == nil {
-		if err := p.expect(grammar.Eq); err == nil {
-			return ast.GTE, nil
-		} else {
-			return ast.GT, nil
+		return ast.QuerySingularRel{Segments: segments}, true
+	case ast.QueryJSONPath:
+		segments, ok := singularSegments(e.Segments)
+		if !ok {
+			return nil, false
 		}
-	} else {
-		return nil, p.errorUnsupportedCodepoint()
+		return ast.QuerySingularAbs{Segments: segments}, true
+	default:
+		return e, true
 	}
 }
 
-func (p *Parser) testExpr() (ast.Expr, error) {
-	isNegated := false
-	if p.expect(grammar.Bang) == nil {
-		isNegated = true
-		p.blankSpace()
-	}
-	if e, err := p.queryFilter(); err == nil {
-		if isNegated {
-			e = ast.ExprLogicalNot{Expr: e}
+// singularSegments converts the segments of a query into singular query segments.
+// A query is singular if every segment is a child segment with a single name or index selector.
+func singularSegments(segments []ast.Expr) ([]ast.ExprSingle, bool) {
+	singular := make([]ast.ExprSingle, 0, len(segments))
+	for _, s := range segments {
+		c, ok := s.(ast.SegmentChild)
+		if !ok || len(c.Selectors) != 1 {
+			return nil, false
 		}
-		return e, nil
-	}
-	f, err := p.functionExpr()
-	if err != nil {
-		return nil, err
-	}
-	if isNegated {
-		f = ast.ExprLogicalNot{Expr: f}
-	}
-	return f, nil
-}
-
-func (p *Parser) segmentDescendant() (ast.Expr, error) {
-	for _, r := range grammar.DescendantPrefix {
-		if err := p.expect(r); err != nil {
-			return nil, err
+		switch selector := c.Selectors[0].(type) {
+		case ast.SelectorName:
+			singular = append(singular, ast.SegmentName{Name: selector.Name})
+		case ast.SelectorIndex:
+			singular = append(singular, ast.SegmentIndex{Index: selector.Index})
+		default:
+			return nil, false
 		}
 	}
-	if s, err := p.bracketedSelection(); err == nil {
-		return ast.SegmentDescendant{
-			Selectors: s,
-		}, nil
-	} else if s, err := p.selectorWildcard(); err == nil {
-		return ast.SegmentDescendant{
-			Selectors: []ast.Expr{s},
-		}, nil
-	} else if n, err := p.memberNameShorthand(); err == nil {
-		return ast.SegmentDescendant{
-			Selectors: []ast.Expr{ast.SelectorName{
-				Name: n,
-			}},
-		}, nil
-	} else {
-		return nil, p.errorUnsupportedCodepoint()
-	}
+	return singular, true
 }
 
 func isBlankSpace(r rune) bool {
@@ -1077,14 +849,6 @@ func isDigit(r rune) bool {
 	return '0' <= r && r <= '9'
 }
 
-func isDigit1(r rune) bool {
-	return '1' <= r && r <= '9'
-}
-
-func isDigit0To7(r rune) bool {
-	return '0' <= r && r <= '7'
-}
-
 func isAlphaUppercase(r rune) bool {
 	return 'A' <= r && r <= 'Z'
 }
@@ -1108,23 +872,6 @@ func isNameChar(r rune) bool {
 	return isNameFirst(r) || isDigit(r)
 }
 
-func isHexDig(r rune) bool {
-	return isDigit(r) || ('A' <= r && r <= 'F')
-}
-
-func isValidFunc(name string) bool {
-	switch name {
-	case grammar.FuncLength,
-		grammar.FuncCount,
-		grammar.FuncMatch,
-		grammar.FuncSearch,
-		grammar.FuncValue:
-		return true
-	default:
-		return false
-	}
-}
-
 func isSupportedFunc(name string) bool {
 	switch name {
 	case grammar.FuncLength, grammar.FuncCount, grammar.FuncMatch, grammar.FuncSearch, grammar.FuncValue:
@@ -1149,7 +896,7 @@ type ErrWrongArgTypeFunction struct {
 
 func (e ErrWrongArgTypeFunction) Error() string {
 	return fmt.Sprintf(
-		"invalid type at %s($%d); expected:%T; actual:%T",
+		"invalid type at %s($%d); expected:%v; actual:%v",
 		e.Name, e.Index, e.ExpectedType, e.ActualType)
 }
 
@@ -1181,57 +928,90 @@ func (e ErrUnsupportedFunction) Error() string {
 	return fmt.Sprintf("unsupported function:%v", e.Name)
 }
 
+// valueArg checks that the i-th argument of function, name, is of ValueType.
+func valueArg(name string, i int, arg ast.Expr) (ast.Expr, error) {
+	e, ok := valueExpr(arg)
+	if !ok {
+		return nil, ErrWrongArgTypeFunction{Name: name, Index: i, ExpectedType: typeValue, ActualType: nonSingularQuery}
+	}
+	if t := typeOf(e); t != typeValue {
+		return nil, ErrWrongArgTypeFunction{Name: name, Index: i, ExpectedType: typeValue, ActualType: t}
+	}
+	return e, nil
+}
+
+// nodesArg checks that the i-th argument of function, name, is of NodesType.
+// Functions of ValueType are also accepted, and are treated as a nodelist of their result.
+func nodesArg(name string, i int, arg ast.Expr) (ast.Expr, error) {
+	switch t := typeOf(arg); {
+	case t == typeNodes:
+		return arg, nil
+	case t == typeValue && !isLiteral(arg):
+		return arg, nil
+	default:
+		return nil, ErrWrongArgTypeFunction{Name: name, Index: i, ExpectedType: typeNodes, ActualType: t}
+	}
+}
+
+func isLiteral(e ast.Expr) bool {
+	_, ok := e.(ast.Literal)
+	return ok
+}
+
 func generateFunc(name string, args []ast.Expr) (ast.Expr, error) {
 	switch name {
 	case grammar.FuncLength:
 		if len(args) != 1 {
 			return nil, ErrWrongArgsCountFunction{Name: name, Expected: 1, Actual: len(args)}
 		}
-		return ast.FuncLength{}, nil
+		arg, err := valueArg(name, 0, args[0])
+		if err != nil {
+			return nil, err
+		}
+		return ast.FuncLength{Expr: arg}, nil
 	case grammar.FuncCount:
 		if len(args) != 1 {
 			return nil, ErrWrongArgsCountFunction{Name: name, Expected: 1, Actual: len(args)}
 		}
-		return ast.FuncCount{}, nil
-	case grammar.FuncMatch:
-		if len(args) != 2 {
-			return nil, ErrWrongArgsCountFunction{Name: name, Expected: 1, Actual: len(args)}
-		}
-		literal, ok := args[1].(ast.Literal)
-		if !ok {
-			return nil, ErrWrongArgTypeFunction{Name: name, Index: 1, ExpectedType: ast.Literal{Value: ""}, ActualType: args[1]}
-		}
-		s, ok := literal.Value.(string)
-		if !ok {
-			return nil, ErrWrongArgTypeFunction{Name: name, Index: 1, ExpectedType: "", ActualType: args[1]}
-		}
-		rg, err := regexp.Compile(s)
+		arg, err := nodesArg(name, 0, args[0])
 		if err != nil {
 			return nil, err
 		}
-		return ast.FuncMatch{Regex: rg}, nil
-	case grammar.FuncSearch:
+		return ast.FuncCount{Expr: arg}, nil
+	case grammar.FuncMatch, grammar.FuncSearch:
 		if len(args) != 2 {
-			return nil, ErrWrongArgsCountFunction{Name: name, Expected: 1, Actual: len(args)}
-		}
-		literal, ok := args[1].(ast.Literal)
-		if !ok {
-			return nil, ErrWrongArgTypeFunction{Name: name, Index: 1, ExpectedType: ast.Literal{Value: ""}, ActualType: args[1]}
+			return nil, ErrWrongArgsCountFunction{Name: name, Expected: 2, Actual: len(args)}
 		}
-		s, ok := literal.Value.(string)
-		if !ok {
-			return nil, ErrWrongArgTypeFunction{Name: name, Index: 1, ExpectedType: "", ActualType: args[1]}
+		arg, err := valueArg(name, 0, args[0])
+		if err != nil {
+			return nil, err
 		}
-		rg, err := regexp.Compile(s)
+		pattern, err := valueArg(name, 1, args[1])
 		if err != nil {
 			return nil, err
 		}
-		return ast.FuncSearch{Regex: rg}, nil
+		isMatch := name == grammar.FuncMatch
+		// Patterns known at parse time are compiled once here.
+		// Invalid patterns are not an error, as the function then evaluates to false.
+		var rg *regexp.Regexp
+		if literal, ok := pattern.(ast.Literal); ok {
+			if s, ok := literal.Value.(string); ok {
+				rg, _ = iregexp.Compile(s, isMatch)
+			}
+		}
+		if isMatch {
+			return ast.FuncMatch{Expr: arg, Pattern: pattern, Regex: rg}, nil
+		}
+		return ast.FuncSearch{Expr: arg, Pattern: pattern, Regex: rg}, nil
 	case grammar.FuncValue:
 		if len(args) != 1 {
 			return nil, ErrWrongArgsCountFunction{Name: name, Expected: 1, Actual: len(args)}
 		}
-		return ast.FuncValue{Expr: args[0]}, nil
+		arg, err := nodesArg(name, 0, args[0])
+		if err != nil {
+			return nil, err
+		}
+		return ast.FuncValue{Expr: arg}, nil
 	default:
 		return nil, ErrUnsupportedFunction{Name: name}
 	}
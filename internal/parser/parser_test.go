@@ -2,8 +2,10 @@ package parser_test
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
+	"github.com/marcfyk/go-jsonpath/internal/ast"
 	"github.com/marcfyk/go-jsonpath/internal/parser"
 	"github.com/stretchr/testify/assert"
 )
@@ -175,3 +177,210 @@ func TestFunctionExtensions(t *testing.T) {
 		})
 	}
 }
+
+func TestBlankSpace(t *testing.T) {
+	paths := []string{
+		"$ .a",
+		"$[ 1 , 2 ]",
+		"$[ 1 : 2 : 1 ]",
+		"$[?@.a==1]",
+		"$[? @.a == 1 && @.b != 2 || ! (@.c < 3) ]",
+		"$[?length( @.a ) >= 1]",
+		"$[?!@.a]",
+		"$[?! @.a]",
+		"$ ..a",
+	}
+	for _, path := range paths {
+		t.Run(path, func(t *testing.T) {
+			p := parser.New(path)
+			a, err := p.Parse()
+			assert.Nil(t, err)
+			assert.NotNil(t, a)
+			assert.True(t, p.IsDone(), "cursor:%d", p.Index)
+		})
+	}
+}
+
+func TestInvalidQueries(t *testing.T) {
+	paths := []string{
+		"",
+		" $",
+		"$ ",
+		"@",
+		"$.",
+		"$. a",
+		"$.. a",
+		"$..",
+		"$.1",
+		"$[",
+		"$[]",
+		"$[1",
+		"$[01]",
+		"$[-0]",
+		"$[1.0]",
+		"$[9007199254740992]",
+		"$['a'",
+		"$['\\'']x",
+		"$[\"\\'\"]",
+		"$['\\q']",
+		"$['\\uDC00']",
+		"$['\\uD800']",
+		"$['\x01']",
+		"$[?@.a = 1]",
+		"$[?@.a & @.b]",
+		"$[?1]",
+		"$[?'a']",
+		"$[?true]",
+		"$[?@.* == 1]",
+		"$[?@..a == 1]",
+		"$[?@['a','b'] == 1]",
+		"$[?length(@.*) == 1]",
+		"$[?length(@)]",
+		"$[?count(1) == 1]",
+		"$[?value(1) == 1]",
+		"$[?match(@.a)]",
+		"$[?match(@.a, 'a') == true]",
+		"$[?length (@) == 1]",
+		"$[?foo(@) == 1]",
+		"$[?(@.a]",
+		"$[?@.a)]",
+	}
+	for _, path := range paths {
+		t.Run(path, func(t *testing.T) {
+			p := parser.New(path)
+			a, err := p.Parse()
+			assert.NotNil(t, err)
+			assert.Nil(t, a)
+		})
+	}
+}
+
+func TestStringLiterals(t *testing.T) {
+	cases := []struct {
+		path     string
+		expected string
+	}{
+		{`$['a']`, "a"},
+		{`$["a"]`, "a"},
+		{`$['"']`, `"`},
+		{`$["'"]`, "'"},
+		{`$['\'']`, "'"},
+		{`$["\""]`, `"`},
+		{`$['\b\f\n\r\t\/\\']`, "\b\f\n\r\t/\\"},
+		{`$['\u263A']`, "☺"},
+		{`$['\u263a']`, "☺"},
+		{`$['\uD83D\uDE00']`, "😀"},
+		{`$['☺']`, "☺"},
+		{`$.☺`, "☺"},
+	}
+	for _, c := range cases {
+		t.Run(c.path, func(t *testing.T) {
+			p := parser.New(c.path)
+			a, err := p.Parse()
+			assert.Nil(t, err)
+			assert.Equal(t, ast.QueryJSONPath{
+				Segments: []ast.Expr{
+					ast.SegmentChild{Selectors: []ast.Expr{ast.SelectorName{Name: c.expected}}},
+				},
+			}, a)
+		})
+	}
+}
+
+func TestSingularQueryComparables(t *testing.T) {
+	p := parser.New("$[?@.a[0] == $['b']]")
+	a, err := p.Parse()
+	assert.Nil(t, err)
+	filter := a.(ast.QueryJSONPath).Segments[0].(ast.SegmentChild).Selectors[0].(ast.SelectorFilter)
+	comparison := filter.Expr.(ast.ExprLogicalOr).Exprs[0].(ast.ExprLogicalAnd).Exprs[0].(ast.ExprComparison)
+	assert.Equal(t, ast.QuerySingularRel{
+		Segments: []ast.ExprSingle{ast.SegmentName{Name: "a"}, ast.SegmentIndex{Index: 0}},
+	}, comparison.Left)
+	assert.Equal(t, ast.QuerySingularAbs{
+		Segments: []ast.ExprSingle{ast.SegmentName{Name: "b"}},
+	}, comparison.Right)
+}
+
+func TestNumberLiterals(t *testing.T) {
+	cases := []struct {
+		literal  string
+		expected ast.Value
+	}{
+		{"1", 1.0},
+		{"-0", -0.0},
+		{"1.5e3", 1500.0},
+		{"9007199254740991", 9007199254740991.0},
+		{"9007199254740993", int64(9007199254740993)},
+		{"-9223372036854775808", int64(-9223372036854775808)},
+		{"18446744073709551615", uint64(18446744073709551615)},
+		{"18446744073709551616", 18446744073709551616.0},
+	}
+	for _, c := range cases {
+		t.Run(c.literal, func(t *testing.T) {
+			p := parser.New("$[?@ == " + c.literal + "]")
+			a, err := p.Parse()
+			assert.Nil(t, err)
+			filter := a.(ast.QueryJSONPath).Segments[0].(ast.SegmentChild).Selectors[0].(ast.SelectorFilter)
+			comparison := filter.Expr.(ast.ExprLogicalOr).Exprs[0].(ast.ExprLogicalAnd).Exprs[0].(ast.ExprComparison)
+			assert.Equal(t, ast.Literal{Value: c.expected}, comparison.Right)
+		})
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	cases := []struct {
+		query string
+		depth int
+		index int
+	}{
+		{"$[?" + strings.Repeat("(", 1_000_000) + "@" + strings.Repeat(")", 1_000_000) + "]", 512, 515},
+		{"$[?@[?@[?@[?@]]]]", 3, 12},
+		{"$[?count(value(length(@))) == 1]", 3, 21},
+		{"$[?!(!(!(@)))]", 3, 9},
+	}
+	for _, c := range cases {
+		t.Run(c.query[:min(len(c.query), 20)], func(t *testing.T) {
+			p := parser.New(c.query)
+			p.MaxDepth = c.depth
+			_, err := p.Parse()
+			assert.Equal(t, parser.ErrMaxDepth{Depth: c.depth, Index: c.index}, err)
+			p = parser.New(c.query)
+			p.MaxDepth = c.depth + 1
+			if c.depth < parser.DefaultMaxDepth {
+				_, err = p.Parse()
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+// filterQuery returns a query with a filter of n comparisons,
+// where every comparison is nested one level deeper than the previous one.
+func filterQuery(n int) string {
+	var b strings.Builder
+	b.WriteString("$[?")
+	for i := range n {
+		fmt.Fprintf(&b, "(@.a%d == %d || match(@.b, 'x.*') && ", i, i)
+	}
+	b.WriteString("@")
+	b.WriteString(strings.Repeat(")", n))
+	b.WriteString("]")
+	return b.String()
+}
+
+func BenchmarkParse(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		query := filterQuery(n)
+		b.Run(fmt.Sprintf("clauses=%d", n), func(b *testing.B) {
+			b.SetBytes(int64(len(query)))
+			b.ReportAllocs()
+			for range b.N {
+				p := parser.New(query)
+				p.MaxDepth = 0
+				if _, err := p.Parse(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
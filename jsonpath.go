@@ -1 +1,203 @@
+// Package jsonpath implements JSONPath queries as specified by RFC9535.
+//
+// A query is compiled once with Compile, and can then be evaluated against
+// any number of documents. Documents are the values produced by
+// encoding/json when unmarshalling into an any, or any other Go values
+// that encoding/json can marshal, see Value.
 package jsonpath
+
+import (
+	"fmt"
+
+	"github.com/marcfyk/go-jsonpath/internal/ast"
+	"github.com/marcfyk/go-jsonpath/internal/eval"
+	"github.com/marcfyk/go-jsonpath/internal/optimizer"
+	"github.com/marcfyk/go-jsonpath/internal/parser"
+)
+
+// Value is a value in a JSON document.
+//
+// numbers | text strings | null | true | false | JSON objects   | arrays
+//
+// float64 | string       | nil  | true | false | map[string]any | []any
+//
+// Objects decoded by Decode keep the order of their members, and are selected in that order.
+// Values implementing Document are read through its methods, which plugs in trees of any kind.
+// Other Go values are read as the JSON values encoding/json marshals them to, without marshalling them:
+//   - Structs are objects of their exported fields, which are named by their json tags.
+//     Fields tagged "-" are skipped, and fields tagged omitempty are skipped when they are empty.
+//     The fields of embedded structs are promoted, as encoding/json promotes them.
+//   - Maps are objects if their keys are strings, integers or implement encoding.TextMarshaler.
+//   - Slices and arrays are arrays, except byte slices, which are base64 encoded strings.
+//   - Integers and json.Number values are numbers, which are compared exactly rather than
+//     after rounding them to a float64, so 64-bit IDs can be matched by filters.
+//   - Pointers and interfaces are the value they point to, or null if they are nil.
+//   - Values implementing json.Marshaler or encoding.TextMarshaler are the values they marshal to.
+//
+// The values of the selected nodes are the Go values of the document, e.g. the int of a struct field.
+// Values that cannot be marshalled, e.g. channels, are neither arrays, objects nor equal to any value.
+type Value = ast.Value
+
+// Location is the position of a Value in a JSON document, as a Normalized Path, e.g. $['a'][0].
+type Location = ast.Location
+
+// Node is a Value in a JSON document along with its Location.
+type Node = ast.Node
+
+// Errors returned when compiling a query.
+type (
+	ErrUnexpectedCodepoint    = parser.ErrUnexpectedCodepoint
+	ErrUnexpectedToken        = parser.ErrUnexpectedToken
+	ErrOutOfRange             = parser.ErrOutOfRange
+	ErrWrongTypeExpr          = parser.ErrWrongTypeExpr
+	ErrWrongArgTypeFunction   = parser.ErrWrongArgTypeFunction
+	ErrWrongArgsCountFunction = parser.ErrWrongArgsCountFunction
+	ErrUnsupportedFunction    = parser.ErrUnsupportedFunction
+	ErrMaxParseDepth          = parser.ErrMaxDepth
+)
+
+// Errors returned when evaluating a query.
+type (
+	ErrMaxEvalDepth  = eval.ErrMaxDepth
+	ErrLimitExceeded = eval.ErrLimitExceeded
+	ErrCycle         = eval.ErrCycle
+)
+
+// Limit names the resource limit in an ErrLimitExceeded.
+type Limit = eval.Limit
+
+// Resource limits of an evaluation.
+const (
+	LimitResults     = eval.LimitResults
+	LimitVisited     = eval.LimitVisited
+	LimitRegexpBytes = eval.LimitRegexpBytes
+	LimitBytes       = eval.LimitBytes
+)
+
+// Query is a compiled jsonpath query.
+//
+// A Query is safe for concurrent use by multiple goroutines.
+type Query struct {
+	// query is the jsonpath string the Query was compiled from.
+	query string
+	// program is the compiled form of the query.
+	program *eval.Program
+}
+
+// Compile parses a jsonpath string and compiles it into a Query,
+// which is evaluated according to the given options.
+func Compile(query string, options ...Option) (*Query, error) {
+	c := newConfig(options)
+	tree, err := c.parse(query)
+	if err != nil {
+		return nil, err
+	}
+	program, err := c.compile(tree)
+	if err != nil {
+		return nil, err
+	}
+	return &Query{
+		query:   query,
+		program: program,
+	}, nil
+}
+
+// parse parses a jsonpath string into its tree.
+func (c config) parse(query string) (ast.QueryJSONPath, error) {
+	p := parser.New(query)
+	p.MaxDepth = c.maxParseDepth
+	e, err := p.Parse()
+	if err != nil {
+		return ast.QueryJSONPath{}, err
+	}
+	q, ok := e.(ast.QueryJSONPath)
+	if !ok {
+		return ast.QueryJSONPath{}, fmt.Errorf("parsed query is not a jsonpath query:%T", e)
+	}
+	return q, nil
+}
+
+// compile optimizes and compiles the tree of a query.
+func (c config) compile(tree ast.QueryJSONPath) (*eval.Program, error) {
+	return eval.Compile(optimizer.Optimize(tree), c.eval)
+}
+
+// MustCompile is like Compile but panics if the query cannot be compiled.
+func MustCompile(query string, options ...Option) *Query {
+	q, err := Compile(query, options...)
+	if err != nil {
+		panic(fmt.Sprintf("jsonpath: Compile(%q): %v", query, err))
+	}
+	return q
+}
+
+// String returns the jsonpath string the Query was compiled from.
+func (q *Query) String() string {
+	return q.query
+}
+
+// IsSingular reports if the Query is a singular query, which selects at most one node.
+func (q *Query) IsSingular() bool {
+	return q.program.IsSingular()
+}
+
+// Select returns the nodes selected by the Query from doc.
+//
+// Array elements are selected in order, while object members are selected in the order of their object,
+// or in the iteration order of maps, which is unspecified unless the Query is compiled WithSortedKeys.
+//
+// doc may be an IndexedDocument, which descendant segments are evaluated against
+// without walking the document.
+//
+// Select returns an error if the evaluation exceeds a limit set by the options of the Query,
+// i.e. ErrMaxEvalDepth or ErrLimitExceeded, or ErrCycle if a descendant segment visits
+// a value that is its own descendant, e.g. a struct that points to itself.
+func (q *Query) Select(doc Value) ([]Node, error) {
+	if d, ok := doc.(*IndexedDocument); ok {
+		return q.program.SelectIndex(d.index)
+	}
+	return q.program.Select(doc)
+}
+
+// SelectValues returns the values of the nodes selected by the Query from doc,
+// in the same order as Select.
+//
+// SelectValues skips tracking the location of every node, so it allocates less
+// than Select when the locations are not needed.
+func (q *Query) SelectValues(doc Value) ([]Value, error) {
+	if d, ok := doc.(*IndexedDocument); ok {
+		return q.program.SelectValuesIndex(d.index)
+	}
+	return q.program.SelectValues(doc)
+}
+
+// IndexedDocument is a document prepared by Index for evaluating many queries against.
+//
+// An IndexedDocument is safe for concurrent use by multiple goroutines,
+// as long as the document it indexes is not modified.
+type IndexedDocument struct {
+	index *eval.Index
+}
+
+// Index builds an IndexedDocument of doc, which queries are evaluated against by passing it
+// to Select and SelectValues in place of doc.
+//
+// Indexing records the location of every node and the members of every object by name,
+// so descendant segments, e.g. $..name and $..[?@.id==1], become lookups and loops
+// rather than walks of the document. Building the index walks the document once,
+// which pays off when several queries with descendant segments are evaluated against it.
+//
+// Descendant segments select the members of an object in the same order for every query.
+// Index returns ErrCycle if a value of doc is its own descendant.
+func Index(doc Value) (*IndexedDocument, error) {
+	ix, err := eval.NewIndex(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &IndexedDocument{index: ix}, nil
+}
+
+// Value returns the document that was indexed.
+func (d *IndexedDocument) Value() Value {
+	return d.index.Root()
+}
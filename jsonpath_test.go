@@ -0,0 +1,741 @@
+package jsonpath_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/marcfyk/go-jsonpath"
+	"github.com/stretchr/testify/assert"
+)
+
+func decode(t testing.TB, s string) jsonpath.Value {
+	var v jsonpath.Value
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+const bookstore = `{
+	"store": {
+		"book": [
+			{"category": "reference", "author": "Nigel Rees", "title": "Sayings of the Century", "price": 8.95},
+			{"category": "fiction", "author": "Evelyn Waugh", "title": "Sword of Honour", "price": 12.99},
+			{"category": "fiction", "author": "Herman Melville", "title": "Moby Dick", "isbn": "0-553-21311-3", "price": 8.99},
+			{"category": "fiction", "author": "J. R. R. Tolkien", "title": "The Lord of the Rings", "isbn": "0-395-19395-8", "price": 22.99}
+		],
+		"bicycle": {"color": "red", "price": 399}
+	}
+}`
+
+type testCase struct {
+	query    string
+	expected []jsonpath.Location
+	// ordered reports if the nodes must be selected in the order of expected.
+	ordered bool
+}
+
+// runTestCases runs the cases against doc, both as is and indexed.
+func runTestCases(t *testing.T, doc string, cases []testCase) {
+	v := decode(t, doc)
+	docs := []jsonpath.Value{v, index(t, v)}
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			q, err := jsonpath.Compile(c.query)
+			assert.Nil(t, err)
+			if c.expected == nil {
+				c.expected = []jsonpath.Location{}
+			}
+			for _, doc := range docs {
+				locations := locationsOf(selectNodes(t, q, doc))
+				if c.ordered {
+					assert.Equal(t, c.expected, locations)
+				} else {
+					assert.ElementsMatch(t, c.expected, locations)
+				}
+			}
+		})
+	}
+}
+
+// index returns the IndexedDocument of doc, failing the test if it cannot be indexed.
+func index(t testing.TB, doc jsonpath.Value) *jsonpath.IndexedDocument {
+	indexed, err := jsonpath.Index(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return indexed
+}
+
+// selectNodes returns the nodes q selects from doc, failing the test if the evaluation fails.
+func selectNodes(t testing.TB, q *jsonpath.Query, doc jsonpath.Value) []jsonpath.Node {
+	nodes, err := q.Select(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nodes
+}
+
+// selectValues returns the values q selects from doc, failing the test if the evaluation fails.
+func selectValues(t testing.TB, q *jsonpath.Query, doc jsonpath.Value) []jsonpath.Value {
+	values, err := q.SelectValues(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+func locationsOf(nodes []jsonpath.Node) []jsonpath.Location {
+	locations := make([]jsonpath.Location, len(nodes))
+	for i, n := range nodes {
+		locations[i] = n.Location
+	}
+	return locations
+}
+
+func TestBookstore(t *testing.T) {
+	runTestCases(t, bookstore, []testCase{
+		{
+			query: "$.store.book[*].author",
+			expected: []jsonpath.Location{
+				"$['store']['book'][0]['author']",
+				"$['store']['book'][1]['author']",
+				"$['store']['book'][2]['author']",
+				"$['store']['book'][3]['author']",
+			},
+			ordered: true,
+		},
+		{
+			query: "$..author",
+			expected: []jsonpath.Location{
+				"$['store']['book'][0]['author']",
+				"$['store']['book'][1]['author']",
+				"$['store']['book'][2]['author']",
+				"$['store']['book'][3]['author']",
+			},
+			ordered: true,
+		},
+		{
+			query:    "$.store.*",
+			expected: []jsonpath.Location{"$['store']['book']", "$['store']['bicycle']"},
+		},
+		{
+			query: "$.store..price",
+			expected: []jsonpath.Location{
+				"$['store']['book'][0]['price']",
+				"$['store']['book'][1]['price']",
+				"$['store']['book'][2]['price']",
+				"$['store']['book'][3]['price']",
+				"$['store']['bicycle']['price']",
+			},
+		},
+		{
+			query:    "$..book[2]",
+			expected: []jsonpath.Location{"$['store']['book'][2]"},
+		},
+		{
+			query:    "$..book[-1]",
+			expected: []jsonpath.Location{"$['store']['book'][3]"},
+		},
+		{
+			query:    "$..book[0,1]",
+			expected: []jsonpath.Location{"$['store']['book'][0]", "$['store']['book'][1]"},
+			ordered:  true,
+		},
+		{
+			query:    "$..book[:2]",
+			expected: []jsonpath.Location{"$['store']['book'][0]", "$['store']['book'][1]"},
+			ordered:  true,
+		},
+		{
+			query:    "$..book[?@.isbn]",
+			expected: []jsonpath.Location{"$['store']['book'][2]", "$['store']['book'][3]"},
+			ordered:  true,
+		},
+		{
+			query:    "$..book[?@.price<10]",
+			expected: []jsonpath.Location{"$['store']['book'][0]", "$['store']['book'][2]"},
+			ordered:  true,
+		},
+	})
+}
+
+func TestDescendantOfEverything(t *testing.T) {
+	q := jsonpath.MustCompile("$..*")
+	assert.Len(t, selectNodes(t, q, decode(t, bookstore)), 27)
+}
+
+func TestRoot(t *testing.T) {
+	q := jsonpath.MustCompile("$")
+	v := decode(t, `{"k": "v"}`)
+	assert.Equal(t, []jsonpath.Node{{Location: "$", Value: v}}, selectNodes(t, q, v))
+	assert.True(t, q.IsSingular())
+}
+
+func TestNameSelector(t *testing.T) {
+	runTestCases(t, `{"o": {"j j": {"k.k": 3}}, "'": {"@": 2}}`, []testCase{
+		{query: "$.o['j j']", expected: []jsonpath.Location{"$['o']['j j']"}},
+		{query: "$.o['j j']['k.k']", expected: []jsonpath.Location{"$['o']['j j']['k.k']"}},
+		{query: `$.o["j j"]["k.k"]`, expected: []jsonpath.Location{"$['o']['j j']['k.k']"}},
+		{query: `$["'"]["@"]`, expected: []jsonpath.Location{`$['\'']['@']`}},
+		{query: "$.absent", expected: nil},
+	})
+}
+
+func TestIndexAndSliceSelectors(t *testing.T) {
+	runTestCases(t, `["a", "b", "c", "d", "e", "f", "g"]`, []testCase{
+		{query: "$[1]", expected: []jsonpath.Location{"$[1]"}},
+		{query: "$[-2]", expected: []jsonpath.Location{"$[5]"}},
+		{query: "$[7]", expected: nil},
+		{query: "$[-8]", expected: nil},
+		{query: "$[1:3]", expected: []jsonpath.Location{"$[1]", "$[2]"}, ordered: true},
+		{query: "$[5:]", expected: []jsonpath.Location{"$[5]", "$[6]"}, ordered: true},
+		{query: "$[1:5:2]", expected: []jsonpath.Location{"$[1]", "$[3]"}, ordered: true},
+		{query: "$[5:1:-2]", expected: []jsonpath.Location{"$[5]", "$[3]"}, ordered: true},
+		{
+			query:    "$[::-1]",
+			expected: []jsonpath.Location{"$[6]", "$[5]", "$[4]", "$[3]", "$[2]", "$[1]", "$[0]"},
+			ordered:  true,
+		},
+		{query: "$[0:5:0]", expected: nil},
+		{query: "$[-100:2]", expected: []jsonpath.Location{"$[0]", "$[1]"}, ordered: true},
+	})
+}
+
+const filterDoc = `{
+	"a": [3, 5, 1, 2, 4, 6, {"b": "j"}, {"b": "k"}, {"b": {}}, {"b": "kilo"}],
+	"o": {"p": 1, "q": 2, "r": 3, "s": 5, "t": {"u": 6}},
+	"e": "f"
+}`
+
+func TestFilterSelector(t *testing.T) {
+	runTestCases(t, filterDoc, []testCase{
+		{query: "$.a[?@.b == 'kilo']", expected: []jsonpath.Location{"$['a'][9]"}},
+		{query: "$.a[?(@.b == 'kilo')]", expected: []jsonpath.Location{"$['a'][9]"}},
+		{query: "$.a[?@>3.5]", expected: []jsonpath.Location{"$['a'][1]", "$['a'][4]", "$['a'][5]"}, ordered: true},
+		{
+			query:    "$.a[?@.b]",
+			expected: []jsonpath.Location{"$['a'][6]", "$['a'][7]", "$['a'][8]", "$['a'][9]"},
+			ordered:  true,
+		},
+		{query: "$[?@.*]", expected: []jsonpath.Location{"$['a']", "$['o']"}},
+		{query: "$[?@[?@.b]]", expected: []jsonpath.Location{"$['a']"}},
+		{
+			query:    "$.o[?@<3, ?@<3]",
+			expected: []jsonpath.Location{"$['o']['p']", "$['o']['q']", "$['o']['p']", "$['o']['q']"},
+		},
+		{query: `$.a[?@<2 || @.b == "k"]`, expected: []jsonpath.Location{"$['a'][2]", "$['a'][7]"}, ordered: true},
+		{query: `$.a[?match(@.b, "[jk]")]`, expected: []jsonpath.Location{"$['a'][6]", "$['a'][7]"}, ordered: true},
+		{
+			query:    `$.a[?search(@.b, "[jk]")]`,
+			expected: []jsonpath.Location{"$['a'][6]", "$['a'][7]", "$['a'][9]"},
+			ordered:  true,
+		},
+		{query: "$.o[?@>1 && @<4]", expected: []jsonpath.Location{"$['o']['q']", "$['o']['r']"}},
+		{query: "$.o[?@.u || @.x]", expected: []jsonpath.Location{"$['o']['t']"}},
+		{
+			query: "$.a[?@.b == $.x]",
+			expected: []jsonpath.Location{
+				"$['a'][0]", "$['a'][1]", "$['a'][2]", "$['a'][3]", "$['a'][4]", "$['a'][5]",
+			},
+			ordered: true,
+		},
+		{query: "$.a[?!@.b]", expected: []jsonpath.Location{
+			"$['a'][0]", "$['a'][1]", "$['a'][2]", "$['a'][3]", "$['a'][4]", "$['a'][5]",
+		}, ordered: true},
+		{query: "$.a[?@ == @]", expected: []jsonpath.Location{
+			"$['a'][0]", "$['a'][1]", "$['a'][2]", "$['a'][3]", "$['a'][4]",
+			"$['a'][5]", "$['a'][6]", "$['a'][7]", "$['a'][8]", "$['a'][9]",
+		}, ordered: true},
+	})
+}
+
+func TestComparisons(t *testing.T) {
+	doc := decode(t, `{"obj": {"x": "y"}, "arr": [2, 3]}`)
+	cases := []struct {
+		expr     string
+		expected bool
+	}{
+		{"$.absent1 == $.absent2", true},
+		{"$.absent1 <= $.absent2", true},
+		{"$.absent == 'g'", false},
+		{"$.absent1 != $.absent2", false},
+		{"$.absent != 'g'", true},
+		{"1 <= 2", true},
+		{"1 > 2", false},
+		{"13 == '13'", false},
+		{"'a' <= 'b'", true},
+		{"'a' > 'b'", false},
+		{"$.obj == $.arr", false},
+		{"$.obj != $.arr", true},
+		{"$.obj == $.obj", true},
+		{"$.obj != $.obj", false},
+		{"$.arr == $.arr", true},
+		{"$.arr != $.arr", false},
+		{"$.obj == 17", false},
+		{"$.obj != 17", true},
+		{"$.obj <= $.arr", false},
+		{"$.obj < $.arr", false},
+		{"$.obj <= $.obj", true},
+		{"$.arr <= $.arr", true},
+		{"1 <= $.arr", false},
+		{"1 >= $.arr", false},
+		{"1 > $.arr", false},
+		{"1 < $.arr", false},
+		{"true <= true", true},
+		{"true > true", false},
+		{"null == null", true},
+		{"null == false", false},
+	}
+	for _, c := range cases {
+		t.Run(c.expr, func(t *testing.T) {
+			q := jsonpath.MustCompile(fmt.Sprintf("$[?%s]", c.expr))
+			// The comparison does not depend on the current node,
+			// so it selects either every child of the root or none.
+			assert.Equal(t, c.expected, len(selectNodes(t, q, doc)) == 2)
+		})
+	}
+}
+
+func TestDescendantSegment(t *testing.T) {
+	runTestCases(t, `{"o": {"j": 1, "k": 2}, "a": [5, 3, [{"j": 4}, {"k": 6}]]}`, []testCase{
+		{query: "$..j", expected: []jsonpath.Location{"$['o']['j']", "$['a'][2][0]['j']"}},
+		{query: "$..[0]", expected: []jsonpath.Location{"$['a'][0]", "$['a'][2][0]"}},
+		{query: "$..o", expected: []jsonpath.Location{"$['o']"}},
+		{
+			query:    "$.o..[*, *]",
+			expected: []jsonpath.Location{"$['o']['j']", "$['o']['k']", "$['o']['j']", "$['o']['k']"},
+		},
+		{
+			query:    "$.a..[0, 1]",
+			expected: []jsonpath.Location{"$['a'][0]", "$['a'][1]", "$['a'][2][0]", "$['a'][2][1]"},
+			ordered:  true,
+		},
+	})
+}
+
+func TestNull(t *testing.T) {
+	runTestCases(t, `{"a": null, "b": [null], "c": [{}], "null": 1}`, []testCase{
+		{query: "$.a", expected: []jsonpath.Location{"$['a']"}},
+		{query: "$.a[0]", expected: nil},
+		{query: "$.a.d", expected: nil},
+		{query: "$.b[0]", expected: []jsonpath.Location{"$['b'][0]"}},
+		{query: "$.b[*]", expected: []jsonpath.Location{"$['b'][0]"}},
+		{query: "$.b[?@]", expected: []jsonpath.Location{"$['b'][0]"}},
+		{query: "$.b[?@==null]", expected: []jsonpath.Location{"$['b'][0]"}},
+		{query: "$.c[?@.d==null]", expected: nil},
+		{query: "$.null", expected: []jsonpath.Location{"$['null']"}},
+	})
+}
+
+func TestFunctionExtensions(t *testing.T) {
+	runTestCases(t, `[
+		{"a": "☺☺", "b": [1, 2], "c": {"x": 1}, "d": "Europe/Paris", "e": "E.*"},
+		{"a": "abcd", "b": [], "c": {}, "d": "Asia/Tokyo", "e": "A.*"},
+		{"a": 1, "b": [[1]], "d": "Europe\nParis", "e": "["}
+	]`, []testCase{
+		{query: "$[?length(@.a) == 2]", expected: []jsonpath.Location{"$[0]"}},
+		{query: "$[?length(@.b) == 0]", expected: []jsonpath.Location{"$[1]"}},
+		{query: "$[?length(@.c) == 1]", expected: []jsonpath.Location{"$[0]"}},
+		{query: "$[?length(@.a) == 1]", expected: nil},
+		{query: "$[?count(@.*) == 5]", expected: []jsonpath.Location{"$[0]", "$[1]"}, ordered: true},
+		{query: "$[?count(@..*) == 6]", expected: []jsonpath.Location{"$[2]"}},
+		{query: "$[?match(@.d, 'Europe/.*')]", expected: []jsonpath.Location{"$[0]"}},
+		{query: "$[?match(@.d, 'Europe.*')]", expected: []jsonpath.Location{"$[0]"}},
+		{query: "$[?search(@.d, 'Paris')]", expected: []jsonpath.Location{"$[0]", "$[2]"}, ordered: true},
+		{query: "$[?match(@.d, @.e)]", expected: []jsonpath.Location{"$[0]", "$[1]"}, ordered: true},
+		{query: "$[?match(@.d, '[')]", expected: nil},
+		{query: "$[?value(@.b[*]) == 2]", expected: nil},
+		{query: "$[?value(@..x) == 1]", expected: []jsonpath.Location{"$[0]"}},
+	})
+}
+
+func TestNormalizedPathEscaping(t *testing.T) {
+	runTestCases(t, `{"'": 1, "\\": 2, "\u000b": 3, "\n": 4, "☺": 5}`, []testCase{
+		{query: "$.*", expected: []jsonpath.Location{`$['\'']`, `$['\\']`, `$['\u000b']`, `$['\n']`, `$['☺']`}},
+	})
+}
+
+func TestSelectValues(t *testing.T) {
+	doc := decode(t, bookstore)
+	queries := []string{
+		"$",
+		"$.store.bicycle.color",
+		"$.store.absent",
+		"$.store.book[*].author",
+		"$..book[?@.price < 10].title",
+		"$..book[-1:]",
+	}
+	for _, query := range queries {
+		t.Run(query, func(t *testing.T) {
+			q := jsonpath.MustCompile(query)
+			expected := []jsonpath.Value{}
+			for _, n := range selectNodes(t, q, doc) {
+				expected = append(expected, n.Value)
+			}
+			assert.Equal(t, expected, selectValues(t, q, doc))
+		})
+	}
+}
+
+func TestIndex(t *testing.T) {
+	doc := decode(t, bookstore)
+	indexed := index(t, doc)
+	assert.Equal(t, doc, indexed.Value())
+	queries := []string{
+		"$..price",
+		"$..['price', 'author']",
+		"$..*",
+		"$..[*, 0]",
+		"$..[?@.price > 10]",
+		"$.store..[?@.isbn].title",
+		"$..book[1:]",
+		"$..absent",
+		"$.store.bicycle.color",
+	}
+	for _, query := range queries {
+		t.Run(query, func(t *testing.T) {
+			q := jsonpath.MustCompile(query)
+			assert.ElementsMatch(t, selectNodes(t, q, doc), selectNodes(t, q, indexed))
+			assert.ElementsMatch(t, selectValues(t, q, doc), selectValues(t, q, indexed))
+			// Members are selected in the same order on every evaluation.
+			assert.Equal(t, selectNodes(t, q, indexed), selectNodes(t, q, indexed))
+		})
+	}
+}
+
+func TestIndexConcurrentReaders(t *testing.T) {
+	doc := logs(20)
+	indexed := index(t, doc)
+	q := jsonpath.MustCompile("$..[?@.type=='error'].type")
+	expected := selectNodes(t, q, doc)
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nodes, err := q.Select(indexed)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, nodes)
+		}()
+	}
+	wg.Wait()
+}
+
+// nested returns a document of arrays nested depth levels deep, around {"x": 1}.
+func nested(depth int) jsonpath.Value {
+	var doc jsonpath.Value = map[string]any{"x": 1.0}
+	for range depth {
+		doc = []any{doc}
+	}
+	return doc
+}
+
+func TestDeeplyNestedDocuments(t *testing.T) {
+	// Descendant segments do not recurse, so depth is bounded by memory rather than the stack.
+	q := jsonpath.MustCompile("$..x")
+	assert.Equal(t, []jsonpath.Value{1.0}, selectValues(t, q, nested(1_000_000)))
+
+	doc := nested(1000)
+	for _, d := range []jsonpath.Value{doc, index(t, doc)} {
+		assert.Len(t, selectNodes(t, q, d), 1)
+		limited := jsonpath.MustCompile("$..x", jsonpath.WithMaxEvalDepth(100))
+		_, err := limited.Select(d)
+		assert.Equal(t, jsonpath.ErrMaxEvalDepth{Depth: 100}, err)
+		_, err = limited.SelectValues(d)
+		assert.Equal(t, jsonpath.ErrMaxEvalDepth{Depth: 100}, err)
+		// The maximum applies below the node the descendant segment starts from.
+		below := jsonpath.MustCompile("$"+strings.Repeat("[0]", 950)+"..x", jsonpath.WithMaxEvalDepth(100))
+		assert.Len(t, selectNodes(t, below, d), 1)
+	}
+}
+
+type book struct {
+	Category string  `json:"category"`
+	Author   string  `json:"author"`
+	Title    string  `json:"title"`
+	ISBN     string  `json:"isbn,omitempty"`
+	Price    float32 `json:"price"`
+	Internal string  `json:"-"`
+}
+
+type store struct {
+	Books   []*book        `json:"book"`
+	Bicycle map[string]any `json:"bicycle"`
+}
+
+func TestGoValues(t *testing.T) {
+	doc := map[string]*store{"store": {
+		Books: []*book{
+			{"reference", "Nigel Rees", "Sayings of the Century", "", 8.95, "x"},
+			{"fiction", "Evelyn Waugh", "Sword of Honour", "", 12.99, "x"},
+			{"fiction", "Herman Melville", "Moby Dick", "0-553-21311-3", 8.99, "x"},
+			{"fiction", "J. R. R. Tolkien", "The Lord of the Rings", "0-395-19395-8", 22.99, "x"},
+		},
+		Bicycle: map[string]any{"color": "red", "price": 399},
+	}}
+	plain := decode(t, bookstore)
+	queries := []string{
+		"$.store.book[*].author",
+		"$..author",
+		"$.store..price",
+		"$..book[-1]",
+		"$..book[?@.isbn]",
+		"$..book[?@.price < 10].title",
+		"$..[?@.price > 20]",
+		"$..*",
+		"$..Internal",
+		"$.store.book[?@.category == $.store.book[0].category].title",
+		"$[?count(@.book[?length(@.author) > 11]) == 2]",
+	}
+	for _, query := range queries {
+		t.Run(query, func(t *testing.T) {
+			q := jsonpath.MustCompile(query)
+			// Go values select the nodes of the JSON they marshal to.
+			expected := locationsOf(selectNodes(t, q, plain))
+			assert.ElementsMatch(t, expected, locationsOf(selectNodes(t, q, doc)))
+			assert.ElementsMatch(t, expected, locationsOf(selectNodes(t, q, index(t, doc))))
+		})
+	}
+	// The values of the selected nodes are the Go values of the document.
+	q := jsonpath.MustCompile("$.store.book[?@.price < 10]")
+	assert.Equal(t, []jsonpath.Value{doc["store"].Books[0], doc["store"].Books[2]}, selectValues(t, q, doc))
+	q = jsonpath.MustCompile("$.store.book[0].price")
+	assert.Equal(t, []jsonpath.Value{float32(8.95)}, selectValues(t, q, doc))
+}
+
+type event struct {
+	ID     int64  `json:"id"`
+	Parent uint64 `json:"parent"`
+}
+
+func TestExactNumbers(t *testing.T) {
+	const events = `[
+		{"id": 1234567890123456789, "parent": 18446744073709551615},
+		{"id": 1234567890123456788, "parent": 1}
+	]`
+	d := json.NewDecoder(strings.NewReader(events))
+	d.UseNumber()
+	var numbers jsonpath.Value
+	assert.Nil(t, d.Decode(&numbers))
+	structs := []event{
+		{ID: 1234567890123456789, Parent: 18446744073709551615},
+		{ID: 1234567890123456788, Parent: 1},
+	}
+	cases := []struct {
+		query    string
+		expected []jsonpath.Location
+	}{
+		{"$[?@.id == 1234567890123456789]", []jsonpath.Location{"$[0]"}},
+		{"$[?@.id < 1234567890123456789]", []jsonpath.Location{"$[1]"}},
+		{"$[?@.parent == 18446744073709551615]", []jsonpath.Location{"$[0]"}},
+		{"$[?@.parent > 1]", []jsonpath.Location{"$[0]"}},
+		{"$[?@.id == $[0].id]", []jsonpath.Location{"$[0]"}},
+		{"$[?@.parent == 1.0]", []jsonpath.Location{"$[1]"}},
+	}
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			q := jsonpath.MustCompile(c.query)
+			assert.Equal(t, c.expected, locationsOf(selectNodes(t, q, numbers)))
+			assert.Equal(t, c.expected, locationsOf(selectNodes(t, q, structs)))
+		})
+	}
+}
+
+type link struct {
+	Name string `json:"name"`
+	Next *link  `json:"next"`
+}
+
+func TestCycles(t *testing.T) {
+	a := &link{Name: "a"}
+	a.Next = &link{Name: "b", Next: a}
+	// Queries without descendant segments never visit the cycle more than they are asked to.
+	q := jsonpath.MustCompile("$.next.next.next.name")
+	assert.Equal(t, []jsonpath.Value{"b"}, selectValues(t, q, a))
+
+	q = jsonpath.MustCompile("$..name")
+	_, err := q.Select(a)
+	assert.Equal(t, jsonpath.ErrCycle{Type: reflect.TypeOf(a)}, err)
+	_, err = q.SelectValues(a)
+	assert.Equal(t, jsonpath.ErrCycle{Type: reflect.TypeOf(a)}, err)
+	_, err = jsonpath.Index(a)
+	assert.Equal(t, jsonpath.ErrCycle{Type: reflect.TypeOf(a)}, err)
+
+	m := map[string]any{}
+	m["self"] = []any{m}
+	_, err = q.Select(m)
+	assert.IsType(t, jsonpath.ErrCycle{}, err)
+
+	// Cycles are found when descendants are visited concurrently.
+	m = map[string]any{}
+	m["a"], m["b"] = m, m
+	for _, workers := range []int{1, 2, 8} {
+		q := jsonpath.MustCompile("$..x", jsonpath.WithParallelDescendants(2), jsonpath.WithWorkers(workers))
+		_, err = q.Select(m)
+		assert.IsType(t, jsonpath.ErrCycle{}, err)
+		_, err = q.SelectValues(m)
+		assert.IsType(t, jsonpath.ErrCycle{}, err)
+	}
+
+	// Comparisons of values with cycles terminate.
+	q = jsonpath.MustCompile("$[?@.next == $[0]]")
+	assert.Len(t, selectNodes(t, q, []any{a, a.Next}), 1)
+}
+
+func TestMaxParseDepth(t *testing.T) {
+	query := "$[?" + strings.Repeat("(", 1000) + "@" + strings.Repeat(")", 1000) + "]"
+	_, err := jsonpath.Compile(query)
+	assert.IsType(t, jsonpath.ErrMaxParseDepth{}, err)
+	_, err = jsonpath.Compile(query, jsonpath.WithMaxParseDepth(2000))
+	assert.Nil(t, err)
+	_, err = jsonpath.Compile("$[?@[?@]]", jsonpath.WithMaxParseDepth(1))
+	assert.Equal(t, jsonpath.ErrMaxParseDepth{Depth: 1, Index: 6}, err)
+}
+
+func TestLimits(t *testing.T) {
+	doc := decode(t, bookstore)
+	cases := []struct {
+		query    string
+		option   jsonpath.Option
+		expected error
+	}{
+		{"$..*", jsonpath.WithMaxResults(27), nil},
+		{"$..*", jsonpath.WithMaxResults(26), jsonpath.ErrLimitExceeded{Limit: jsonpath.LimitResults, Max: 26}},
+		{"$.store.book[*]", jsonpath.WithMaxResults(3), jsonpath.ErrLimitExceeded{Limit: jsonpath.LimitResults, Max: 3}},
+		// The descendant segment visits 28 nodes, and the wildcard visits the 27 children among them.
+		{"$..*", jsonpath.WithMaxVisited(55), nil},
+		{"$..*", jsonpath.WithMaxVisited(54), jsonpath.ErrLimitExceeded{Limit: jsonpath.LimitVisited, Max: 54}},
+		{"$.store.book[?@.price < 10]", jsonpath.WithMaxVisited(5), nil},
+		{"$.store.book[?@.price < 10]", jsonpath.WithMaxVisited(4), jsonpath.ErrLimitExceeded{Limit: jsonpath.LimitVisited, Max: 4}},
+		{"$..*", jsonpath.WithMaxBytes(1 << 20), nil},
+		{"$..*", jsonpath.WithMaxBytes(1 << 10), jsonpath.ErrLimitExceeded{Limit: jsonpath.LimitBytes, Max: 1 << 10}},
+		{"$.store.book[?match(@.title, @.author)]", jsonpath.WithMaxRegexpBytes(100), nil},
+		{"$.store.book[?match(@.title, @.author)]", jsonpath.WithMaxRegexpBytes(20), jsonpath.ErrLimitExceeded{Limit: jsonpath.LimitRegexpBytes, Max: 20}},
+		{"$.store.book[?match(@.title, 'S.*')]", jsonpath.WithMaxRegexpBytes(1), nil},
+	}
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			q := jsonpath.MustCompile(c.query, c.option)
+			_, err := q.Select(doc)
+			assert.Equal(t, c.expected, err)
+			_, err = q.SelectValues(doc)
+			assert.Equal(t, c.expected, err)
+			_, err = q.Select(index(t, doc))
+			assert.Equal(t, c.expected, err)
+		})
+	}
+}
+
+func TestLimitsFailFast(t *testing.T) {
+	doc := logs(100)
+	q := jsonpath.MustCompile("$..*..*..*", jsonpath.WithMaxVisited(100_000), jsonpath.WithParallelDescendants(10))
+	_, err := q.Select(doc)
+	assert.Equal(t, jsonpath.ErrLimitExceeded{Limit: jsonpath.LimitVisited, Max: 100_000}, err)
+	assert.EqualError(t, err, "evaluation exceeds the max visited nodes:100000")
+}
+
+func TestCompileErrors(t *testing.T) {
+	_, err := jsonpath.Compile("$[?@.* == 1]")
+	assert.IsType(t, jsonpath.ErrWrongTypeExpr{}, err)
+	_, err = jsonpath.Compile("$.a[")
+	assert.IsType(t, jsonpath.ErrUnexpectedToken{}, err)
+	assert.Panics(t, func() { jsonpath.MustCompile("$[") })
+}
+
+func BenchmarkSelect(b *testing.B) {
+	doc := decode(b, bookstore)
+	queries := []string{
+		"$.store.bicycle.color",
+		"$.store.book[*].author",
+		"$..book[?@.price < 10 && @.category == 'fiction']",
+		"$..*",
+	}
+	for _, query := range queries {
+		q := jsonpath.MustCompile(query)
+		b.Run(query, func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				q.Select(doc)
+			}
+		})
+		b.Run(query+"/values", func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				q.SelectValues(doc)
+			}
+		})
+	}
+}
+
+// logs returns a document of n arrays of n entries, where every third entry is an error.
+func logs(n int) jsonpath.Value {
+	doc := make([]any, n)
+	for i := range doc {
+		entries := make([]any, n)
+		for j := range entries {
+			kind := "info"
+			if (i*n+j)%3 == 0 {
+				kind = "error"
+			}
+			entries[j] = map[string]any{"type": kind}
+		}
+		doc[i] = entries
+	}
+	return doc
+}
+
+func TestParallelDescendants(t *testing.T) {
+	doc := logs(50)
+	query := "$..[?@.type=='error']"
+	expected := selectNodes(t, jsonpath.MustCompile(query), doc)
+	assert.Len(t, expected, 50*50/3+1)
+	// Workers below 1 default to GOMAXPROCS.
+	for _, workers := range []int{-1, 0, 1, 2, 7, 64} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			q := jsonpath.MustCompile(query, jsonpath.WithParallelDescendants(10), jsonpath.WithWorkers(workers))
+			assert.Equal(t, expected, selectNodes(t, q, doc))
+			// Errors of the goroutines visiting descendants stop the evaluation.
+			q = jsonpath.MustCompile(query, jsonpath.WithParallelDescendants(10), jsonpath.WithWorkers(workers), jsonpath.WithMaxEvalDepth(2))
+			_, err := q.Select(doc)
+			assert.Equal(t, jsonpath.ErrMaxEvalDepth{Depth: 2}, err)
+		})
+	}
+}
+
+func BenchmarkIndex(b *testing.B) {
+	doc := logs(300)
+	indexed := index(b, doc)
+	for _, query := range []string{"$..type", "$..[?@.type=='error']"} {
+		q := jsonpath.MustCompile(query)
+		b.Run(query, func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				q.Select(doc)
+			}
+		})
+		b.Run(query+"/indexed", func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				q.Select(indexed)
+			}
+		})
+	}
+}
+
+func BenchmarkParallelDescendants(b *testing.B) {
+	doc := logs(300)
+	query := "$..[?@.type=='error']"
+	for _, threshold := range []int{0, 100} {
+		q := jsonpath.MustCompile(query, jsonpath.WithParallelDescendants(threshold))
+		b.Run(fmt.Sprintf("threshold=%d", threshold), func(b *testing.B) {
+			for range b.N {
+				q.Select(doc)
+			}
+		})
+	}
+}
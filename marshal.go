@@ -0,0 +1,258 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/marcfyk/go-jsonpath/internal/ast"
+	"github.com/marcfyk/go-jsonpath/internal/eval"
+	"github.com/marcfyk/go-jsonpath/internal/model"
+)
+
+// ErrInvalidMarshal is the error type when Marshal is passed a value that is not a struct or a pointer to one.
+type ErrInvalidMarshal struct {
+	Type reflect.Type
+}
+
+func (e ErrInvalidMarshal) Error() string {
+	return fmt.Sprintf("value to marshal is not a struct or pointer to one:%v", e.Type)
+}
+
+// ErrConflictingTags is the error type when Marshal writes two fields to the same location,
+// or a field into a value of another field.
+type ErrConflictingTags struct {
+	// Location is the location both fields write to.
+	Location Location
+	// Field and Other are the paths of the fields, e.g. Spec.Replicas, in the order they are written.
+	Field string
+	Other string
+}
+
+func (e ErrConflictingTags) Error() string {
+	return fmt.Sprintf("fields write conflicting values:%s and %s; found at location:%s", e.Other, e.Field, e.Location)
+}
+
+// Marshal returns the JSON document made of the values of the fields of the struct v,
+// written to the locations of their jsonpath tags, as Unmarshal reads them:
+//
+//	type Deployment struct {
+//		Name     string `jsonpath:"$.metadata.name"`
+//		App      string `jsonpath:"$.spec.template.metadata.labels['app']"`
+//		Image    string `jsonpath:"$.spec.template.spec.containers[0].image"`
+//		Replicas int    `jsonpath:"$.spec.replicas"`
+//	}
+//
+// Tags must be singular queries without negative indices, whose missing objects and arrays are created,
+// in the order their fields are declared. Arrays are extended to the index written with null elements,
+// with at most MaxArrayExtension of them before it, as SetOrCreate extends them.
+// Values are marshalled as encoding/json marshals them, except that the fields of structs with jsonpath tags,
+// or slices of them, are written by their own tags, relative to the location of the struct if they start with @.
+// Options of tags are ignored.
+//
+// Marshal returns ErrInvalidTag if a tag is not a singular query, and ErrConflictingTags if two fields
+// write to the same location, or a field writes into the value of another, e.g. $.a and $.a.b,
+// and ErrIndexOutOfRange if an array must be extended with more than MaxArrayExtension null elements.
+func Marshal(v any) ([]byte, error) {
+	doc, err := MarshalValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+// MarshalValue returns the document Marshal marshals, whose objects are Objects in the order they are written.
+func MarshalValue(v any) (Value, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, ErrInvalidMarshal{Type: reflect.TypeOf(v)}
+	}
+	root := &written{}
+	if err := tagTypeOf(rv.Type()).write(root, rv, nil, ""); err != nil {
+		return nil, err
+	}
+	if !root.leaf && root.kind == model.Invalid {
+		return Object{}, nil
+	}
+	return root.document(), nil
+}
+
+// written is a value of a document being written by Marshal.
+type written struct {
+	// field is the path of the field that wrote the value, or first wrote into the object or array.
+	field string
+	leaf  bool
+	value Value
+	// kind is the kind of the value if it is an object or array that fields write into.
+	kind model.Kind
+	// names are the names of the members of an object, in the order they are written.
+	names    []string
+	members  map[string]*written
+	elements []*written
+}
+
+// write writes the fields of the struct, rv, into the document, root, with the struct at the steps, base.
+// path is the path of the struct from the struct passed to Marshal, followed by a dot if it is not empty.
+func (tt *tagType) write(root *written, rv reflect.Value, base []eval.Step, path string) error {
+	if tt.err != nil {
+		return tt.err
+	}
+	for _, f := range tt.fields {
+		fv := rv.Field(f.index)
+		if f.query == nil {
+			if err := f.nested.write(root, fv, base, path+f.name+"."); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.steps == nil {
+			return ErrInvalidTag{Type: rv.Type(), Field: f.name, Err: ErrNotSingular{Query: f.query.String()}}
+		}
+		steps := f.steps
+		for _, s := range steps {
+			if s.IsIndex && s.Index < 0 {
+				return ErrInvalidTag{Type: rv.Type(), Field: f.name, Err: errors.New("query has a negative index")}
+			}
+		}
+		if f.relative {
+			steps = append(base[:len(base):len(base)], steps...)
+		}
+		if err := root.writeValue(fv, steps, f.nested, f.elem, path+f.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeValue writes the value, rv, of the field, path, at the steps from root.
+// nested and elem are the tagTypes of the struct type of rv and of its elements, if it is a struct or slice of them.
+func (root *written) writeValue(rv reflect.Value, steps []eval.Step, nested, elem *tagType, path string) error {
+	switch {
+	case nested.tagged():
+		for rv.Kind() == reflect.Pointer && !rv.IsNil() {
+			rv = rv.Elem()
+		}
+		if rv.Kind() == reflect.Pointer {
+			break
+		}
+		w, err := root.at(steps, path)
+		if err != nil {
+			return err
+		}
+		if err := w.into(model.Object, path, steps); err != nil {
+			return err
+		}
+		return nested.write(root, rv, steps, path+".")
+	case elem.tagged() && !rv.IsNil():
+		w, err := root.at(steps, path)
+		if err != nil {
+			return err
+		}
+		if err := w.into(model.Array, path, steps); err != nil {
+			return err
+		}
+		for i := range rv.Len() {
+			element := append(steps[:len(steps):len(steps)], eval.Step{Index: i, IsIndex: true})
+			if err := root.writeValue(rv.Index(i), element, elem, nil, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	w, err := root.at(steps, path)
+	if err != nil {
+		return err
+	}
+	if w.leaf || w.kind != model.Invalid {
+		return ErrConflictingTags{Location: stepsLocation(steps), Field: path, Other: w.field}
+	}
+	w.field, w.leaf, w.value = path, true, rv.Interface()
+	return nil
+}
+
+// at returns the value at the steps from root, creating the objects and arrays on the way for the field, path.
+func (root *written) at(steps []eval.Step, path string) (*written, error) {
+	w := root
+	for i, s := range steps {
+		if !s.IsIndex {
+			if err := w.into(model.Object, path, steps[:i]); err != nil {
+				return nil, err
+			}
+			next, ok := w.members[s.Name]
+			if !ok {
+				next = &written{}
+				if w.members == nil {
+					w.members = make(map[string]*written)
+				}
+				w.members[s.Name] = next
+				w.names = append(w.names, s.Name)
+			}
+			w = next
+			continue
+		}
+		if err := w.into(model.Array, path, steps[:i]); err != nil {
+			return nil, err
+		}
+		if s.Index-len(w.elements) > MaxArrayExtension {
+			return nil, ErrIndexOutOfRange{Location: stepsLocation(steps[:i]), Index: s.Index}
+		}
+		for len(w.elements) <= s.Index {
+			w.elements = append(w.elements, nil)
+		}
+		if w.elements[s.Index] == nil {
+			w.elements[s.Index] = &written{}
+		}
+		w = w.elements[s.Index]
+	}
+	return w, nil
+}
+
+// into makes the value, w, at the steps an object or array of the kind that the field, path, writes into.
+func (w *written) into(kind model.Kind, path string, steps []eval.Step) error {
+	if w.leaf || w.kind != model.Invalid && w.kind != kind {
+		return ErrConflictingTags{Location: stepsLocation(steps), Field: path, Other: w.field}
+	}
+	if w.kind == model.Invalid {
+		w.field, w.kind = path, kind
+	}
+	return nil
+}
+
+// document returns the value written, with null in place of elements that are not.
+func (w *written) document() Value {
+	switch {
+	case w == nil:
+		return nil
+	case w.kind == model.Object:
+		o := make(Object, len(w.names))
+		for i, name := range w.names {
+			o[i] = Member{Name: name, Value: w.members[name].document()}
+		}
+		return o
+	case w.kind == model.Array:
+		elements := make([]any, len(w.elements))
+		for i, e := range w.elements {
+			elements[i] = e.document()
+		}
+		return elements
+	default:
+		return w.value
+	}
+}
+
+// stepsLocation returns the location of the value at the steps from the root.
+func stepsLocation(steps []eval.Step) Location {
+	b := []byte("$")
+	for _, s := range steps {
+		if s.IsIndex {
+			b = ast.AppendIndex(b, s.Index)
+		} else {
+			b = ast.AppendName(b, s.Name)
+		}
+	}
+	return Location(b)
+}
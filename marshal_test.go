@@ -0,0 +1,199 @@
+package jsonpath_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/marcfyk/go-jsonpath"
+	"github.com/stretchr/testify/assert"
+)
+
+type port struct {
+	Name string `jsonpath:"@.name"`
+	Port int    `jsonpath:"@.containerPort"`
+}
+
+type container struct {
+	Name  string `jsonpath:"@.name"`
+	Image string `jsonpath:"@.image"`
+	Ports []port `jsonpath:"@.ports"`
+}
+
+type labels struct {
+	App  string `jsonpath:"$.metadata.labels['app']"`
+	Tier string `jsonpath:"$.metadata.labels['tier']"`
+}
+
+type deployment struct {
+	Name       string `jsonpath:"$.metadata.name"`
+	Labels     labels
+	Replicas   *int              `jsonpath:"$.spec.replicas"`
+	Selector   map[string]string `jsonpath:"$.spec.selector.matchLabels"`
+	App        string            `jsonpath:"$.spec.template.metadata.labels['app']"`
+	Containers []container       `jsonpath:"$.spec.template.spec.containers"`
+	Sidecar    *container        `jsonpath:"$.spec.template.spec.initContainers[1]"`
+	Skipped    string            `jsonpath:"-"`
+}
+
+func TestMarshal(t *testing.T) {
+	replicas := 3
+	d := deployment{
+		Name:     "web",
+		Labels:   labels{App: "web", Tier: "frontend"},
+		Replicas: &replicas,
+		Selector: map[string]string{"app": "web"},
+		App:      "web",
+		Containers: []container{
+			{Name: "web", Image: "nginx:1.27", Ports: []port{{Name: "http", Port: 80}}},
+			{Name: "logs", Image: "fluent-bit"},
+		},
+		Sidecar: &container{Name: "proxy", Image: "envoy"},
+		Skipped: "skipped",
+	}
+	data, err := jsonpath.Marshal(d)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{
+		"metadata": {"name": "web", "labels": {"app": "web", "tier": "frontend"}},
+		"spec": {
+			"replicas": 3,
+			"selector": {"matchLabels": {"app": "web"}},
+			"template": {
+				"metadata": {"labels": {"app": "web"}},
+				"spec": {
+					"containers": [
+						{"name": "web", "image": "nginx:1.27", "ports": [{"name": "http", "containerPort": 80}]},
+						{"name": "logs", "image": "fluent-bit", "ports": null}
+					],
+					"initContainers": [null, {"name": "proxy", "image": "envoy", "ports": null}]
+				}
+			}
+		}
+	}`, string(data))
+
+	// Objects are written in the order of their fields.
+	doc, err := jsonpath.MarshalValue(&d)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"metadata", "spec"}, jsonpath.Adapt(doc).Keys())
+
+	// Documents that are marshalled are unmarshalled to the same struct.
+	var back deployment
+	assert.Nil(t, jsonpath.Unmarshal(data, &back))
+	d.Skipped = ""
+	assert.Equal(t, d, back)
+
+	d.Replicas, d.Sidecar, d.Containers = nil, nil, nil
+	data, err = jsonpath.Marshal(d)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{
+		"metadata": {"name": "web", "labels": {"app": "web", "tier": "frontend"}},
+		"spec": {
+			"replicas": null,
+			"selector": {"matchLabels": {"app": "web"}},
+			"template": {"metadata": {"labels": {"app": "web"}}, "spec": {"containers": null, "initContainers": [null, null]}}
+		}
+	}`, string(data))
+
+	type root struct {
+		Value []int `jsonpath:"$"`
+	}
+	data, err = jsonpath.Marshal(root{Value: []int{1, 2}})
+	assert.Nil(t, err)
+	assert.Equal(t, `[1,2]`, string(data))
+
+	data, err = jsonpath.Marshal(struct{}{})
+	assert.Nil(t, err)
+	assert.Equal(t, `{}`, string(data))
+}
+
+func TestMarshalErrors(t *testing.T) {
+	type nonSingular struct {
+		Names []string `jsonpath:"$.items[*].name"`
+	}
+	_, err := jsonpath.Marshal(nonSingular{})
+	var tagErr jsonpath.ErrInvalidTag
+	assert.True(t, errors.As(err, &tagErr))
+	assert.Equal(t, "Names", tagErr.Field)
+
+	type negative struct {
+		Last string `jsonpath:"$.items[-1]"`
+	}
+	_, err = jsonpath.Marshal(negative{})
+	assert.True(t, errors.As(err, &tagErr))
+
+	cases := []struct {
+		name     string
+		v        any
+		expected jsonpath.ErrConflictingTags
+	}{
+		{
+			"same location",
+			struct {
+				A string `jsonpath:"$.a"`
+				B string `jsonpath:"$['a']"`
+			}{},
+			jsonpath.ErrConflictingTags{Location: "$['a']", Field: "B", Other: "A"},
+		},
+		{
+			"into a value",
+			struct {
+				A string `jsonpath:"$.a"`
+				B string `jsonpath:"$.a.b.c"`
+			}{},
+			jsonpath.ErrConflictingTags{Location: "$['a']", Field: "B", Other: "A"},
+		},
+		{
+			"over an object",
+			struct {
+				A string `jsonpath:"$.a.b.c"`
+				B string `jsonpath:"$.a.b"`
+			}{},
+			jsonpath.ErrConflictingTags{Location: "$['a']['b']", Field: "B", Other: "A"},
+		},
+		{
+			"object and array",
+			struct {
+				A string `jsonpath:"$.a.b"`
+				B string `jsonpath:"$.a[0]"`
+			}{},
+			jsonpath.ErrConflictingTags{Location: "$['a']", Field: "B", Other: "A"},
+		},
+		{
+			"nested",
+			struct {
+				Containers []container `jsonpath:"$.containers"`
+				Image      string      `jsonpath:"$.containers[1].image"`
+			}{Containers: make([]container, 2)},
+			jsonpath.ErrConflictingTags{Location: "$['containers'][1]['image']", Field: "Image", Other: "Containers[1].Image"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := jsonpath.Marshal(c.v)
+			assert.Equal(t, c.expected, err)
+		})
+	}
+
+	// Arrays are extended by at most MaxArrayExtension null elements.
+	type far struct {
+		A string `jsonpath:"$.a[200000000]"`
+	}
+	_, err = jsonpath.Marshal(far{})
+	assert.Equal(t, jsonpath.ErrIndexOutOfRange{Location: "$['a']", Index: 200000000}, err)
+	type edge struct {
+		A int `jsonpath:"$.a[0]"`
+		B int `jsonpath:"$.a[1025]"`
+		C int `jsonpath:"$.a[2050]"`
+	}
+	v, err := jsonpath.MarshalValue(edge{})
+	assert.Nil(t, err)
+	assert.Len(t, v.(jsonpath.Object)[0].Value, 2051)
+	type past struct {
+		A int `jsonpath:"$.a[1025]"`
+	}
+	_, err = jsonpath.Marshal(past{})
+	assert.Equal(t, jsonpath.ErrIndexOutOfRange{Location: "$['a']", Index: 1025}, err)
+
+	_, err = jsonpath.Marshal(3)
+	assert.Equal(t, jsonpath.ErrInvalidMarshal{Type: reflect.TypeFor[int]()}, err)
+}
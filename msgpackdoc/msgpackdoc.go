@@ -0,0 +1,242 @@
+// Package msgpackdoc evaluates jsonpath queries against MessagePack documents.
+//
+// MessagePack is read as the JSON value it is equivalent to:
+//   - Maps are objects, whose keys are strings, or integers named by their decimal representation.
+//     Maps with other keys are not supported.
+//   - Binary data is a base64 encoded string, as encoding/json marshals a []byte.
+//   - Timestamps, i.e. extension type -1, are strings in RFC3339 format.
+//   - Integers are int64, or uint64 if they are beyond the range of an int64, and are compared exactly.
+//
+// Other extension types, NaN and infinite floats, and strings that are not valid UTF-8
+// have no JSON equivalent, and fail with ErrUnsupported.
+package msgpackdoc
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+	"unicode/utf8"
+
+	"github.com/marcfyk/go-jsonpath"
+	"github.com/marcfyk/go-jsonpath/internal/codec"
+	"github.com/marcfyk/go-jsonpath/internal/model"
+)
+
+// Node is an item of a MessagePack document, whose arrays and maps are decoded once a query reads them.
+// Offset returns the offset of the item in the document.
+type Node = codec.Node
+
+// Errors returned when reading a document.
+type (
+	ErrMalformed   = codec.ErrMalformed
+	ErrUnsupported = codec.ErrUnsupported
+)
+
+// Decode decodes a MessagePack document, with maps as jsonpath.Objects,
+// which keep the order of their members.
+func Decode(data []byte) (jsonpath.Value, error) {
+	return codec.Decode(format{}, data)
+}
+
+// Parse returns the Node of a MessagePack document, which queries read without decoding the document
+// up front, so the arrays and maps they do not select into are skipped over rather than decoded.
+// The document is validated once, so that queries cannot fail to read it.
+func Parse(data []byte) (*Node, error) {
+	return codec.Parse(format{}, data)
+}
+
+// timestampType is the extension type of timestamps.
+const timestampType = -1
+
+// format reads MessagePack, as specified by https://github.com/msgpack/msgpack/blob/master/spec.md.
+type format struct{}
+
+func (format) Head(data []byte, off int) (codec.Head, error) {
+	if off >= len(data) {
+		return codec.Head{}, codec.Truncated(off)
+	}
+	switch b := data[off]; {
+	case b <= 0x7f, b >= 0xe0:
+		return codec.Head{Kind: model.Number, Integer: true, End: off + 1}, nil
+	case b <= 0x8f:
+		return codec.Head{Kind: model.Object, Len: int(b & 0x0f), End: off + 1}, nil
+	case b <= 0x9f:
+		return codec.Head{Kind: model.Array, Len: int(b & 0x0f), End: off + 1}, nil
+	case b <= 0xbf:
+		return str(data, off, off+1, int(b&0x1f))
+	case b == 0xc0:
+		return codec.Head{Kind: model.Null, End: off + 1}, nil
+	case b == 0xc1:
+		return codec.Head{}, codec.ErrMalformed{Offset: off, Reason: "reserved byte 0xc1"}
+	case b == 0xc2, b == 0xc3:
+		return codec.Head{Kind: model.Bool, End: off + 1}, nil
+	case b <= 0xc6:
+		n, start, err := length(data, off, 1<<(b-0xc4))
+		if err != nil {
+			return codec.Head{}, err
+		}
+		end, err := payload(data, off, start, n)
+		return codec.Head{Kind: model.String, End: end}, err
+	case b <= 0xc9:
+		n, start, err := length(data, off, 1<<(b-0xc7))
+		if err != nil {
+			return codec.Head{}, err
+		}
+		return ext(data, off, start, n)
+	case b == 0xca, b == 0xcb:
+		end, err := payload(data, off, off+1, 4<<(b-0xca))
+		if err != nil {
+			return codec.Head{}, err
+		}
+		if f := float(data, off); math.IsNaN(f) || math.IsInf(f, 0) {
+			return codec.Head{}, codec.ErrUnsupported{Offset: off, Value: fmt.Sprint(f)}
+		}
+		return codec.Head{Kind: model.Number, End: end}, nil
+	case b <= 0xd3:
+		end, err := payload(data, off, off+1, 1<<((b-0xcc)%4))
+		return codec.Head{Kind: model.Number, Integer: true, End: end}, err
+	case b <= 0xd8:
+		return ext(data, off, off+1, 1<<(b-0xd4))
+	case b <= 0xdb:
+		n, start, err := length(data, off, 1<<(b-0xd9))
+		if err != nil {
+			return codec.Head{}, err
+		}
+		return str(data, off, start, n)
+	default:
+		kind := model.Array
+		if b >= 0xde {
+			kind = model.Object
+		}
+		n, start, err := length(data, off, 2<<((b-0xdc)%2))
+		return codec.Head{Kind: kind, Len: n, End: start}, err
+	}
+}
+
+// length reads the length of the item at offset, off, which is in the size bytes after its first byte.
+// It returns the length and the offset after it.
+func length(data []byte, off, size int) (int, int, error) {
+	start := off + 1 + size
+	if start > len(data) {
+		return 0, 0, codec.Truncated(off)
+	}
+	return int(uintN(data[off+1 : start])), start, nil
+}
+
+// payload returns the offset after the payload of n bytes, at offset start, of the item at offset, off.
+func payload(data []byte, off, start, n int) (int, error) {
+	if n > len(data)-start {
+		return 0, codec.Truncated(off)
+	}
+	return start + n, nil
+}
+
+// str returns the head of the string at offset, off, whose payload of n bytes is at offset start.
+func str(data []byte, off, start, n int) (codec.Head, error) {
+	end, err := payload(data, off, start, n)
+	if err != nil {
+		return codec.Head{}, err
+	}
+	if !utf8.Valid(data[start:end]) {
+		return codec.Head{}, codec.ErrUnsupported{Offset: off, Value: "string that is not valid UTF-8"}
+	}
+	return codec.Head{Kind: model.String, End: end}, nil
+}
+
+// ext returns the head of the extension at offset, off, whose type is at offset start,
+// followed by its payload of n bytes. Only timestamps are supported.
+func ext(data []byte, off, start, n int) (codec.Head, error) {
+	end, err := payload(data, off, start+1, n)
+	if err != nil {
+		return codec.Head{}, err
+	}
+	if t := int8(data[start]); t != timestampType || n != 4 && n != 8 && n != 12 {
+		return codec.Head{}, codec.ErrUnsupported{Offset: off, Value: fmt.Sprintf("extension type %d of %d bytes", t, n)}
+	}
+	return codec.Head{Kind: model.String, End: end}, nil
+}
+
+// float returns the value of the float32 or float64 at offset, off.
+func float(data []byte, off int) float64 {
+	if data[off] == 0xca {
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data[off+1:])))
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(data[off+1:]))
+}
+
+func (format) Scalar(data []byte, off int) any {
+	b := data[off]
+	switch {
+	case b <= 0x7f:
+		return int64(b)
+	case b >= 0xe0:
+		return int64(int8(b))
+	case b <= 0xbf:
+		return string(data[off+1 : off+1+int(b&0x1f)])
+	case b == 0xc0:
+		return nil
+	case b == 0xc2, b == 0xc3:
+		return b == 0xc3
+	case b >= 0xc4 && b <= 0xc6:
+		size := 1 << (b - 0xc4)
+		start := off + 1 + size
+		return base64.StdEncoding.EncodeToString(data[start : start+int(uintN(data[off+1:start]))])
+	case b >= 0xc7 && b <= 0xc9:
+		size := 1 << (b - 0xc7)
+		start := off + 2 + size
+		return timestamp(data[start : start+int(uintN(data[off+1:start-1]))])
+	case b == 0xca:
+		return math.Float32frombits(binary.BigEndian.Uint32(data[off+1:]))
+	case b == 0xcb:
+		return math.Float64frombits(binary.BigEndian.Uint64(data[off+1:]))
+	case b >= 0xcc && b <= 0xcf:
+		u := uintN(data[off+1 : off+1+1<<(b-0xcc)])
+		if u > math.MaxInt64 {
+			return u
+		}
+		return int64(u)
+	case b >= 0xd0 && b <= 0xd3:
+		size := 1 << (b - 0xd0)
+		u := uintN(data[off+1 : off+1+size])
+		// The integer is sign extended from its size.
+		shift := 64 - 8*size
+		return int64(u<<shift) >> shift
+	case b >= 0xd4 && b <= 0xd8:
+		return timestamp(data[off+2 : off+2+1<<(b-0xd4)])
+	default:
+		size := 1 << (b - 0xd9)
+		start := off + 1 + size
+		return string(data[start : start+int(uintN(data[off+1:start]))])
+	}
+}
+
+// timestamp returns the timestamp of the payload of a timestamp extension, in RFC3339 format.
+func timestamp(payload []byte) string {
+	var t time.Time
+	switch len(payload) {
+	case 4:
+		t = time.Unix(int64(binary.BigEndian.Uint32(payload)), 0)
+	case 8:
+		u := binary.BigEndian.Uint64(payload)
+		t = time.Unix(int64(u&(1<<34-1)), int64(u>>34))
+	default:
+		t = time.Unix(int64(binary.BigEndian.Uint64(payload[4:])), int64(binary.BigEndian.Uint32(payload)))
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// uintN returns the big endian unsigned integer of 1, 2, 4 or 8 bytes.
+func uintN(b []byte) uint64 {
+	switch len(b) {
+	case 1:
+		return uint64(b[0])
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b))
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b))
+	default:
+		return binary.BigEndian.Uint64(b)
+	}
+}
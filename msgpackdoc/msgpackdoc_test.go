@@ -0,0 +1,108 @@
+package msgpackdoc_test
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/marcfyk/go-jsonpath"
+	"github.com/marcfyk/go-jsonpath/msgpackdoc"
+	"github.com/stretchr/testify/assert"
+)
+
+// encoded returns the bytes of hexadecimal text, which is split into items by spaces.
+func encoded(t *testing.T, text string) []byte {
+	b, err := hex.DecodeString(strings.ReplaceAll(text, " ", ""))
+	assert.Nil(t, err)
+	return b
+}
+
+// document is a map of 7 members.
+const document = "87" +
+	" a4 6e616d65 a1 61" + // "name": "a"
+	" a3 696473 94 01 fe cd012c cf8000000000000000" + // "ids": [1, -2, 300, 1<<63]
+	" a3 62696e c4 02 6869" + // "bin": bin "hi"
+	" a2 7473 d6 ff 6553f100" + // "ts": timestamp 1700000000
+	" a1 66 cb 3ff8000000000000" + // "f": 1.5
+	" a1 67 ca 3dcccccd" + // "g": float32 0.1
+	" a1 6b 81 01 c3" // "k": {1: true}
+
+// toJSON returns the JSON of v.
+func toJSON(t *testing.T, v any) string {
+	b, err := json.Marshal(v)
+	assert.Nil(t, err)
+	return string(b)
+}
+
+func TestDecode(t *testing.T) {
+	v, err := msgpackdoc.Decode(encoded(t, document))
+	assert.Nil(t, err)
+	assert.Equal(t, jsonpath.Object{
+		{Name: "name", Value: "a"},
+		{Name: "ids", Value: []any{int64(1), int64(-2), int64(300), uint64(1 << 63)}},
+		{Name: "bin", Value: "aGk="},
+		{Name: "ts", Value: "2023-11-14T22:13:20Z"},
+		{Name: "f", Value: 1.5},
+		{Name: "g", Value: float32(0.1)},
+		{Name: "k", Value: jsonpath.Object{{Name: "1", Value: true}}},
+	}, v)
+}
+
+func TestParse(t *testing.T) {
+	data := encoded(t, document)
+	decoded, err := msgpackdoc.Decode(data)
+	assert.Nil(t, err)
+	parsed, err := msgpackdoc.Parse(data)
+	assert.Nil(t, err)
+	indexed, err := jsonpath.Index(parsed)
+	assert.Nil(t, err)
+	queries := []string{
+		"$.*",
+		"$..*",
+		"$.ids[?@ > 1]",
+		"$[?@ == 0.1]",
+		"$..[?@ == true]",
+		"$.ids[-1]",
+	}
+	for _, query := range queries {
+		t.Run(query, func(t *testing.T) {
+			q := jsonpath.MustCompile(query)
+			expected, err := q.Select(decoded)
+			assert.Nil(t, err)
+			for _, doc := range []jsonpath.Value{parsed, indexed} {
+				nodes, err := q.Select(doc)
+				assert.Nil(t, err)
+				assert.Equal(t, toJSON(t, expected), toJSON(t, nodes))
+			}
+		})
+	}
+	values, err := jsonpath.MustCompile("$.k").SelectValues(parsed)
+	assert.Nil(t, err)
+	assert.Equal(t, 64, values[0].(*msgpackdoc.Node).Offset())
+}
+
+func TestErrors(t *testing.T) {
+	cases := []struct {
+		name     string
+		data     string
+		expected error
+	}{
+		{"reserved", "c1", msgpackdoc.ErrMalformed{Offset: 0, Reason: "reserved byte 0xc1"}},
+		{"truncated", "92 01", msgpackdoc.ErrMalformed{Offset: 2, Reason: "unexpected end of data"}},
+		{"truncated string", "a3 6162", msgpackdoc.ErrMalformed{Offset: 0, Reason: "unexpected end of data"}},
+		{"trailing data", "01 02", msgpackdoc.ErrMalformed{Offset: 1, Reason: "unexpected data after the value"}},
+		{"NaN", "91 cb 7ff8000000000001", msgpackdoc.ErrUnsupported{Offset: 1, Value: "NaN"}},
+		{"extension", "d4 05 00", msgpackdoc.ErrUnsupported{Offset: 0, Value: "extension type 5 of 1 bytes"}},
+		{"key", "81 c3 01", msgpackdoc.ErrUnsupported{Offset: 1, Value: "map key that is not a string or integer"}},
+		{"invalid UTF-8", "a1 ff", msgpackdoc.ErrUnsupported{Offset: 0, Value: "string that is not valid UTF-8"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := msgpackdoc.Decode(encoded(t, c.data))
+			assert.Equal(t, c.expected, err)
+			_, err = msgpackdoc.Parse(encoded(t, c.data))
+			assert.Equal(t, c.expected, err)
+		})
+	}
+}
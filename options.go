@@ -0,0 +1,116 @@
+package jsonpath
+
+import (
+	"github.com/marcfyk/go-jsonpath/internal/eval"
+	"github.com/marcfyk/go-jsonpath/internal/parser"
+)
+
+// Option configures how a Query is compiled and evaluated.
+type Option func(*config)
+
+// config is the configuration of a Query built from its options.
+type config struct {
+	// maxParseDepth is the maximum nesting of expressions in the query.
+	maxParseDepth int
+	// eval configures the evaluation.
+	eval eval.Options
+}
+
+// newConfig returns the configuration built from options.
+func newConfig(options []Option) config {
+	c := config{maxParseDepth: parser.DefaultMaxDepth}
+	for _, option := range options {
+		option(&c)
+	}
+	return c
+}
+
+// WithParallelDescendants visits the children of arrays and objects concurrently
+// in descendant segments, e.g. $..[?@.type == 'error'], if they have at least threshold children.
+// Smaller arrays and objects are visited sequentially, as the cost of starting goroutines
+// outweighs the work of visiting them.
+//
+// At most GOMAXPROCS goroutines visit descendants in one evaluation.
+// The selected nodes are in the same order as when visited sequentially.
+func WithParallelDescendants(threshold int) Option {
+	return func(c *config) {
+		c.eval.ParallelThreshold = threshold
+	}
+}
+
+// WithWorkers sets the maximum number of goroutines visiting descendants concurrently
+// in one evaluation, if WithParallelDescendants is set. It defaults to GOMAXPROCS if n is less than 1.
+func WithWorkers(n int) Option {
+	return func(c *config) {
+		c.eval.Workers = n
+	}
+}
+
+// WithMaxParseDepth sets the maximum nesting of parentheses, filters and function arguments
+// in a query, which are parsed recursively. Compile returns ErrMaxParseDepth for queries
+// nested deeper than n. It defaults to 512, and there is no maximum if n is 0.
+func WithMaxParseDepth(n int) Option {
+	return func(c *config) {
+		c.maxParseDepth = n
+	}
+}
+
+// WithMaxEvalDepth sets the maximum nesting of the nodes a descendant segment visits,
+// below the node the segment starts from. Select returns ErrMaxEvalDepth for documents
+// nested deeper than n. There is no maximum by default.
+//
+// Descendant segments visit nodes without recursion, so the maximum only bounds
+// the work done for deeply nested documents rather than the goroutine stack.
+func WithMaxEvalDepth(n int) Option {
+	return func(c *config) {
+		c.eval.MaxDepth = n
+	}
+}
+
+// WithMaxResults sets the maximum number of nodes an evaluation selects.
+// Select returns ErrLimitExceeded with LimitResults for evaluations that select more.
+func WithMaxResults(n int) Option {
+	return func(c *config) {
+		c.eval.MaxResults = n
+	}
+}
+
+// WithMaxVisited sets the maximum number of nodes an evaluation visits, including the nodes
+// that segments select along the way and the nodes visited by queries in filters.
+// Select returns ErrLimitExceeded with LimitVisited as soon as the evaluation visits more,
+// so combinatorial queries, e.g. $..*..*..*, fail fast.
+func WithMaxVisited(n int) Option {
+	return func(c *config) {
+		c.eval.MaxVisited = n
+	}
+}
+
+// WithMaxRegexpBytes sets the maximum total length of the patterns an evaluation compiles,
+// which are the patterns of match and search taken from the document rather than the query.
+// Select returns ErrLimitExceeded with LimitRegexpBytes for evaluations that compile more.
+func WithMaxRegexpBytes(n int) Option {
+	return func(c *config) {
+		c.eval.MaxRegexpBytes = n
+	}
+}
+
+// WithMaxBytes sets the approximate maximum number of bytes an evaluation allocates
+// for the nodes it visits, including their locations, and the patterns it compiles.
+// Select returns ErrLimitExceeded with LimitBytes as soon as the evaluation allocates more.
+func WithMaxBytes(n int) Option {
+	return func(c *config) {
+		c.eval.MaxBytes = n
+	}
+}
+
+// WithSortedKeys selects the members of maps in the order of their names,
+// so that wildcards, filters and descendant segments select them in the same order every time.
+// Objects with an order of their own, i.e. structs, Objects and Documents, keep their order.
+//
+// Without it, the members of maps are selected in the iteration order of the map, which is random,
+// except by descendant segments over indexed documents, whose maps are always laid out by name.
+func WithSortedKeys() Option {
+	return func(c *config) {
+		c.eval.SortedKeys = true
+	}
+}
@@ -0,0 +1,311 @@
+// Package overlay applies OpenAPI Overlays, as specified by the OpenAPI Overlay Specification 1.0.0,
+// to OpenAPI documents, evaluating the targets of their actions as jsonpath queries.
+//
+// Overlays and documents are YAML or JSON. Decode decodes them into Objects, []any and scalars,
+// which keep the order of their members, and Encode encodes documents back to YAML in that order.
+package overlay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/marcfyk/go-jsonpath"
+	"github.com/marcfyk/go-jsonpath/yamldoc"
+)
+
+// ErrInvalidOverlay is the error type when an overlay does not follow the specification.
+type ErrInvalidOverlay struct {
+	// Field is the field of the overlay, e.g. actions[1].target.
+	Field string
+	Err   error
+}
+
+func (e ErrInvalidOverlay) Error() string {
+	return fmt.Sprintf("overlay is invalid:%v; found at field:%s", e.Err, e.Field)
+}
+
+func (e ErrInvalidOverlay) Unwrap() error {
+	return e.Err
+}
+
+// ErrAction is the error type when an action of an overlay cannot be applied.
+type ErrAction struct {
+	// Index is the index of the action in the overlay.
+	Index  int
+	Target string
+	Err    error
+}
+
+func (e ErrAction) Error() string {
+	return fmt.Sprintf("overlay action failed:%v; found at action:%d", e.Err, e.Index)
+}
+
+func (e ErrAction) Unwrap() error {
+	return e.Err
+}
+
+// Warning is a warning about an action that is applied, but whose target selects no node.
+type Warning struct {
+	// Index is the index of the action in the overlay.
+	Index  int
+	Target string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("target selects no node:%s; found at action:%d", w.Target, w.Index)
+}
+
+// Overlay is an overlay document.
+type Overlay struct {
+	// Overlay is the version of the Overlay Specification the overlay follows, e.g. 1.0.0.
+	Overlay string `jsonpath:"$.overlay,required"`
+	Info    Info
+	// Extends is the URL of the document the overlay is meant to be applied to, if it has one.
+	Extends string   `jsonpath:"$.extends"`
+	Actions []Action `jsonpath:"$.actions,required"`
+}
+
+// Info is the metadata of an overlay.
+type Info struct {
+	Title   string `jsonpath:"$.info.title,required"`
+	Version string `jsonpath:"$.info.version,required"`
+}
+
+// Action is an action of an overlay, which updates or removes the nodes its Target selects.
+type Action struct {
+	// Target is a query, e.g. $.paths.*.get.
+	Target      string `jsonpath:"@.target,required"`
+	Description string `jsonpath:"@.description"`
+	// Update is the value merged into the nodes, or nil if the action has none.
+	Update jsonpath.Value `jsonpath:"@.update"`
+	// Remove removes the nodes, in place of updating them.
+	Remove bool `jsonpath:"@.remove"`
+}
+
+// Parse decodes an overlay document, and checks that it follows version 1 of the specification,
+// and that the targets of its actions are queries.
+// It returns the errors of jsonpath.Unmarshal if required fields are missing or have the wrong type,
+// and ErrInvalidOverlay if the version is not supported, there are no actions, or a target cannot be compiled.
+func Parse(data []byte) (*Overlay, error) {
+	doc, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	var o Overlay
+	if err := jsonpath.UnmarshalValue(doc, &o); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(o.Overlay, "1.") {
+		return nil, ErrInvalidOverlay{Field: "overlay", Err: fmt.Errorf("unsupported version:%s", o.Overlay)}
+	}
+	if len(o.Actions) == 0 {
+		return nil, ErrInvalidOverlay{Field: "actions", Err: errors.New("overlay has no actions")}
+	}
+	for i, a := range o.Actions {
+		if _, err := jsonpath.Compile(a.Target); err != nil {
+			return nil, ErrInvalidOverlay{Field: fmt.Sprintf("actions[%d].target", i), Err: err}
+		}
+	}
+	return &o, nil
+}
+
+// Apply applies the actions of the overlay to doc in order, and returns doc, along with a Warning
+// for every action whose target selects no node. doc is updated in place, as Query.Apply and Query.Delete update it.
+//
+// An action that removes deletes the nodes its target selects. Otherwise, its Update is merged into every node:
+//   - The members of an update of an object are merged into the object. Members that are objects in both
+//     are merged recursively, members that are arrays in both are concatenated, and other members are replaced.
+//   - An update of an array is appended to the array as one element.
+//
+// Every node gets its own copy of the update, so later actions modify them independently.
+// Objects and arrays are updated if they are Objects, map[string]any or []any values, as Decode and encoding/json decode them.
+// An action fails with ErrAction if its target selects values that are not objects or arrays,
+// or an update of an object is not an object. doc is partly updated if an action fails.
+func (o *Overlay) Apply(doc jsonpath.Value) (jsonpath.Value, []Warning, error) {
+	var warnings []Warning
+	for i, a := range o.Actions {
+		q, err := jsonpath.Compile(a.Target)
+		if err != nil {
+			return nil, nil, ErrAction{Index: i, Target: a.Target, Err: err}
+		}
+		selected := 0
+		switch {
+		case a.Remove:
+			var removed []jsonpath.Location
+			doc, removed, err = q.Delete(doc)
+			selected = len(removed)
+		case a.Update != nil:
+			doc, err = q.Apply(doc, func(_ jsonpath.Location, v jsonpath.Value) (jsonpath.Value, error) {
+				selected++
+				return merge(v, a.Update)
+			})
+		default:
+			var nodes []jsonpath.Node
+			nodes, err = q.Select(doc)
+			selected = len(nodes)
+		}
+		if err != nil {
+			return nil, nil, ErrAction{Index: i, Target: a.Target, Err: err}
+		}
+		if selected == 0 {
+			warnings = append(warnings, Warning{Index: i, Target: a.Target})
+		}
+	}
+	return doc, warnings, nil
+}
+
+// merge merges the update into the object or array, v, and returns v.
+func merge(v, update jsonpath.Value) (jsonpath.Value, error) {
+	d, u := jsonpath.Adapt(v), jsonpath.Adapt(update)
+	switch d.Kind() {
+	case jsonpath.KindObject:
+		if u.Kind() != jsonpath.KindObject {
+			return nil, errors.New("update of an object is not an object")
+		}
+		for _, name := range u.Keys() {
+			uv, _ := u.Member(name)
+			dv, ok := jsonpath.Adapt(v).Member(name)
+			dk, uk := jsonpath.Adapt(dv).Kind(), jsonpath.Adapt(uv).Kind()
+			var member jsonpath.Value
+			var err error
+			switch {
+			case ok && dk == jsonpath.KindObject && uk == jsonpath.KindObject:
+				member, err = merge(dv, uv)
+			case ok && dk == jsonpath.KindArray && uk == jsonpath.KindArray:
+				member, err = concat(dv, uv)
+			default:
+				member = plain(uv)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if v, err = setMember(v, name, member); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case jsonpath.KindArray:
+		return concat(v, []any{update})
+	default:
+		return nil, fmt.Errorf("target is not an object or array:%T", v)
+	}
+}
+
+// concat appends copies of the elements of the array, elements, to the array, v, and returns v.
+func concat(v, elements jsonpath.Value) (jsonpath.Value, error) {
+	a, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("value cannot be merged into:%T", v)
+	}
+	e := jsonpath.Adapt(elements)
+	for i := range e.Len() {
+		a = append(a, plain(e.Index(i)))
+	}
+	return a, nil
+}
+
+// setMember sets the member, name, of the object, v, to e, adding it if v has none, and returns v.
+func setMember(v jsonpath.Value, name string, e jsonpath.Value) (jsonpath.Value, error) {
+	switch o := v.(type) {
+	case jsonpath.Object:
+		for i := range o {
+			if o[i].Name == name {
+				o[i].Value = e
+				return o, nil
+			}
+		}
+		return append(o, jsonpath.Member{Name: name, Value: e}), nil
+	case map[string]any:
+		o[name] = e
+		return o, nil
+	}
+	return nil, fmt.Errorf("value cannot be merged into:%T", v)
+}
+
+// Decode decodes a YAML or JSON document, read as yamldoc reads it, into Objects, []any and scalars.
+func Decode(data []byte) (jsonpath.Value, error) {
+	n, err := yamldoc.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return plain(n), nil
+}
+
+// plain returns a copy of the value, v, made of Objects, []any and scalars.
+func plain(v jsonpath.Value) jsonpath.Value {
+	d := jsonpath.Adapt(v)
+	switch d.Kind() {
+	case jsonpath.KindObject:
+		keys := d.Keys()
+		o := make(jsonpath.Object, len(keys))
+		for i, name := range keys {
+			m, _ := d.Member(name)
+			o[i] = jsonpath.Member{Name: name, Value: plain(m)}
+		}
+		return o
+	case jsonpath.KindArray:
+		a := make([]any, d.Len())
+		for i := range a {
+			a[i] = plain(d.Index(i))
+		}
+		return a
+	default:
+		return d.Scalar()
+	}
+}
+
+// Encode encodes a document as YAML, with the members of objects in the order they are read in.
+func Encode(doc jsonpath.Value) ([]byte, error) {
+	n, err := node(doc)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(n)
+}
+
+// node returns the yaml.Node of the value, v.
+func node(v jsonpath.Value) (*yaml.Node, error) {
+	d := jsonpath.Adapt(v)
+	switch d.Kind() {
+	case jsonpath.KindObject:
+		n := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, name := range d.Keys() {
+			m, _ := d.Member(name)
+			value, err := node(m)
+			if err != nil {
+				return nil, err
+			}
+			n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name}, value)
+		}
+		return n, nil
+	case jsonpath.KindArray:
+		n := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for i := range d.Len() {
+			e, err := node(d.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			n.Content = append(n.Content, e)
+		}
+		return n, nil
+	case jsonpath.KindInvalid:
+		return nil, fmt.Errorf("value is not a JSON value:%T", v)
+	}
+	s := d.Scalar()
+	if number, ok := s.(json.Number); ok {
+		tag := "!!float"
+		if _, err := number.Int64(); err == nil {
+			tag = "!!int"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: number.String()}, nil
+	}
+	n := &yaml.Node{}
+	if err := n.Encode(s); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
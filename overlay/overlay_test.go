@@ -0,0 +1,211 @@
+package overlay_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/marcfyk/go-jsonpath"
+	"github.com/marcfyk/go-jsonpath/overlay"
+	"github.com/marcfyk/go-jsonpath/yamldoc"
+	"github.com/stretchr/testify/assert"
+)
+
+const petstore = `openapi: 3.0.3
+info:
+  title: Petstore
+  version: 1.0.0
+tags:
+  - name: pets
+paths:
+  /pets:
+    get:
+      operationId: listPets
+      tags: [pets]
+      parameters:
+        - name: limit
+          in: query
+    post:
+      operationId: createPet
+      x-internal: true
+  /admin:
+    get:
+      operationId: admin
+      x-internal: true
+`
+
+const customize = `overlay: 1.0.0
+info:
+  title: Public petstore
+  version: 1.0.0
+extends: https://example.com/petstore.yaml
+actions:
+  - target: $.info
+    description: Rename the API.
+    update:
+      title: Public Petstore
+      contact:
+        email: pets@example.com
+  - target: $.paths.*[?@['x-internal']]
+    remove: true
+  - target: $.paths[?length(@) == 0]
+    remove: true
+  - target: $.paths.*.get
+    update:
+      tags: [public]
+      parameters:
+        - name: offset
+          in: query
+  - target: $.tags
+    update:
+      name: public
+  - target: $.components.schemas
+    update:
+      Pet: {type: object}
+`
+
+func parse(t *testing.T, data string) *overlay.Overlay {
+	o, err := overlay.Parse([]byte(data))
+	assert.Nil(t, err)
+	return o
+}
+
+func decode(t *testing.T, data string) jsonpath.Value {
+	doc, err := overlay.Decode([]byte(data))
+	assert.Nil(t, err)
+	return doc
+}
+
+func marshal(t *testing.T, v any) string {
+	b, err := json.Marshal(v)
+	assert.Nil(t, err)
+	return string(b)
+}
+
+func TestParse(t *testing.T) {
+	o := parse(t, customize)
+	assert.Equal(t, "1.0.0", o.Overlay)
+	assert.Equal(t, overlay.Info{Title: "Public petstore", Version: "1.0.0"}, o.Info)
+	assert.Equal(t, "https://example.com/petstore.yaml", o.Extends)
+	assert.Len(t, o.Actions, 6)
+	assert.Equal(t, "Rename the API.", o.Actions[0].Description)
+	assert.Equal(t, jsonpath.Object{
+		{Name: "title", Value: "Public Petstore"},
+		{Name: "contact", Value: jsonpath.Object{{Name: "email", Value: "pets@example.com"}}},
+	}, o.Actions[0].Update)
+	assert.True(t, o.Actions[1].Remove)
+	assert.Nil(t, o.Actions[1].Update)
+
+	// JSON overlays are YAML.
+	o = parse(t, `{"overlay": "1.0.0", "info": {"title": "t", "version": "1"}, "actions": [{"target": "$", "remove": true}]}`)
+	assert.Equal(t, []overlay.Action{{Target: "$", Remove: true}}, o.Actions)
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := overlay.Parse([]byte(`overlay: 2.0.0
+info: {title: t, version: "1"}
+actions: []
+`))
+	assert.Equal(t, overlay.ErrInvalidOverlay{Field: "overlay", Err: errors.New("unsupported version:2.0.0")}, err)
+
+	_, err = overlay.Parse([]byte(`overlay: 1.0.0
+info: {title: t, version: "1"}
+actions: []
+`))
+	assert.Equal(t, overlay.ErrInvalidOverlay{Field: "actions", Err: errors.New("overlay has no actions")}, err)
+
+	_, err = overlay.Parse([]byte(`overlay: 1.0.0
+info: {title: t, version: "1"}
+actions:
+  - target: $.paths
+  - target: $.paths[
+`))
+	var invalid overlay.ErrInvalidOverlay
+	assert.True(t, errors.As(err, &invalid))
+	assert.Equal(t, "actions[1].target", invalid.Field)
+
+	_, err = overlay.Parse([]byte(`overlay: 1.0.0
+actions: []
+`))
+	var missing jsonpath.ErrMissingField
+	assert.True(t, errors.As(err, &missing))
+	assert.Equal(t, "Info.Title", missing.Field)
+
+	_, err = overlay.Parse([]byte(`overlay: [`))
+	assert.NotNil(t, err)
+}
+
+func TestApply(t *testing.T) {
+	doc, warnings, err := parse(t, customize).Apply(decode(t, petstore))
+	assert.Nil(t, err)
+	assert.Equal(t, []overlay.Warning{{Index: 5, Target: "$.components.schemas"}}, warnings)
+	assert.Equal(t, "target selects no node:$.components.schemas; found at action:5", warnings[0].String())
+
+	data, err := overlay.Encode(doc)
+	assert.Nil(t, err)
+	assert.Equal(t, `openapi: 3.0.3
+info:
+    title: Public Petstore
+    version: 1.0.0
+    contact:
+        email: pets@example.com
+tags:
+    - name: pets
+    - name: public
+paths:
+    /pets:
+        get:
+            operationId: listPets
+            tags:
+                - pets
+                - public
+            parameters:
+                - name: limit
+                  in: query
+                - name: offset
+                  in: query
+`, string(data))
+}
+
+func TestApplyCopiesUpdates(t *testing.T) {
+	o := parse(t, `overlay: 1.0.0
+info: {title: t, version: "1"}
+actions:
+  - target: $.items[*]
+    update: {meta: {n: 1}}
+  - target: $.items[0].meta
+    update: {n: 2}
+`)
+	doc, warnings, err := o.Apply(decode(t, `items: [{}, {}]`))
+	assert.Nil(t, err)
+	assert.Empty(t, warnings)
+	assert.JSONEq(t, `{"items": [{"meta": {"n": 2}}, {"meta": {"n": 1}}]}`, marshal(t, doc))
+
+	// Documents decoded by encoding/json are updated too.
+	doc, _, err = o.Apply(map[string]any{"items": []any{map[string]any{"meta": map[string]any{"m": 0}}}})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"items": [{"meta": {"m": 0, "n": 2}}]}`, marshal(t, doc))
+}
+
+func TestApplyErrors(t *testing.T) {
+	readOnly, err := yamldoc.Parse([]byte(petstore))
+	assert.Nil(t, err)
+	cases := []struct {
+		name    string
+		actions string
+		doc     jsonpath.Value
+	}{
+		{"scalar target", `[{target: $.info.title, update: {a: 1}}]`, decode(t, petstore)},
+		{"scalar update", `[{target: $.info, update: 1}]`, decode(t, petstore)},
+		{"read-only document", `[{target: $.info, update: {a: 1}}]`, readOnly},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			o := parse(t, "overlay: 1.0.0\ninfo: {title: t, version: '1'}\nactions: "+c.actions)
+			_, _, err := o.Apply(c.doc)
+			var actionErr overlay.ErrAction
+			assert.True(t, errors.As(err, &actionErr))
+			assert.Equal(t, 0, actionErr.Index)
+		})
+	}
+}
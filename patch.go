@@ -0,0 +1,493 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/marcfyk/go-jsonpath/internal/eval"
+	"github.com/marcfyk/go-jsonpath/internal/model"
+)
+
+// ErrPatch is the error type when an operation of a patch cannot be applied.
+type ErrPatch struct {
+	// Index is the index of the operation in the patch.
+	Index int
+	Err   error
+}
+
+func (e ErrPatch) Error() string {
+	return fmt.Sprintf("patch operation failed:%v; found at operation:%d", e.Err, e.Index)
+}
+
+func (e ErrPatch) Unwrap() error {
+	return e.Err
+}
+
+// Operation is an operation of a JSON Patch, as specified by RFC6902.
+// In a Patch, Path and From are JSON Pointers, as specified by RFC6901, e.g. /items/0,
+// and in a QueryPatch, they are queries, e.g. $.items[0].
+type Operation struct {
+	// Op is add, remove, replace, move, copy or test.
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	// From is the location a move or copy operation moves or copies the value from.
+	From string `json:"from,omitempty"`
+	// Value is the value an add, replace or test operation adds, replaces or tests the value at Path with.
+	Value Value `json:"value,omitempty"`
+	// noValue reports if the operation is unmarshalled from JSON without a value member.
+	noValue bool
+}
+
+// MarshalJSON marshals the operation, with its value if it is an add, replace or test operation, even if it is null.
+func (o Operation) MarshalJSON() ([]byte, error) {
+	op := struct {
+		Op    string `json:"op"`
+		Path  string `json:"path"`
+		From  string `json:"from,omitempty"`
+		Value *Value `json:"value,omitempty"`
+	}{Op: o.Op, Path: o.Path, From: o.From}
+	switch o.Op {
+	case "add", "replace", "test":
+		op.Value = &o.Value
+	}
+	return json.Marshal(op)
+}
+
+// UnmarshalJSON unmarshals the operation, decoding its value with Decode.
+// Add, replace and test operations without a value fail when they are applied.
+func (o *Operation) UnmarshalJSON(data []byte) error {
+	var op struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		From  string          `json:"from"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(data, &op); err != nil {
+		return err
+	}
+	*o = Operation{Op: op.Op, Path: op.Path, From: op.From, noValue: op.Value == nil}
+	if op.Value != nil {
+		v, err := Decode(op.Value)
+		if err != nil {
+			return err
+		}
+		o.Value = v
+	}
+	return nil
+}
+
+// Patch is a JSON Patch, as specified by RFC6902.
+type Patch []Operation
+
+// Apply applies the operations of the patch to doc in order, and returns doc,
+// or the value that replaces it if an operation replaces the root.
+//
+// Values are added, replaced and removed in place, in the same arrays and objects that Set writes into,
+// and are copied, as Objects and []any values, so that the values of the patch are not modified by later operations.
+// If an operation fails, Apply returns ErrPatch with the index of the operation, and doc is partly modified.
+func (p Patch) Apply(doc Value) (Value, error) {
+	for i, op := range p {
+		var err error
+		if doc, err = applyOperation(doc, op); err != nil {
+			return nil, ErrPatch{Index: i, Err: err}
+		}
+	}
+	return doc, nil
+}
+
+// SetWithPatch is like Set, and also returns the JSON Patch of the values it replaces,
+// which is a replace operation for every node whose value changes, in the order they are replaced.
+func (q *Query) SetWithPatch(doc Value, value Value) (Value, Patch, error) {
+	return q.ApplyWithPatch(doc, func(Location, Value) (Value, error) {
+		return value, nil
+	})
+}
+
+// ApplyWithPatch is like Apply, and also returns the JSON Patch of the values it replaces,
+// which is a replace operation for every node whose value changes, in the order they are replaced.
+// Arrays and objects always change, as f may modify them in place, and other values change if they are not equal.
+// The values of the operations are the values f returns, rather than copies of them.
+func (q *Query) ApplyWithPatch(doc Value, f func(loc Location, v Value) (Value, error)) (Value, Patch, error) {
+	patch := Patch{}
+	v, err := q.edit(doc, false, func(loc Location, steps []eval.Step, v Value) (Value, error) {
+		replaced, err := f(loc, v)
+		if err != nil {
+			return nil, ErrApply{Location: loc, Err: err}
+		}
+		if kind := model.Of(replaced).Kind(); kind == model.Array || kind == model.Object || !model.Equal(v, replaced) {
+			patch = append(patch, Operation{Op: "replace", Path: pointer(steps), Value: replaced})
+		}
+		return replaced, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, patch, nil
+}
+
+// DeleteWithPatch is like Delete, and also returns the JSON Patch of the nodes it removes,
+// which is a remove operation for every node, in the order they are removed, i.e. elements of the same array
+// from the highest index down. If the Query selects the root, the patch replaces it with null.
+func (q *Query) DeleteWithPatch(doc Value) (Value, Patch, error) {
+	t, _, err := q.edits(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	if t == nil {
+		return doc, Patch{}, nil
+	}
+	if t.selected {
+		return nil, Patch{{Op: "replace", Path: ""}}, nil
+	}
+	patch := Patch{}
+	t.walk(nil, true, func(steps []eval.Step) {
+		patch = append(patch, Operation{Op: "remove", Path: pointer(steps)})
+	})
+	v, err := t.remove(doc, []byte("$"), false)
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, patch, nil
+}
+
+// QueryPatch is a patch whose operations have queries in place of the JSON Pointers of a Patch.
+//
+// The Path of an operation is expanded into an operation for every node it selects, which are applied
+// in the order Set, Delete and Apply edit them, i.e. elements of the same array from the highest index down.
+// An operation whose Path selects no node is not applied, except that:
+//   - an add, copy or move operation with a singular query adds the value at the location of the query,
+//     e.g. $.a.b adds the member b to the object at $.a.
+//   - a test operation fails.
+//
+// The From of a move or copy operation must be a singular query that selects a node.
+// Queries are compiled once, and kept in the Cache shared by Get, GetAll and GetOne.
+type QueryPatch []Operation
+
+// Apply expands every operation of the patch into the operations of the nodes it selects from doc,
+// as modified by the operations before it, and applies them. It returns doc, as Patch.Apply returns it,
+// along with the Patch of the operations that it applies.
+func (p QueryPatch) Apply(doc Value) (Value, Patch, error) {
+	applied := Patch{}
+	for i, op := range p {
+		expanded, err := expand(doc, op)
+		if err != nil {
+			return nil, nil, ErrPatch{Index: i, Err: err}
+		}
+		for _, e := range expanded {
+			if doc, err = applyOperation(doc, e); err != nil {
+				return nil, nil, ErrPatch{Index: i, Err: err}
+			}
+		}
+		applied = append(applied, expanded...)
+	}
+	return doc, applied, nil
+}
+
+// expand returns the operations of the nodes the Path of the operation, op, selects from doc.
+func expand(doc Value, op Operation) ([]Operation, error) {
+	if err := checkValue(op); err != nil {
+		return nil, err
+	}
+	q, err := queries.Compile(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	var from string
+	if op.Op == "move" || op.Op == "copy" {
+		fq, err := queries.Compile(op.From)
+		if err != nil {
+			return nil, err
+		}
+		if !fq.IsSingular() {
+			return nil, ErrNotSingular{Query: op.From}
+		}
+		paths, err := fq.program.SelectSteps(doc)
+		if err != nil {
+			return nil, err
+		}
+		if len(paths) == 0 {
+			return nil, ErrNoMatch{Query: op.From}
+		}
+		from = pointer(paths[0])
+	}
+	t, _, err := q.edits(doc)
+	if err != nil {
+		return nil, err
+	}
+	var ops []Operation
+	if t != nil {
+		t.walk(nil, op.Op == "remove", func(steps []eval.Step) {
+			ops = append(ops, Operation{Op: op.Op, Path: pointer(steps), From: from, Value: op.Value})
+		})
+		return ops, nil
+	}
+	switch op.Op {
+	case "add", "copy", "move":
+		steps, ok := q.program.Path()
+		if !ok {
+			return nil, nil
+		}
+		for _, s := range steps {
+			if s.IsIndex && s.Index < 0 {
+				return nil, ErrNoMatch{Query: op.Path}
+			}
+		}
+		return []Operation{{Op: op.Op, Path: pointer(steps), From: from, Value: op.Value}}, nil
+	case "test":
+		return nil, ErrNoMatch{Query: op.Path}
+	}
+	return nil, nil
+}
+
+// checkValue returns an error if the operation, op, must have a value, but was unmarshalled without one.
+func checkValue(op Operation) error {
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.noValue {
+			return fmt.Errorf("operation has no value:%s", op.Op)
+		}
+	}
+	return nil
+}
+
+// applyOperation applies the operation, op, to doc, and returns doc.
+func applyOperation(doc Value, op Operation) (Value, error) {
+	if err := checkValue(op); err != nil {
+		return nil, err
+	}
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch op.Op {
+	case "add", "replace":
+		v, err := plain(op.Value, 0, nil)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "add" {
+			return add(doc, path, v)
+		}
+		return replace(doc, path, v)
+	case "remove":
+		return remove(doc, path)
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		v, err := valueAt(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "copy" {
+			if v, err = plain(v, 0, nil); err != nil {
+				return nil, err
+			}
+			return add(doc, path, v)
+		}
+		if slices.Equal(from, path) {
+			return doc, nil
+		}
+		if len(from) < len(path) && slices.Equal(from, path[:len(from)]) {
+			return nil, fmt.Errorf("value cannot be moved into itself:%s", op.From)
+		}
+		if doc, err = remove(doc, from); err != nil {
+			return nil, err
+		}
+		return add(doc, path, v)
+	case "test":
+		v, err := valueAt(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !model.Equal(v, op.Value) {
+			return nil, fmt.Errorf("value is not equal to the value tested:%s", op.Path)
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("unknown operation:%s", op.Op)
+	}
+}
+
+// add adds the value, e, at the reference tokens, path, of doc, and returns doc.
+func add(doc Value, path []string, e Value) (Value, error) {
+	if len(path) == 0 {
+		return e, nil
+	}
+	return update(doc, path, []byte("$"), func(v Value, token string, loc []byte) (Value, error) {
+		m := model.Of(v)
+		switch m.Kind() {
+		case model.Object:
+			if _, ok := m.Member(token); ok {
+				return writeChild(v, eval.Step{Name: token}, e, loc)
+			}
+			return addMember(v, token, e, loc)
+		case model.Array:
+			i := m.Len()
+			if token != "-" {
+				var err error
+				if i, err = arrayIndex(token, m.Len()+1); err != nil {
+					return nil, err
+				}
+			}
+			return insert(v, i, e, loc)
+		default:
+			return nil, ErrNotWritable{Location: Location(loc), Type: reflect.TypeOf(v)}
+		}
+	})
+}
+
+// replace replaces the value at the reference tokens, path, of doc with e, and returns doc.
+func replace(doc Value, path []string, e Value) (Value, error) {
+	if len(path) == 0 {
+		return e, nil
+	}
+	return update(doc, path, []byte("$"), func(v Value, token string, loc []byte) (Value, error) {
+		s, err := existingStep(v, token)
+		if err != nil {
+			return nil, err
+		}
+		return writeChild(v, s, e, loc)
+	})
+}
+
+// remove removes the value at the reference tokens, path, of doc, and returns doc.
+func remove(doc Value, path []string) (Value, error) {
+	if len(path) == 0 {
+		return nil, errors.New("root cannot be removed")
+	}
+	return update(doc, path, []byte("$"), func(v Value, token string, loc []byte) (Value, error) {
+		s, err := existingStep(v, token)
+		if err != nil {
+			return nil, err
+		}
+		return removeChild(v, s, loc)
+	})
+}
+
+// update replaces the array or object, v, that the last of the reference tokens, path, is in,
+// with the value f returns for it, and returns v. loc is the location of v.
+func update(v Value, path []string, loc []byte, f func(v Value, token string, loc []byte) (Value, error)) (Value, error) {
+	if len(path) == 1 {
+		return f(v, path[0], loc)
+	}
+	s, err := existingStep(v, path[0])
+	if err != nil {
+		return nil, err
+	}
+	e, _ := child(model.Of(v), s)
+	e, err = update(e, path[1:], appendStep(loc, s), f)
+	if err != nil {
+		return nil, err
+	}
+	return writeChild(v, s, e, loc)
+}
+
+// valueAt returns the value at the reference tokens, path, of doc.
+func valueAt(doc Value, path []string) (Value, error) {
+	for _, token := range path {
+		s, err := existingStep(doc, token)
+		if err != nil {
+			return nil, err
+		}
+		doc, _ = child(model.Of(doc), s)
+	}
+	return doc, nil
+}
+
+// existingStep returns the step of the member or element, token, of v, which must exist.
+func existingStep(v Value, token string) (eval.Step, error) {
+	m := model.Of(v)
+	switch m.Kind() {
+	case model.Object:
+		if _, ok := m.Member(token); !ok {
+			return eval.Step{}, fmt.Errorf("object has no member:%s", token)
+		}
+		return eval.Step{Name: token}, nil
+	case model.Array:
+		i, err := arrayIndex(token, m.Len())
+		return eval.Step{Index: i, IsIndex: true}, err
+	default:
+		return eval.Step{}, fmt.Errorf("value is not an array or object:%s", token)
+	}
+}
+
+// arrayIndex returns the index of the reference token, which must be less than n.
+func arrayIndex(token string, n int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || strconv.Itoa(i) != token {
+		return 0, fmt.Errorf("reference token is not an array index:%s", token)
+	}
+	if i >= n {
+		return 0, fmt.Errorf("array index out of range:%d", i)
+	}
+	return i, nil
+}
+
+// insert inserts e into the array, v, at the location, loc, at the index, i, and returns the array.
+func insert(v Value, i int, e Value, loc []byte) (Value, error) {
+	if s, ok := v.([]any); ok {
+		return slices.Insert(s, i, e), nil
+	}
+	n := model.Of(v).Len()
+	grown, err := grow(v, n, e, loc)
+	if err != nil {
+		return nil, err
+	}
+	// The element is moved from the end of the array into its place.
+	for j := n; j > i; j-- {
+		if grown, err = swap(grown, j, j-1, loc); err != nil {
+			return nil, err
+		}
+	}
+	return grown, nil
+}
+
+// swap swaps the elements at the indices, i and j, of the array, v, at the location, loc, and returns the array.
+func swap(v Value, i, j int, loc []byte) (Value, error) {
+	m := model.Of(v)
+	a, b := m.Index(i), m.Index(j)
+	v, err := writeChild(v, eval.Step{Index: i, IsIndex: true}, b, loc)
+	if err != nil {
+		return nil, err
+	}
+	return writeChild(v, eval.Step{Index: j, IsIndex: true}, a, loc)
+}
+
+// parsePointer returns the reference tokens of a JSON Pointer.
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if p[0] != '/' {
+		return nil, fmt.Errorf("JSON pointer does not start with /:%s", p)
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, token := range tokens {
+		if strings.Contains(strings.ReplaceAll(strings.ReplaceAll(token, "~0", ""), "~1", ""), "~") {
+			return nil, fmt.Errorf("JSON pointer has an invalid escape:%s", p)
+		}
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// pointer returns the JSON Pointer of the value at the steps from the root.
+func pointer(steps []eval.Step) string {
+	var b strings.Builder
+	for _, s := range steps {
+		b.WriteByte('/')
+		if s.IsIndex {
+			b.WriteString(strconv.Itoa(s.Index))
+		} else {
+			b.WriteString(strings.ReplaceAll(strings.ReplaceAll(s.Name, "~", "~0"), "/", "~1"))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,197 @@
+package jsonpath_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/marcfyk/go-jsonpath"
+	"github.com/stretchr/testify/assert"
+)
+
+// patchOf returns the patch of the JSON, s, failing the test if it cannot be unmarshalled.
+func patchOf(t testing.TB, s string) []jsonpath.Operation {
+	var p []jsonpath.Operation
+	if err := json.Unmarshal([]byte(s), &p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestPatchApply(t *testing.T) {
+	cases := []struct {
+		name     string
+		doc      string
+		patch    string
+		expected string
+	}{
+		{"add member", `{"foo": "bar"}`, `[{"op": "add", "path": "/baz", "value": "qux"}]`, `{"baz": "qux", "foo": "bar"}`},
+		{"add element", `{"foo": ["bar", "baz"]}`, `[{"op": "add", "path": "/foo/1", "value": "qux"}]`, `{"foo": ["bar", "qux", "baz"]}`},
+		{"add to end", `{"foo": ["bar"]}`, `[{"op": "add", "path": "/foo/-", "value": ["abc"]}]`, `{"foo": ["bar", ["abc"]]}`},
+		{"add null", `{"foo": 1}`, `[{"op": "add", "path": "/bar", "value": null}]`, `{"foo": 1, "bar": null}`},
+		{"remove", `{"baz": "qux", "foo": "bar"}`, `[{"op": "remove", "path": "/baz"}]`, `{"foo": "bar"}`},
+		{"remove element", `{"foo": ["bar", "qux", "baz"]}`, `[{"op": "remove", "path": "/foo/1"}]`, `{"foo": ["bar", "baz"]}`},
+		{"replace", `{"baz": "qux", "foo": "bar"}`, `[{"op": "replace", "path": "/baz", "value": "boo"}]`, `{"baz": "boo", "foo": "bar"}`},
+		{"replace root", `{"foo": "bar"}`, `[{"op": "replace", "path": "", "value": [1]}]`, `[1]`},
+		{
+			"move",
+			`{"foo": {"bar": "baz", "waldo": "fred"}, "qux": {"corge": "grault"}}`,
+			`[{"op": "move", "from": "/foo/waldo", "path": "/qux/thud"}]`,
+			`{"foo": {"bar": "baz"}, "qux": {"corge": "grault", "thud": "fred"}}`,
+		},
+		{"move element", `{"foo": ["all", "grass", "cows", "eat"]}`, `[{"op": "move", "from": "/foo/1", "path": "/foo/3"}]`, `{"foo": ["all", "cows", "eat", "grass"]}`},
+		{"copy", `{"a": {"b": [1]}}`, `[{"op": "copy", "from": "/a/b", "path": "/c"}, {"op": "add", "path": "/c/-", "value": 2}]`, `{"a": {"b": [1]}, "c": [1, 2]}`},
+		{"test", `{"baz": "qux", "foo": ["a", 2, "c"]}`, `[{"op": "test", "path": "/baz", "value": "qux"}, {"op": "test", "path": "/foo/1", "value": 2}]`, `{"baz": "qux", "foo": ["a", 2, "c"]}`},
+		{"escapes", `{"a/b": {"m~n": 1}}`, `[{"op": "replace", "path": "/a~1b/m~0n", "value": 2}]`, `{"a/b": {"m~n": 2}}`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for _, doc := range []jsonpath.Value{decode(t, c.doc), decodeOrdered(t, c.doc)} {
+				v, err := jsonpath.Patch(patchOf(t, c.patch)).Apply(doc)
+				assert.Nil(t, err)
+				assert.JSONEq(t, c.expected, marshal(t, v))
+			}
+		})
+	}
+
+	// Values of the patch are copied into the document.
+	value := []any{1}
+	v, err := jsonpath.Patch{{Op: "add", Path: "/a", Value: value}, {Op: "add", Path: "/a/-", Value: 2}}.Apply(map[string]any{})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"a": [1, 2]}`, marshal(t, v))
+	assert.Equal(t, []any{1}, value)
+
+	// Elements are inserted into slices of other types.
+	v, err = jsonpath.Patch{{Op: "add", Path: "/0", Value: "x"}}.Apply([]string{"a", "b"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"x", "a", "b"}, v)
+}
+
+func TestPatchApplyErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		patch string
+		index int
+	}{
+		{"missing member", `[{"op": "remove", "path": "/missing"}]`, 0},
+		{"missing parent", `[{"op": "add", "path": "/missing/a", "value": 1}]`, 0},
+		{"index out of range", `[{"op": "add", "path": "/a/3", "value": 1}]`, 0},
+		{"leading zero", `[{"op": "replace", "path": "/a/01", "value": 1}]`, 0},
+		{"end of array", `[{"op": "replace", "path": "/a/-", "value": 1}]`, 0},
+		{"into a scalar", `[{"op": "add", "path": "/b/c", "value": 1}]`, 0},
+		{"invalid pointer", `[{"op": "add", "path": "a", "value": 1}]`, 0},
+		{"invalid escape", `[{"op": "add", "path": "/a~2", "value": 1}]`, 0},
+		{"move into itself", `[{"op": "move", "from": "/a", "path": "/a/0"}]`, 0},
+		{"failed test", `[{"op": "test", "path": "/b", "value": 1}, {"op": "test", "path": "/b", "value": 2}]`, 1},
+		{"unknown op", `[{"op": "merge", "path": "/b"}]`, 0},
+		{"remove root", `[{"op": "remove", "path": ""}]`, 0},
+		{"add without value", `[{"op": "add", "path": "/c"}]`, 0},
+		{"replace without value", `[{"op": "replace", "path": "/b"}]`, 0},
+		{"test without value", `[{"op": "test", "path": "/b", "value": 1}, {"op": "test", "path": "/b"}]`, 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := jsonpath.Patch(patchOf(t, c.patch)).Apply(decode(t, `{"a": [1, 2], "b": 1}`))
+			var patchErr jsonpath.ErrPatch
+			assert.True(t, errors.As(err, &patchErr))
+			assert.Equal(t, c.index, patchErr.Index)
+		})
+	}
+}
+
+func TestOperationJSON(t *testing.T) {
+	p := jsonpath.Patch{
+		{Op: "add", Path: "/a", Value: nil},
+		{Op: "remove", Path: "/b"},
+		{Op: "move", Path: "/c", From: "/d"},
+	}
+	assert.JSONEq(t, `[
+		{"op": "add", "path": "/a", "value": null},
+		{"op": "remove", "path": "/b"},
+		{"op": "move", "path": "/c", "from": "/d"}
+	]`, marshal(t, p))
+
+	// Values are decoded as Decode decodes them.
+	back := patchOf(t, `[{"op": "add", "path": "/a", "value": {"b": 1, "a": 2}}]`)
+	assert.Equal(t, jsonpath.Object{{Name: "b", Value: json.Number("1")}, {Name: "a", Value: json.Number("2")}}, back[0].Value)
+}
+
+func TestEditsWithPatch(t *testing.T) {
+	doc := decode(t, `{"items": [{"price": 1}, {"price": 2}, {"name": "x"}], "total": 3}`)
+	v, p, err := jsonpath.MustCompile("$.items[*].price").SetWithPatch(doc, 2)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"items": [{"price": 2}, {"price": 2}, {"name": "x"}], "total": 3}`, marshal(t, v))
+	// The price that is already 2 is not replaced.
+	assert.JSONEq(t, `[{"op": "replace", "path": "/items/0/price", "value": 2}]`, marshal(t, p))
+
+	v, p, err = jsonpath.MustCompile("$.items[?@.price]").DeleteWithPatch(v)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"items": [{"name": "x"}], "total": 3}`, marshal(t, v))
+	assert.JSONEq(t, `[{"op": "remove", "path": "/items/1"}, {"op": "remove", "path": "/items/0"}]`, marshal(t, p))
+
+	v, p, err = jsonpath.MustCompile("$..name").ApplyWithPatch(v, func(_ jsonpath.Location, v jsonpath.Value) (jsonpath.Value, error) {
+		return v.(string) + "!", nil
+	})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `[{"op": "replace", "path": "/items/0/name", "value": "x!"}]`, marshal(t, p))
+
+	// Patches replay the edits they are returned by.
+	replayed, err := p.Apply(decode(t, `{"items": [{"name": "x"}], "total": 3}`))
+	assert.Nil(t, err)
+	assert.Equal(t, marshal(t, v), marshal(t, replayed))
+
+	v, p, err = jsonpath.MustCompile("$").DeleteWithPatch(v)
+	assert.Nil(t, err)
+	assert.Nil(t, v)
+	assert.JSONEq(t, `[{"op": "replace", "path": "", "value": null}]`, marshal(t, p))
+
+	_, p, err = jsonpath.MustCompile("$.missing").DeleteWithPatch(decode(t, `{}`))
+	assert.Nil(t, err)
+	assert.Empty(t, p)
+}
+
+func TestQueryPatch(t *testing.T) {
+	doc := decode(t, `{"users": [{"name": "a", "admin": true}, {"name": "b"}, {"name": "c", "admin": false}], "meta": {}}`)
+	// Missing members are only added by singular queries, so $.users[*].active is not applied.
+	v, applied, err := jsonpath.QueryPatch(patchOf(t, `[
+		{"op": "test", "path": "$.users[0].name", "value": "a"},
+		{"op": "remove", "path": "$.users[*].admin"},
+		{"op": "add", "path": "$.users[*].active", "value": true},
+		{"op": "add", "path": "$.users[0].active", "value": true},
+		{"op": "add", "path": "$.meta.count", "value": 3},
+		{"op": "copy", "from": "$.users[0].name", "path": "$.meta.first"},
+		{"op": "remove", "path": "$.users[?@.name == 'b']"}
+	]`)).Apply(doc)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{
+		"users": [{"name": "a", "active": true}, {"name": "c"}],
+		"meta": {"count": 3, "first": "a"}
+	}`, marshal(t, v))
+	assert.JSONEq(t, `[
+		{"op": "test", "path": "/users/0/name", "value": "a"},
+		{"op": "remove", "path": "/users/2/admin"},
+		{"op": "remove", "path": "/users/0/admin"},
+		{"op": "add", "path": "/users/0/active", "value": true},
+		{"op": "add", "path": "/meta/count", "value": 3},
+		{"op": "copy", "from": "/users/0/name", "path": "/meta/first"},
+		{"op": "remove", "path": "/users/1"}
+	]`, marshal(t, applied))
+
+	errorCases := []struct {
+		name  string
+		patch string
+	}{
+		{"failed test", `[{"op": "test", "path": "$.missing", "value": 1}]`},
+		{"non-singular from", `[{"op": "copy", "from": "$.users[*]", "path": "$.meta.users"}]`},
+		{"missing from", `[{"op": "move", "from": "$.missing", "path": "$.meta.users"}]`},
+		{"invalid query", `[{"op": "remove", "path": "$.["}]`},
+		{"add without value", `[{"op": "add", "path": "$.meta.count"}]`},
+	}
+	for _, c := range errorCases {
+		t.Run(c.name, func(t *testing.T) {
+			_, _, err := jsonpath.QueryPatch(patchOf(t, c.patch)).Apply(decode(t, `{"users": [1, 2], "meta": {}}`))
+			var patchErr jsonpath.ErrPatch
+			assert.True(t, errors.As(err, &patchErr))
+		})
+	}
+}
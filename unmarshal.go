@@ -0,0 +1,352 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/marcfyk/go-jsonpath/internal/ast"
+	"github.com/marcfyk/go-jsonpath/internal/eval"
+	"github.com/marcfyk/go-jsonpath/internal/model"
+)
+
+// ErrInvalidUnmarshal is the error type when Unmarshal is passed a value that is not a non-nil pointer to a struct.
+type ErrInvalidUnmarshal struct {
+	Type reflect.Type
+}
+
+func (e ErrInvalidUnmarshal) Error() string {
+	return fmt.Sprintf("value to unmarshal into is not a non-nil pointer to a struct:%v", e.Type)
+}
+
+// ErrInvalidTag is the error type when a jsonpath struct tag cannot be compiled.
+type ErrInvalidTag struct {
+	// Type is the struct type of the field.
+	Type  reflect.Type
+	Field string
+	Err   error
+}
+
+func (e ErrInvalidTag) Error() string {
+	return fmt.Sprintf("invalid jsonpath tag of field:%s.%s: %v", e.Type, e.Field, e.Err)
+}
+
+func (e ErrInvalidTag) Unwrap() error {
+	return e.Err
+}
+
+// ErrMissingField is the error type when the query of a field tagged required selects no node.
+type ErrMissingField struct {
+	// Field is the path of the field from the struct passed to Unmarshal, e.g. Spec.Containers[0].Image.
+	Field string
+	Query string
+}
+
+func (e ErrMissingField) Error() string {
+	return fmt.Sprintf("required field selects no node:%s; found query:%s", e.Field, e.Query)
+}
+
+// Unmarshal decodes the JSON value of data like Decode, and stores the values
+// the jsonpath tags of the fields of the struct v points to select from it.
+// A tag is a query, followed by options separated by commas:
+//
+//	type Repo struct {
+//		Name   string   `jsonpath:"$.data.attributes.name,required"`
+//		Stars  int      `jsonpath:"$.data.attributes.stars,default=0"`
+//		Topics []string `jsonpath:"$.data.topics[*].name"`
+//		Owner  Owner    `jsonpath:"$.included[?@.type == 'user']"`
+//	}
+//
+//	type Owner struct {
+//		Login string `jsonpath:"@.attributes.login"`
+//	}
+//
+// Fields are set from the first node their query selects, converted to the type of the field as Get converts it.
+// Fields of a slice type whose query is not singular are set to a slice of every node instead.
+// Fields whose query selects no node are left as they are, unless they have an option:
+//   - required fails with ErrMissingField.
+//   - default=value sets the field to the value, which is read as JSON, or as a string if it is not JSON.
+//     It must be the last option, so the value may have commas.
+//
+// Fields whose type, or element type for slices, is a struct with jsonpath tags are set by their tags,
+// which read the node selected for the field. Queries starting with @, rather than $, are relative to that node,
+// and queries starting with $ are relative to the root of the document. Untagged struct fields
+// with jsonpath tags are set by their tags too, reading the same node as the struct they are in.
+// Other fields without a jsonpath tag, or tagged "-", are left as they are.
+//
+// Tags are compiled once per struct type. Unmarshal returns ErrInvalidTag if a tag cannot be compiled.
+func Unmarshal(data []byte, v any) error {
+	doc, err := Decode(data)
+	if err != nil {
+		return err
+	}
+	return UnmarshalValue(doc, v)
+}
+
+// UnmarshalValue stores the values the jsonpath tags of the fields of the struct v points to
+// select from doc, as Unmarshal does, e.g. for documents that are Documents or Go values.
+func UnmarshalValue(doc Value, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return ErrInvalidUnmarshal{Type: reflect.TypeOf(v)}
+	}
+	tt := tagTypeOf(rv.Elem().Type())
+	return tt.fill(rv.Elem(), doc, Node{Location: "$", Value: doc}, "")
+}
+
+// tagType is the fields of a struct type that are set by jsonpath tags.
+type tagType struct {
+	fields []tagField
+	// err is the error compiling the tags of the struct type.
+	err error
+}
+
+// tagField is a field of a struct that is set by a jsonpath tag.
+type tagField struct {
+	name  string
+	index int
+	// query is the query of the tag, or nil if the field is an untagged struct with jsonpath tags.
+	query *Query
+	// steps are the steps of the query, if it is a singular query, which Marshal writes the field to.
+	steps []eval.Step
+	// relative reports if the query is relative to the node selected for the struct.
+	relative bool
+	required bool
+	// fallback is the default value, if the field has one.
+	fallback   Value
+	hasDefault bool
+	// each reports if the field is a slice of every node the query selects.
+	each bool
+	// nested is the tagType of the struct type of the field, if it is a struct or pointer to one.
+	nested *tagType
+	// elem is the tagType of the struct type of the elements of the field, if it is a slice of them.
+	elem *tagType
+}
+
+// tagTypes are the struct types read so far.
+var tagTypes sync.Map
+
+// tagTypeOf returns the fields of the struct type, t, that are set by jsonpath tags.
+func tagTypeOf(t reflect.Type) *tagType {
+	if tt, ok := tagTypes.Load(t); ok {
+		return tt.(*tagType)
+	}
+	building := make(map[reflect.Type]*tagType)
+	buildTagType(t, building)
+	// The struct types are only stored once all of them are compiled, since the tagTypes of
+	// recursive types refer to each other, and other goroutines read stored tagTypes without locking.
+	for bt, tt := range building {
+		tagTypes.LoadOrStore(bt, tt)
+	}
+	tt, _ := tagTypes.Load(t)
+	return tt.(*tagType)
+}
+
+// buildTagType compiles the tags of the struct type, t. building are the struct types being compiled,
+// which the types of their fields may refer to, and whose tagTypes are filled once their fields are compiled.
+func buildTagType(t reflect.Type, building map[reflect.Type]*tagType) *tagType {
+	if tt, ok := tagTypes.Load(t); ok {
+		return tt.(*tagType)
+	}
+	if tt, ok := building[t]; ok {
+		return tt
+	}
+	tt := &tagType{}
+	building[t] = tt
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		tag, ok := sf.Tag.Lookup("jsonpath")
+		if tag == "-" {
+			continue
+		}
+		if !ok {
+			// Untagged structs with tags read the node of the struct they are in.
+			if sf.Type.Kind() == reflect.Struct {
+				if nested := buildTagType(sf.Type, building); len(nested.fields) > 0 || nested.err != nil {
+					tt.fields = append(tt.fields, tagField{name: sf.Name, index: i, nested: nested})
+				}
+			}
+			continue
+		}
+		f, err := parseTag(tag, sf, building)
+		if err != nil {
+			tt.err = ErrInvalidTag{Type: t, Field: sf.Name, Err: err}
+			break
+		}
+		tt.fields = append(tt.fields, f)
+	}
+	// Errors of the struct types of fields are found up front, rather than once a document has them.
+	for _, f := range tt.fields {
+		for _, nested := range []*tagType{f.nested, f.elem} {
+			if tt.err == nil && nested != nil && nested.err != nil {
+				tt.err = nested.err
+			}
+		}
+	}
+	return tt
+}
+
+// parseTag compiles the jsonpath tag of the struct field, sf.
+func parseTag(tag string, sf reflect.StructField, building map[reflect.Type]*tagType) (tagField, error) {
+	f := tagField{name: sf.Name, index: sf.Index[0]}
+	query, fallback, hasDefault := strings.Cut(tag, ",default=")
+	for {
+		rest, ok := strings.CutSuffix(query, ",required")
+		if !ok {
+			break
+		}
+		query, f.required = rest, true
+	}
+	if f.required && hasDefault {
+		return tagField{}, fmt.Errorf("field is both required and has a default")
+	}
+	compiled := query
+	if strings.HasPrefix(query, "@") {
+		compiled, f.relative = "$"+query[1:], true
+	}
+	q, err := Compile(compiled)
+	if err != nil {
+		return tagField{}, err
+	}
+	// Errors name the query as it is spelled in the tag.
+	q.query = query
+	f.query = q
+	f.steps, _ = q.program.Path()
+	if t := structType(sf.Type); t != nil {
+		f.nested = buildTagType(t, building)
+	}
+	if sf.Type.Kind() == reflect.Slice {
+		if t := structType(sf.Type.Elem()); t != nil {
+			f.elem = buildTagType(t, building)
+		}
+		f.each = !q.IsSingular()
+	}
+	if hasDefault {
+		f.hasDefault = true
+		f.fallback = fallback
+		if json.Valid([]byte(fallback)) {
+			f.fallback, _ = Decode([]byte(fallback))
+		}
+		if ok, err := convert(f.fallback, reflect.New(sf.Type).Elem()); !ok {
+			return tagField{}, fmt.Errorf("default cannot be converted to type:%s: %w", sf.Type, err)
+		}
+	}
+	return f, nil
+}
+
+// structType returns the struct type of t, if it is a struct or pointers to one.
+func structType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	return t
+}
+
+// tagged reports if the struct type, tt, is set by jsonpath tags.
+func (tt *tagType) tagged() bool {
+	return tt != nil && (len(tt.fields) > 0 || tt.err != nil)
+}
+
+// fill sets the fields of the struct, rv, from the document, root, with the node selected for the struct, n.
+// path is the path of the struct from the struct passed to Unmarshal, followed by a dot if it is not empty.
+func (tt *tagType) fill(rv reflect.Value, root Value, n Node, path string) error {
+	if tt.err != nil {
+		return tt.err
+	}
+	for _, f := range tt.fields {
+		fv := rv.Field(f.index)
+		if f.query == nil {
+			if err := f.nested.fill(fv, root, n, path+f.name+"."); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := f.set(fv, root, n, path+f.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// set sets the field, fv, from the nodes its query selects.
+func (f tagField) set(fv reflect.Value, root Value, n Node, path string) error {
+	doc := root
+	if f.relative {
+		doc = n.Value
+	}
+	nodes, err := f.query.Select(doc)
+	if err != nil {
+		return err
+	}
+	if f.relative {
+		for i := range nodes {
+			nodes[i].Location = n.Location + nodes[i].Location[1:]
+		}
+	}
+	switch {
+	case len(nodes) == 0:
+		if f.required {
+			return ErrMissingField{Field: path, Query: f.query.String()}
+		}
+		if f.hasDefault {
+			convert(f.fallback, fv)
+		}
+		return nil
+	case f.each:
+		return setElements(fv, nodes, f.elem, root, path)
+	case f.elem.tagged():
+		m := model.Of(nodes[0].Value)
+		if m.Kind() != model.Array {
+			return setNode(fv, nodes[0], nil, root, path)
+		}
+		elements := make([]Node, m.Len())
+		for i := range elements {
+			location := Location(ast.AppendIndex([]byte(nodes[0].Location), i))
+			elements[i] = Node{Location: location, Value: m.Index(i)}
+		}
+		return setElements(fv, elements, f.elem, root, path)
+	default:
+		return setNode(fv, nodes[0], f.nested, root, path)
+	}
+}
+
+// setElements sets the slice, fv, to the values of the nodes, whose struct type is elem if they are set by tags.
+func setElements(fv reflect.Value, nodes []Node, elem *tagType, root Value, path string) error {
+	s := reflect.MakeSlice(fv.Type(), len(nodes), len(nodes))
+	for i, n := range nodes {
+		if err := setNode(s.Index(i), n, elem, root, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	fv.Set(s)
+	return nil
+}
+
+// setNode sets rv to the value of the node, n, by the tags of its struct type, nested, if it is set by tags.
+func setNode(rv reflect.Value, n Node, nested *tagType, root Value, path string) error {
+	if !nested.tagged() {
+		if ok, err := convert(n.Value, rv); !ok {
+			return ErrTypeMismatch{Location: n.Location, Type: rv.Type(), Field: path, Err: err}
+		}
+		return nil
+	}
+	if model.Of(n.Value).Kind() == model.Null {
+		rv.SetZero()
+		return nil
+	}
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		rv = rv.Elem()
+	}
+	return nested.fill(rv, root, n, path+".")
+}
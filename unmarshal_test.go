@@ -0,0 +1,183 @@
+package jsonpath_test
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/marcfyk/go-jsonpath"
+	"github.com/stretchr/testify/assert"
+)
+
+const repository = `{
+	"data": {
+		"id": "42",
+		"attributes": {"name": "go-jsonpath", "stars": 1200, "archived": false},
+		"topics": [{"name": "json"}, {"name": "rfc9535"}]
+	},
+	"included": [
+		{"type": "user", "id": "7", "attributes": {"login": "ada", "email": null}},
+		{"type": "license", "id": "mit", "attributes": {"name": "MIT License"}}
+	]
+}`
+
+type owner struct {
+	Login string  `jsonpath:"@.attributes.login,required"`
+	Email *string `jsonpath:"@.attributes.email"`
+	// Repository is read from the root, rather than the node of the owner.
+	Repository string `jsonpath:"$.data.id"`
+}
+
+type topic struct {
+	Name string `jsonpath:"@.name"`
+}
+
+type counts struct {
+	Stars    int `jsonpath:"$.data.attributes.stars"`
+	Forks    int `jsonpath:"$.data.attributes.forks,default=3"`
+	Watchers int
+}
+
+type repo struct {
+	ID       string   `jsonpath:"$.data.id,required"`
+	Name     string   `jsonpath:"$.data.attributes.name"`
+	Archived bool     `jsonpath:"$.data.attributes.archived"`
+	Topics   []string `jsonpath:"$.data.topics[*].name"`
+	Owner    *owner   `jsonpath:"$.included[?@.type == 'user']"`
+	License  string   `jsonpath:"$.included[?@.type == 'license'].attributes.name,default=none"`
+	Language string   `jsonpath:"$.data.attributes.language,default=Go, probably"`
+	Labels   []string `jsonpath:"$.data.labels,default=[\"a\",\"b\"]"`
+	// Singular queries of slices of tagged structs read the elements of the array.
+	TopicList []topic `jsonpath:"$.data.topics"`
+	Resources []topic `jsonpath:"$.included[*].attributes"`
+	Counts    counts
+	Skipped   string `jsonpath:"-"`
+}
+
+func TestUnmarshal(t *testing.T) {
+	var r repo
+	r.Skipped = "kept"
+	err := jsonpath.Unmarshal([]byte(repository), &r)
+	assert.Nil(t, err)
+	assert.Equal(t, repo{
+		ID:        "42",
+		Name:      "go-jsonpath",
+		Topics:    []string{"json", "rfc9535"},
+		Owner:     &owner{Login: "ada", Repository: "42"},
+		License:   "MIT License",
+		Language:  "Go, probably",
+		Labels:    []string{"a", "b"},
+		TopicList: []topic{{Name: "json"}, {Name: "rfc9535"}},
+		Resources: []topic{{}, {Name: "MIT License"}},
+		Counts:    counts{Stars: 1200, Forks: 3},
+		Skipped:   "kept",
+	}, r)
+
+	// Documents that are already decoded are read the same.
+	var fromValue repo
+	fromValue.Skipped = "kept"
+	assert.Nil(t, jsonpath.UnmarshalValue(decode(t, repository), &fromValue))
+	assert.Equal(t, r, fromValue)
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	type missing struct {
+		Owner owner `jsonpath:"$.included[1]"`
+	}
+	err := jsonpath.Unmarshal([]byte(repository), &missing{})
+	assert.Equal(t, jsonpath.ErrMissingField{Field: "Owner.Login", Query: "@.attributes.login"}, err)
+
+	type mismatch struct {
+		Topics []topic `jsonpath:"$.data.topics"`
+		Stars  []int   `jsonpath:"$..stars"`
+		Name   int     `jsonpath:"$.data.attributes.name"`
+	}
+	err = jsonpath.Unmarshal([]byte(repository), &mismatch{})
+	var e jsonpath.ErrTypeMismatch
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, jsonpath.Location("$['data']['attributes']['name']"), e.Location)
+	assert.Equal(t, "Name", e.Field)
+
+	type nested struct {
+		Topics []struct {
+			Name int `jsonpath:"@.name"`
+		} `jsonpath:"$.data.topics[*]"`
+	}
+	err = jsonpath.Unmarshal([]byte(repository), &nested{})
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, jsonpath.Location("$['data']['topics'][0]['name']"), e.Location)
+	assert.Equal(t, "Topics[0].Name", e.Field)
+
+	type invalid struct {
+		Name string `jsonpath:"$.data[,required"`
+	}
+	err = jsonpath.Unmarshal([]byte(repository), &invalid{})
+	var tagErr jsonpath.ErrInvalidTag
+	assert.True(t, errors.As(err, &tagErr))
+	assert.Equal(t, "Name", tagErr.Field)
+	assert.Equal(t, reflect.TypeFor[invalid](), tagErr.Type)
+
+	type invalidDefault struct {
+		Stars int `jsonpath:"$.stars,default=many"`
+	}
+	assert.True(t, errors.As(jsonpath.Unmarshal([]byte(repository), &invalidDefault{}), &tagErr))
+	type invalidNested struct {
+		Inner struct {
+			Name string `jsonpath:"@.name,required,default=x"`
+		}
+	}
+	assert.True(t, errors.As(jsonpath.Unmarshal([]byte(repository), &invalidNested{}), &tagErr))
+
+	var r repo
+	assert.Equal(t, jsonpath.ErrInvalidUnmarshal{Type: reflect.TypeFor[repo]()}, jsonpath.Unmarshal([]byte(repository), r))
+	assert.Equal(t, jsonpath.ErrInvalidUnmarshal{Type: reflect.TypeFor[*repo]()}, jsonpath.Unmarshal([]byte(repository), (*repo)(nil)))
+	assert.NotNil(t, jsonpath.Unmarshal([]byte(`{`), &r))
+}
+
+// tree is a struct type that refers to itself.
+type tree struct {
+	Name     string  `jsonpath:"@.name"`
+	Children []*tree `jsonpath:"@.children"`
+}
+
+func TestUnmarshalRecursiveType(t *testing.T) {
+	var root tree
+	err := jsonpath.Unmarshal([]byte(`{"name": "a", "children": [{"name": "b", "children": [{"name": "c"}]}, {"name": "d"}]}`), &root)
+	assert.Nil(t, err)
+	assert.Equal(t, tree{Name: "a", Children: []*tree{
+		{Name: "b", Children: []*tree{{Name: "c"}}},
+		{Name: "d"},
+	}}, root)
+}
+
+type mutualA struct {
+	Name string   `jsonpath:"@.name"`
+	B    *mutualB `jsonpath:"@.b"`
+}
+
+type mutualB struct {
+	Name string   `jsonpath:"@.name"`
+	A    *mutualA `jsonpath:"@.a"`
+}
+
+func TestUnmarshalMutuallyRecursiveTypesConcurrently(t *testing.T) {
+	const doc = `{"name": "a", "b": {"name": "b", "a": {"name": "c"}}}`
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%2 == 0 {
+				var a mutualA
+				assert.Nil(t, jsonpath.Unmarshal([]byte(doc), &a))
+				assert.Equal(t, mutualA{Name: "a", B: &mutualB{Name: "b", A: &mutualA{Name: "c"}}}, a)
+				return
+			}
+			var b mutualB
+			assert.Nil(t, jsonpath.Unmarshal([]byte(doc), &b))
+			assert.Equal(t, mutualB{Name: "a", A: nil}, b)
+		}()
+	}
+	wg.Wait()
+}
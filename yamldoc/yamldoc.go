@@ -0,0 +1,286 @@
+// Package yamldoc evaluates jsonpath queries against YAML documents parsed by gopkg.in/yaml.v3,
+// so that the nodes a query selects carry their line and column in the YAML source.
+//
+// A yaml.Node tree is read as the JSON value it decodes to:
+//   - Mappings are objects, with merge keys (<<) resolved, and sequences are arrays.
+//   - Scalars are typed by their tag: !!null is null, !!bool is a boolean,
+//     !!int and !!float are numbers, and every other tag, e.g. !!str or !!timestamp, is a string.
+//   - Aliases are the node of their anchor, and documents are their content.
+//
+// The values of the selected nodes are *Node, whose Line and Column are those of the YAML node.
+// Nodes reached through an alias are the anchored node, so they have the position of the anchor.
+package yamldoc
+
+import (
+	"unsafe"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/marcfyk/go-jsonpath"
+)
+
+// Node is a yaml.Node read as a jsonpath.Document.
+//
+// The fields of the yaml.Node are promoted, except for its Kind, which is shadowed by
+// the Kind of the Document. Nodes are never copied, see New.
+type Node struct {
+	yaml.Node
+}
+
+// Tags of the YAML core schema that are read as types other than strings.
+const (
+	nullTag  = "!!null"
+	boolTag  = "!!bool"
+	intTag   = "!!int"
+	floatTag = "!!float"
+	mergeTag = "!!merge"
+)
+
+// Parse parses a YAML document into a Node.
+func Parse(data []byte) (*Node, error) {
+	var n yaml.Node
+	if err := yaml.Unmarshal(data, &n); err != nil {
+		return nil, err
+	}
+	return New(&n), nil
+}
+
+// New returns the Node of a yaml.Node.
+// The Node of a document node is the Node of its content.
+//
+// The Node is the yaml.Node itself rather than a copy, since a Node has the layout of
+// the yaml.Node it embeds. So Nodes of the same yaml.Node are the same pointer,
+// which is how cycles through aliases are detected.
+func New(n *yaml.Node) *Node {
+	if r := resolve(n); r != nil {
+		return (*Node)(unsafe.Pointer(r))
+	}
+	// Empty documents are null.
+	return &Node{yaml.Node{Kind: yaml.ScalarNode, Tag: nullTag, Value: "null", Line: n.Line, Column: n.Column}}
+}
+
+// node returns the yaml.Node that the Node resolves to.
+func (n *Node) node() *yaml.Node {
+	if n == nil {
+		return nil
+	}
+	return resolve(&n.Node)
+}
+
+// resolve returns the node that aliases and documents refer to, or nil if there is none.
+// The zero yaml.Node, which an empty input is decoded to, is an empty document.
+func resolve(n *yaml.Node) *yaml.Node {
+	for n != nil {
+		switch n.Kind {
+		case 0:
+			return nil
+		case yaml.AliasNode:
+			n = n.Alias
+		case yaml.DocumentNode:
+			if len(n.Content) == 0 {
+				return nil
+			}
+			n = n.Content[0]
+		default:
+			return n
+		}
+	}
+	return nil
+}
+
+// Kind returns the JSON type of the Node.
+func (n *Node) Kind() jsonpath.Kind {
+	y := n.node()
+	if y == nil {
+		return jsonpath.KindNull
+	}
+	switch y.Kind {
+	case yaml.MappingNode:
+		return jsonpath.KindObject
+	case yaml.SequenceNode:
+		return jsonpath.KindArray
+	case yaml.ScalarNode:
+		switch y.ShortTag() {
+		case nullTag:
+			return jsonpath.KindNull
+		case boolTag:
+			return jsonpath.KindBool
+		case intTag, floatTag:
+			return jsonpath.KindNumber
+		default:
+			return jsonpath.KindString
+		}
+	default:
+		return jsonpath.KindInvalid
+	}
+}
+
+// Len returns the number of elements of a sequence or members of a mapping.
+func (n *Node) Len() int {
+	y := n.node()
+	switch {
+	case y == nil:
+		return 0
+	case y.Kind == yaml.SequenceNode:
+		return len(y.Content)
+	case y.Kind == yaml.MappingNode:
+		n := 0
+		eachMember(y, func(string, *yaml.Node) bool {
+			n++
+			return true
+		})
+		return n
+	default:
+		return 0
+	}
+}
+
+// Index returns the Node of the element at index, i, of a sequence.
+func (n *Node) Index(i int) any {
+	y := n.node()
+	if y == nil || y.Kind != yaml.SequenceNode || i < 0 || len(y.Content) <= i {
+		return nil
+	}
+	return New(y.Content[i])
+}
+
+// Member returns the Node of the member, name, of a mapping.
+func (n *Node) Member(name string) (any, bool) {
+	y := n.node()
+	if y == nil || y.Kind != yaml.MappingNode {
+		return nil, false
+	}
+	// The members of the mapping itself take precedence over merged members,
+	// so they are looked up without resolving merge keys.
+	merges := false
+	for i := 0; i+1 < len(y.Content); i += 2 {
+		k := resolve(y.Content[i])
+		switch {
+		case k == nil || k.Kind != yaml.ScalarNode:
+		case isMerge(k):
+			merges = true
+		case k.Value == name:
+			return New(y.Content[i+1]), true
+		}
+	}
+	if !merges {
+		return nil, false
+	}
+	var value *yaml.Node
+	eachMember(y, func(n string, v *yaml.Node) bool {
+		if n == name {
+			value = v
+		}
+		return value == nil
+	})
+	if value == nil {
+		return nil, false
+	}
+	return New(value), true
+}
+
+// Members calls f with the name and Node of every member of a mapping, in the order of Keys,
+// until f returns false. It visits the members in one pass, so queries call it rather than Member.
+func (n *Node) Members(f func(name string, e any) bool) {
+	y := n.node()
+	if y == nil || y.Kind != yaml.MappingNode {
+		return
+	}
+	eachMember(y, func(name string, v *yaml.Node) bool {
+		return f(name, New(v))
+	})
+}
+
+// Keys returns the names of the members of a mapping, in the order of the document.
+// Members merged from other mappings follow the members of the mapping itself.
+func (n *Node) Keys() []string {
+	y := n.node()
+	if y == nil || y.Kind != yaml.MappingNode {
+		return nil
+	}
+	keys := make([]string, 0, len(y.Content)/2)
+	eachMember(y, func(name string, _ *yaml.Node) bool {
+		keys = append(keys, name)
+		return true
+	})
+	return keys
+}
+
+// Scalar returns the value of a scalar, as a nil, bool, int, uint64, float64 or string.
+func (n *Node) Scalar() any {
+	y := n.node()
+	if y == nil || y.Kind != yaml.ScalarNode {
+		return nil
+	}
+	switch y.ShortTag() {
+	case nullTag:
+		return nil
+	case boolTag, intTag, floatTag:
+		var v any
+		if err := y.Decode(&v); err != nil {
+			return y.Value
+		}
+		return v
+	default:
+		return y.Value
+	}
+}
+
+// isMerge reports if the key, k, of a mapping is a merge key.
+func isMerge(k *yaml.Node) bool {
+	return k.Value == "<<" && k.ShortTag() == mergeTag
+}
+
+// eachMember calls f with the members of the mapping, m, with merge keys resolved, until f returns false.
+func eachMember(m *yaml.Node, f func(name string, value *yaml.Node) bool) {
+	visitMembers(m, make(map[string]bool, len(m.Content)/2), nil, f)
+}
+
+// visitMembers calls f with the members of the mapping, m, whose names are not seen, until f returns false,
+// and reports if f never returns false. The members of a mapping take precedence over merged members,
+// and mappings merged earlier take precedence over mappings merged later. merging are the mappings being
+// merged into m, which guards against merging a mapping into itself.
+func visitMembers(m *yaml.Node, seen map[string]bool, merging map[*yaml.Node]bool, f func(name string, value *yaml.Node) bool) bool {
+	var merges []*yaml.Node
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		k, v := resolve(m.Content[i]), m.Content[i+1]
+		if k == nil || k.Kind != yaml.ScalarNode {
+			continue
+		}
+		if isMerge(k) {
+			merges = append(merges, v)
+			continue
+		}
+		if !seen[k.Value] {
+			seen[k.Value] = true
+			if !f(k.Value, v) {
+				return false
+			}
+		}
+	}
+	if len(merges) == 0 {
+		return true
+	}
+	if merging == nil {
+		merging = make(map[*yaml.Node]bool)
+	}
+	merging[m] = true
+	defer delete(merging, m)
+	for _, merge := range merges {
+		merge = resolve(merge)
+		sources := []*yaml.Node{merge}
+		if merge != nil && merge.Kind == yaml.SequenceNode {
+			sources = merge.Content
+		}
+		for _, src := range sources {
+			src = resolve(src)
+			if src == nil || src.Kind != yaml.MappingNode || merging[src] {
+				continue
+			}
+			if !visitMembers(src, seen, merging, f) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,183 @@
+package yamldoc_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/marcfyk/go-jsonpath"
+	"github.com/marcfyk/go-jsonpath/yamldoc"
+	"github.com/stretchr/testify/assert"
+)
+
+const manifests = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  labels: &labels
+    app: web
+    tier: frontend
+spec:
+  replicas: 1
+  selector:
+    matchLabels: *labels
+  template:
+    metadata:
+      labels:
+        <<: *labels
+        tier: backend
+`
+
+func parse(t *testing.T, data string) *yamldoc.Node {
+	n, err := yamldoc.Parse([]byte(data))
+	assert.Nil(t, err)
+	return n
+}
+
+func TestPositions(t *testing.T) {
+	doc := parse(t, manifests)
+	q := jsonpath.MustCompile("$[?@.replicas <= 1].replicas")
+	nodes, err := q.Select(jsonpath.Value(doc))
+	assert.Nil(t, err)
+	var lints []string
+	for _, n := range nodes {
+		y := n.Value.(*yamldoc.Node)
+		lints = append(lints, fmt.Sprintf("line %d, column %d: %s must be > 1", y.Line, y.Column, n.Location))
+	}
+	assert.Equal(t, []string{"line 9, column 13: $['spec']['replicas'] must be > 1"}, lints)
+}
+
+func TestAnchors(t *testing.T) {
+	doc := parse(t, manifests)
+	cases := []struct {
+		query    string
+		expected []any
+	}{
+		{"$.spec.selector.matchLabels.*", []any{"web", "frontend"}},
+		// Members of the mapping override merged members, and follow them in document order.
+		{"$.spec.template.metadata.labels.*", []any{"backend", "web"}},
+		{"$..[?@.app == 'web'].tier", []any{"frontend", "frontend", "backend"}},
+	}
+	indexed, err := jsonpath.Index(doc)
+	assert.Nil(t, err)
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			for _, d := range []jsonpath.Value{doc, indexed} {
+				values, err := jsonpath.MustCompile(c.query).SelectValues(d)
+				assert.Nil(t, err)
+				var scalars []any
+				for _, v := range values {
+					scalars = append(scalars, v.(*yamldoc.Node).Scalar())
+				}
+				assert.Equal(t, c.expected, scalars)
+			}
+		})
+	}
+	// Aliased nodes have the position of their anchor.
+	values, err := jsonpath.MustCompile("$.spec.selector.matchLabels.app").SelectValues(doc)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, values[0].(*yamldoc.Node).Line)
+}
+
+func TestTags(t *testing.T) {
+	doc := parse(t, `
+null: ~
+bool: yes
+true: true
+int: 0x1F
+float: 1.5e3
+string: "12"
+tagged: !!str 12
+time: 2001-12-14
+binary: !!binary aGk=
+custom: !Ref other
+empty:
+`)
+	cases := []struct {
+		name   string
+		kind   jsonpath.Kind
+		scalar any
+	}{
+		{"null", jsonpath.KindNull, nil},
+		// YAML 1.2 does not read yes as a boolean.
+		{"bool", jsonpath.KindString, "yes"},
+		{"true", jsonpath.KindBool, true},
+		{"int", jsonpath.KindNumber, 31},
+		{"float", jsonpath.KindNumber, 1500.0},
+		{"string", jsonpath.KindString, "12"},
+		{"tagged", jsonpath.KindString, "12"},
+		{"time", jsonpath.KindString, "2001-12-14"},
+		{"binary", jsonpath.KindString, "aGk="},
+		{"custom", jsonpath.KindString, "other"},
+		{"empty", jsonpath.KindNull, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			v, ok := doc.Member(c.name)
+			assert.True(t, ok)
+			n := v.(*yamldoc.Node)
+			assert.Equal(t, c.kind, n.Kind())
+			assert.Equal(t, c.scalar, n.Scalar())
+		})
+	}
+	q := jsonpath.MustCompile("$[?@ == 31 || @ == 1500 || @ == true || @ == null]")
+	values, err := q.SelectValues(doc)
+	assert.Nil(t, err)
+	assert.Len(t, values, 5)
+}
+
+func TestMembers(t *testing.T) {
+	doc := parse(t, manifests)
+	values, err := jsonpath.MustCompile("$.spec.template.metadata.labels").SelectValues(doc)
+	assert.Nil(t, err)
+	labels := values[0].(*yamldoc.Node)
+	assert.Equal(t, 2, labels.Len())
+	assert.Equal(t, []string{"tier", "app"}, labels.Keys())
+	var names []string
+	var scalars []any
+	labels.Members(func(name string, e any) bool {
+		names = append(names, name)
+		scalars = append(scalars, e.(*yamldoc.Node).Scalar())
+		return true
+	})
+	assert.Equal(t, labels.Keys(), names)
+	assert.Equal(t, []any{"backend", "web"}, scalars)
+	app, ok := labels.Member("app")
+	assert.True(t, ok)
+	assert.Equal(t, "web", app.(*yamldoc.Node).Scalar())
+	_, ok = labels.Member("missing")
+	assert.False(t, ok)
+
+	// Members of mappings without merge keys are looked up without allocating.
+	var spec strings.Builder
+	for i := range 1000 {
+		fmt.Fprintf(&spec, "k%d: %d\n", i, i)
+	}
+	large := parse(t, spec.String())
+	allocs := testing.AllocsPerRun(10, func() {
+		if _, ok := large.Member("k999"); !ok {
+			t.Fatal("member not found")
+		}
+	})
+	assert.Zero(t, allocs)
+	values, err = jsonpath.MustCompile("$.*").SelectValues(large)
+	assert.Nil(t, err)
+	assert.Len(t, values, 1000)
+}
+
+func TestEmptyDocument(t *testing.T) {
+	doc := parse(t, "")
+	assert.Equal(t, jsonpath.KindNull, doc.Kind())
+	values, err := jsonpath.MustCompile("$").SelectValues(doc)
+	assert.Nil(t, err)
+	assert.Equal(t, []jsonpath.Value{doc}, values)
+}
+
+func TestAliasCycles(t *testing.T) {
+	doc := parse(t, "a: &x [1, *x]\n")
+	values, err := jsonpath.MustCompile("$.a[1][1][1][0]").SelectValues(doc)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, values[0].(*yamldoc.Node).Scalar())
+	_, err = jsonpath.MustCompile("$..*").Select(doc)
+	assert.IsType(t, jsonpath.ErrCycle{}, err)
+}